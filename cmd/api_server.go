@@ -1,12 +1,15 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"postcode-polygons/internal"
 	"postcode-polygons/routes"
 	spatialindex "postcode-polygons/spatial-index"
+	"strings"
 	"time"
 
 	"github.com/Depado/ginprom"
@@ -22,12 +25,34 @@ import (
 	hc_config "github.com/tavsec/gin-healthcheck/config"
 )
 
-func ApiServer(zipFile string, port int, debug bool) {
-	idx, err := internal.TransientDownload(zipFile, spatialindex.NewCodePointSpatialIndex)
+// Where the API server can serve polygons from. "auto" picks the geometry
+// store if there is one, otherwise the SQLite store if there is one, otherwise
+// the pack if it exists, or otherwise the individual files.
+var POLYGON_STORES = []string{"auto", "files", "pack", "geometry", "sqlite"}
+
+// PolygonStoreConfig is where to serve polygons from: Store is one of
+// POLYGON_STORES, and the rest are the paths of each kind of store, empty if
+// there isn't one.
+type PolygonStoreConfig struct {
+	Store    string
+	Pack     string
+	Geometry string
+	SQLite   string
+}
+
+func ApiServer(zipFile string, snapshotFile string, polygons PolygonStoreConfig, port int, debug bool, reloadInterval time.Duration, adminToken string) {
+	loaded, err := loadIndex(zipFile, snapshotFile)
 	if err != nil {
 		log.Fatalf("failed to create spatial index: %v", err)
 	}
-	log.Printf("CodePoint spatial index created with %d entries", idx.Len())
+	log.Printf("CodePoint spatial index created with %d entries", loaded.Len())
+
+	idx := spatialindex.NewSwappableSpatialIndex(loaded)
+	reloader := &indexReloader{idx: idx, zipFile: zipFile, snapshotFile: snapshotFile}
+	if reloadInterval > 0 {
+		log.Printf("Reloading spatial index every %s", reloadInterval)
+		go reloader.Every(reloadInterval)
+	}
 
 	r := gin.New()
 
@@ -41,6 +66,7 @@ func ApiServer(zipFile string, port int, debug bool) {
 		gin.Recovery(),
 		gin.LoggerWithWriter(gin.DefaultWriter, "/healthz", "/metrics"),
 		prometheus.Instrument(),
+		routes.KeepUncompressedWriter(),
 		compress.Compress(),
 		cachecontrol.New(cachecontrol.CacheAssetsForeverPreset),
 		cors.Default(),
@@ -56,11 +82,29 @@ func ApiServer(zipFile string, port int, debug bool) {
 		log.Fatalf("failed to initialize healthcheck: %v", err)
 	}
 
+	// Needed for any polygon files that extract-data compressed with zstd
+	if err := internal.LoadZstdDictionary(internal.ZSTD_DICTIONARY); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("failed to load zstd dictionary: %v", err)
+	}
+
 	cache := memoize.NewMemoizer(5*time.Minute, 10*time.Minute)
-	repo := internal.NewPolygonsRepo(cache)
+	repo, err := loadPolygons(polygons, cache)
+	if err != nil {
+		log.Fatalf("failed to open polygons: %v", err)
+	}
 
 	r.GET("/v1/postcode/codepoints", routes.CodePointSearch(idx))
+	r.GET("/v1/postcode/codepoints/nearest", routes.NearestCodePoints(idx))
 	r.GET("/v1/postcode/polygons", routes.PolygonSearch(idx, repo))
+	r.GET("/v1/postcode/reverse", routes.ReverseGeocode(idx, repo))
+	r.POST("/v1/postcode/batch", routes.PostcodeBatch(idx, repo))
+	r.GET("/v1/postcode/autocomplete", routes.PostcodeAutocomplete(idx))
+	r.GET("/v1/postcode/:postcode", routes.PostcodeLookup(idx, repo))
+	r.GET("/v1/tiles/:z/:x/:y", routes.PolygonTiles(idx, repo))
+
+	if adminToken != "" {
+		r.POST("/admin/reload", routes.RequireToken(adminToken), routes.AdminReload(reloader))
+	}
 
 	addr := fmt.Sprintf(":%d", port)
 	log.Printf("Starting HTTP API Server on port %d...", port)
@@ -68,3 +112,70 @@ func ApiServer(zipFile string, port int, debug bool) {
 		log.Fatalf("HTTP API Server failed to start on port %d: %v", port, err)
 	}
 }
+
+// loadPolygons serves polygons from the configured store, resolving "auto" to
+// the store it picks.
+func loadPolygons(config PolygonStoreConfig, cache *memoize.Memoizer) (internal.PolygonsRepo, error) {
+	store := config.Store
+	auto := store == "auto"
+	for {
+		switch store {
+		case "auto":
+			switch {
+			case config.Geometry != "":
+				store = "geometry"
+			case config.SQLite != "":
+				store = "sqlite"
+			case config.Pack != "":
+				store = "pack"
+			default:
+				store = "files"
+			}
+
+		case "files":
+			log.Printf("Serving polygons from individual files")
+			return internal.NewPolygonsRepo(cache), nil
+
+		case "pack":
+			if config.Pack == "" {
+				return nil, errors.New("the pack polygon store needs --pack <path>")
+			}
+			pack, err := internal.OpenPack(config.Pack)
+			if auto && os.IsNotExist(err) {
+				log.Printf("Polygon pack %s not found, using individual polygon files", config.Pack)
+				store = "files"
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Serving polygons from pack %s", config.Pack)
+			return internal.NewPackedPolygonsRepo(pack, cache), nil
+
+		case "geometry":
+			if config.Geometry == "" {
+				return nil, errors.New("the geometry polygon store needs --geometry <path>")
+			}
+			geometry, err := internal.OpenGeometryStore(config.Geometry)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Serving polygons from geometry store %s", config.Geometry)
+			return internal.NewMappedPolygonsRepo(geometry), nil
+
+		case "sqlite":
+			if config.SQLite == "" {
+				return nil, errors.New("the sqlite polygon store needs --sqlite <path>")
+			}
+			sqlite, err := internal.OpenSQLiteStore(config.SQLite)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Serving polygons from SQLite store %s", config.SQLite)
+			return internal.NewSQLitePolygonsRepo(sqlite, cache), nil
+
+		default:
+			return nil, fmt.Errorf("unsupported polygon store '%s', must be one of %s", store, strings.Join(POLYGON_STORES, ", "))
+		}
+	}
+}
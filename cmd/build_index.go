@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"postcode-polygons/internal"
+	spatialindex "postcode-polygons/spatial-index"
+	"time"
+)
+
+func BuildIndex(zipFile string, snapshotFile string) {
+	start := time.Now()
+	idx, err := internal.TransientDownload(zipFile, spatialindex.NewCodePointSpatialIndex)
+	if err != nil {
+		log.Fatalf("failed to create spatial index: %v", err)
+	}
+
+	if err := spatialindex.WriteSnapshot(idx, snapshotFile, zipFile); err != nil {
+		log.Fatalf("failed to write spatial index snapshot: %v", err)
+	}
+	log.Printf("Wrote snapshot of %d entries to %s in %s", idx.Len(), snapshotFile, time.Since(start))
+}
+
+// loadIndex starts from the snapshot where possible, otherwise building the
+// index from the CodePoint zip and then writing a new snapshot for next time.
+// An empty snapshotFile disables snapshots altogether.
+func loadIndex(zipFile string, snapshotFile string) (spatialindex.SpatialIndex, error) {
+	if snapshotFile != "" {
+		idx, info, err := spatialindex.LoadSnapshot(snapshotFile)
+		switch {
+		case err != nil && os.IsNotExist(err):
+			log.Printf("No spatial index snapshot found at %s", snapshotFile)
+		case err != nil:
+			log.Printf("Ignoring spatial index snapshot %s: %v", snapshotFile, err)
+		case isStale(info, zipFile):
+			log.Printf("Ignoring spatial index snapshot %s: built from %s at %s, which is out of date", snapshotFile, info.Source, info.Built.Format(time.RFC3339))
+		default:
+			log.Printf("Loaded spatial index snapshot %s (built %s)", snapshotFile, info.Built.Format(time.RFC3339))
+			return idx, nil
+		}
+	}
+
+	idx, err := internal.TransientDownload(zipFile, spatialindex.NewCodePointSpatialIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshotFile != "" {
+		if err := spatialindex.WriteSnapshot(idx, snapshotFile, zipFile); err != nil {
+			log.Printf("failed to write spatial index snapshot: %v", err)
+		}
+	}
+	return idx, nil
+}
+
+// isStale reports whether a snapshot was built from a different source, or
+// from a local file that has since been replaced. Snapshots of a download
+// can't be checked without downloading it again, so are never stale however
+// old they are, and are refreshed by reloads or the build-index command
+// instead.
+func isStale(info spatialindex.SnapshotInfo, zipFile string) bool {
+	if info.Source != zipFile {
+		return true
+	}
+	stat, err := os.Stat(zipFile)
+	return err == nil && stat.ModTime().Truncate(time.Second).After(info.Built)
+}
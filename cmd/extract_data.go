@@ -8,6 +8,8 @@ import (
 	"os"
 	"path/filepath"
 	"postcode-polygons/internal"
+	"postcode-polygons/postcode"
+	"postcode-polygons/topology"
 	"strings"
 
 	"github.com/dsnet/compress/bzip2"
@@ -17,23 +19,31 @@ import (
 	"github.com/paulmach/orb/geojson"
 )
 
-func ExtractData(tarBz2File string) {
+// Amount of reprocessed GeoJSON to train the zstd dictionary on
+const ZSTD_DICTIONARY_SAMPLES = 2 << 20
 
-	f, err := os.Open(tarBz2File)
-	if err != nil {
-		log.Fatalf("Error opening file: %v", err)
-	}
-	defer func() {
-		if err := f.Close(); err != nil {
-			log.Printf("Error closing file: %v", err)
-		}
-	}()
+// What extract-data can write the polygons to, as well as the pack and
+// geometry store: only the individual files, or a SQLite store too.
+var EXTRACT_OUTPUTS = []string{"files", "sqlite"}
 
-	bz2Reader, err := bzip2.NewReader(f, &bzip2.ReaderConfig{})
-	if err != nil {
-		log.Fatalf("Error creating bzip2 reader: %v", err)
+// ExtractData unpacks the NSUL polygons, and derives the other levels and
+// simplified copies from them, compressing each file with the codec. With
+// topoJSON set, the simplified copies are also written as TopoJSON. Unless
+// packFile, geometryFile or sqliteFile are empty, all the polygons are then
+// written into a pack, geometry store or SQLite store as well.
+func ExtractData(tarBz2File string, codec *internal.Codec, topoJSON bool, packFile string, geometryFile string, sqliteFile string) {
+	err := internal.LoadZstdDictionary(internal.ZSTD_DICTIONARY)
+	switch {
+	case err == nil:
+		log.Printf("Loaded zstd dictionary %s", internal.ZSTD_DICTIONARY)
+	case !os.IsNotExist(err):
+		log.Fatalf("Error loading zstd dictionary: %v", err)
+	case codec.Name == "zstd":
+		trainZstdDictionary(tarBz2File)
 	}
-	tarReader := tar.NewReader(bz2Reader)
+
+	tarReader, closeArchive := openArchive(tarBz2File)
+	defer closeArchive()
 
 	skipped := color.New(color.FgBlue).SprintFunc()
 	successful := color.New(color.FgGreen).SprintFunc()
@@ -59,9 +69,8 @@ func ExtractData(tarBz2File string) {
 		fileType, propName := extractFileType(header)
 		if fileType != "" {
 
-			outputFile := fmt.Sprintf("./data/postcodes/%ss/%s.bz2", fileType, filepath.Base(header.Name))
-			if exists, err := os.Stat(outputFile); err == nil && !exists.IsDir() {
-				log.Printf("Skipping file %s (already exists)", skipped(outputFile))
+			outputFile := fmt.Sprintf("./data/postcodes/%ss/%s%s", fileType, filepath.Base(header.Name), codec.Extension)
+			if existingOutput(outputFile, codec) {
 				continue
 			}
 
@@ -96,6 +105,437 @@ func ExtractData(tarBz2File string) {
 			log.Printf("Skipping: %v\n", skipped(header.Name))
 		}
 	}
+
+	// Sectors and areas aren't in the archive, so are built up from the units
+	// and districts extracted above
+	dissolveLevel("sector", "units", "sectors", codec, func(district string) string { return district }, func(unit string) string {
+		return mustParse(postcode.Parse, unit).Sector()
+	})
+	areaCode := func(district string) string {
+		return mustParse(postcode.ParseOutward, district).Area()
+	}
+	dissolveLevel("area", "districts", "areas", codec, areaCode, areaCode)
+
+	simplifyLevels(codec, topoJSON)
+
+	if packFile != "" || geometryFile != "" || sqliteFile != "" {
+		storeLevels(packFile, geometryFile, sqliteFile)
+	}
+}
+
+// SimplifyData writes the simplified copies of the polygons that have already
+// been extracted, as ExtractData does last. They aren't checked in, as they
+// can be derived from the polygons that are, so are written when deploying.
+func SimplifyData(codec *internal.Codec, topoJSON bool) {
+	// Needed for any polygon files that extract-data compressed with zstd
+	if err := internal.LoadZstdDictionary(internal.ZSTD_DICTIONARY); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Error loading zstd dictionary: %v", err)
+	}
+	simplifyLevels(codec, topoJSON)
+}
+
+// simplifyLevels writes simplified copies of the polygons, so that requests
+// for low zoom levels don't need to simplify them every time. Each group of
+// files shares one topology, so that neighbouring polygons within it still
+// meet exactly: units and sectors within each district, districts within
+// each area, and all of the areas.
+func simplifyLevels(codec *internal.Codec, topoJSON bool) {
+	groups := map[string]func(string) string{
+		"units":     func(district string) string { return district },
+		"sectors":   func(district string) string { return district },
+		"districts": func(district string) string { return mustParse(postcode.ParseOutward, district).Area() },
+		"areas":     func(area string) string { return "all" },
+	}
+	for _, target := range internal.SIMPLIFIED_TARGETS {
+		for _, zoom := range internal.SIMPLIFIED_ZOOMS {
+			simplifyLevel(target, zoom, groups[target], codec, topoJSON)
+		}
+	}
+}
+
+// dissolveLevel merges the polygons from the source level into the larger
+// polygons of the target level. Features are grouped into output files by
+// fileKey (given the source file's name), and into polygons by featureID
+// (given the source feature's ID).
+func dissolveLevel(fileType string, source string, target string, codec *internal.Codec, fileKey func(string) string, featureID func(string) string) {
+	successful := color.New(color.FgGreen).SprintFunc()
+
+	err := os.MkdirAll(fmt.Sprintf("./data/postcodes/%s", target), os.ModePerm)
+	if err != nil {
+		log.Fatalf("Error creating directory for %s: %v", target, err)
+	}
+
+	inputFiles, err := internal.ListFeatureCollections(fmt.Sprintf("./data/postcodes/%s", source))
+	if err != nil {
+		log.Fatalf("Error listing %s files: %v", source, err)
+	}
+
+	grouped := make(map[string][]string)
+	keys := make([]string, 0, len(inputFiles))
+	for _, inputFile := range inputFiles {
+		key := fileKey(internal.FeatureCollectionName(inputFile))
+		if _, exists := grouped[key]; !exists {
+			keys = append(keys, key)
+		}
+		grouped[key] = append(grouped[key], inputFile)
+	}
+
+	for _, key := range keys {
+		outputFile := fmt.Sprintf("./data/postcodes/%s/%s.geojson%s", target, key, codec.Extension)
+		if existingOutput(outputFile, codec) {
+			continue
+		}
+
+		polygons := make(map[string][]orb.Polygon)
+		ids := make([]string, 0, 10)
+		for _, inputFile := range grouped[key] {
+			fc, err := internal.DecompressFeatureCollection(inputFile)
+			if err != nil {
+				log.Fatalf("Error reading file %s: %v", inputFile, err)
+			}
+
+			for _, feature := range fc.Features {
+				sourceID, ok := feature.ID.(string)
+				if !ok {
+					log.Fatalf("Missing or invalid ID for feature in %s", inputFile)
+				}
+				id := featureID(sourceID)
+				if _, exists := polygons[id]; !exists {
+					ids = append(ids, id)
+				}
+				switch geometry := feature.Geometry.(type) {
+				case orb.Polygon:
+					polygons[id] = append(polygons[id], geometry)
+				case orb.MultiPolygon:
+					polygons[id] = append(polygons[id], geometry...)
+				}
+			}
+		}
+
+		fc := geojson.NewFeatureCollection()
+		for _, id := range ids {
+			var geometry orb.Geometry = internal.Dissolve(polygons[id])
+			if multiPolygon := geometry.(orb.MultiPolygon); len(multiPolygon) == 1 {
+				geometry = multiPolygon[0]
+			}
+			feature := geojson.NewFeature(geometry)
+			feature.ID = id
+			feature.Properties["type"] = fileType
+			fc.Append(feature)
+		}
+
+		newSize, err := internal.CompressFeatureCollection(outputFile, fc)
+		if err != nil {
+			log.Fatalf("Error compressing file %s: %v", outputFile, err)
+		}
+		log.Printf("Dissolved %d %s file(s) into %s (%s)\n",
+			len(grouped[key]), source, successful(outputFile), humanize.Bytes(uint64(newSize)))
+	}
+}
+
+// simplifyLevel writes a copy of every file at the target level with the
+// polygons simplified for the zoom. Files with the same groupKey (given the
+// file's name) are simplified together.
+func simplifyLevel(target string, zoom int, groupKey func(string) string, codec *internal.Codec, topoJSON bool) {
+	successful := color.New(color.FgGreen).SprintFunc()
+
+	simplifiedTarget := internal.SimplifiedTarget(target, zoom)
+	err := os.MkdirAll(fmt.Sprintf("./data/postcodes/%s", simplifiedTarget), os.ModePerm)
+	if err != nil {
+		log.Fatalf("Error creating directory for %s: %v", simplifiedTarget, err)
+	}
+
+	inputFiles, err := internal.ListFeatureCollections(fmt.Sprintf("./data/postcodes/%s", target))
+	if err != nil {
+		log.Fatalf("Error listing %s files: %v", target, err)
+	}
+
+	grouped := make(map[string][]string)
+	keys := make([]string, 0, len(inputFiles))
+	for _, inputFile := range inputFiles {
+		key := groupKey(internal.FeatureCollectionName(inputFile))
+		if _, exists := grouped[key]; !exists {
+			keys = append(keys, key)
+		}
+		grouped[key] = append(grouped[key], inputFile)
+	}
+
+	tolerance := internal.ToleranceForZoom(zoom)
+	for _, key := range keys {
+		// Only a whole group is simplified the same way, so the group is
+		// skipped only if all of it has been written, and otherwise written
+		// again in full
+		if existingGroup(simplifiedTarget, grouped[key], codec, topoJSON) {
+			continue
+		}
+
+		// Features are simplified together, then sorted back into their files
+		combined := geojson.NewFeatureCollection()
+		sources := make(map[any]string)
+		for _, inputFile := range grouped[key] {
+			fc, err := internal.DecompressFeatureCollection(inputFile)
+			if err != nil {
+				log.Fatalf("Error reading file %s: %v", inputFile, err)
+			}
+			for _, feature := range fc.Features {
+				sources[feature.ID] = inputFile
+				combined.Append(feature)
+			}
+		}
+
+		simplified := make(map[string]*geojson.FeatureCollection, len(grouped[key]))
+		for _, inputFile := range grouped[key] {
+			simplified[inputFile] = geojson.NewFeatureCollection()
+		}
+		for _, feature := range internal.SimplifyFeatureCollection(combined, tolerance).Features {
+			simplified[sources[feature.ID]].Append(feature)
+		}
+
+		for _, inputFile := range grouped[key] {
+			name := internal.FeatureCollectionName(inputFile)
+			outputFile := fmt.Sprintf("./data/postcodes/%s/%s.geojson%s", simplifiedTarget, name, codec.Extension)
+			newSize, err := internal.CompressFeatureCollection(outputFile, simplified[inputFile])
+			if err != nil {
+				log.Fatalf("Error compressing file %s: %v", outputFile, err)
+			}
+			log.Printf("Simplified %s for zoom %d into %s (%s)\n",
+				inputFile, zoom, successful(outputFile), humanize.Bytes(uint64(newSize)))
+
+			if topoJSON {
+				topoFile := fmt.Sprintf("./data/postcodes/%s/%s.topojson%s", simplifiedTarget, name, codec.Extension)
+				topo := topology.Build(simplified[inputFile])
+				newSize, err := internal.CompressFile(topoFile, func(w io.Writer) error {
+					data, err := topo.TopoJSON(target, 0)
+					if err != nil {
+						return err
+					}
+					_, err = w.Write(data)
+					return err
+				})
+				if err != nil {
+					log.Fatalf("Error compressing file %s: %v", topoFile, err)
+				}
+				log.Printf("Wrote TopoJSON %s (%s)\n", successful(topoFile), humanize.Bytes(uint64(newSize)))
+			}
+		}
+	}
+}
+
+// existingGroup reports whether the simplified copies of every file in a group
+// have already been written, including as TopoJSON if it's wanted.
+func existingGroup(simplifiedTarget string, inputFiles []string, codec *internal.Codec, topoJSON bool) bool {
+	for _, inputFile := range inputFiles {
+		name := internal.FeatureCollectionName(inputFile)
+		if !existingOutput(fmt.Sprintf("./data/postcodes/%s/%s.geojson%s", simplifiedTarget, name, codec.Extension), codec) {
+			return false
+		}
+		if topoJSON && !existingOutput(fmt.Sprintf("./data/postcodes/%s/%s.topojson%s", simplifiedTarget, name, codec.Extension), codec) {
+			return false
+		}
+	}
+	return true
+}
+
+// polygonStore is somewhere that every polygon file can be written to at once.
+type polygonStore interface {
+	WriteFeatureCollection(target string, district string, fc *geojson.FeatureCollection) error
+	Close() error
+	Abort()
+}
+
+// storeLevels writes every level, and the simplified copies of each, into a
+// pack, a geometry store and/or a SQLite store (skipping any whose file is
+// empty). They're rebuilt from scratch each time, so always match the
+// individual files.
+func storeLevels(packFile string, geometryFile string, sqliteFile string) {
+	successful := color.New(color.FgGreen).SprintFunc()
+
+	targets := make([]string, 0, len(internal.SIMPLIFIED_TARGETS)*(len(internal.SIMPLIFIED_ZOOMS)+1))
+	for _, target := range internal.SIMPLIFIED_TARGETS {
+		targets = append(targets, target)
+		for _, zoom := range internal.SIMPLIFIED_ZOOMS {
+			targets = append(targets, internal.SimplifiedTarget(target, zoom))
+		}
+	}
+
+	stores := make(map[string]polygonStore, 3)
+	if packFile != "" {
+		pack, err := internal.CreatePack(packFile)
+		if err != nil {
+			log.Fatalf("Error creating pack %s: %v", packFile, err)
+		}
+		stores[packFile] = pack
+	}
+	if geometryFile != "" {
+		geometry, err := internal.CreateGeometryStore(geometryFile)
+		if err != nil {
+			log.Fatalf("Error creating geometry store %s: %v", geometryFile, err)
+		}
+		stores[geometryFile] = geometry
+	}
+	if sqliteFile != "" {
+		sqlite, err := internal.CreateSQLiteStore(sqliteFile)
+		if err != nil {
+			log.Fatalf("Error creating SQLite store %s: %v", sqliteFile, err)
+		}
+		stores[sqliteFile] = sqlite
+	}
+	abort := func() {
+		for _, store := range stores {
+			store.Abort()
+		}
+	}
+
+	for _, target := range targets {
+		inputFiles, err := internal.ListFeatureCollections(fmt.Sprintf("./data/postcodes/%s", target))
+		if err != nil {
+			abort()
+			log.Fatalf("Error listing %s files: %v", target, err)
+		}
+
+		for _, inputFile := range inputFiles {
+			fc, err := internal.DecompressFeatureCollection(inputFile)
+			if err != nil {
+				abort()
+				log.Fatalf("Error reading file %s: %v", inputFile, err)
+			}
+			district := internal.FeatureCollectionName(inputFile)
+			for outputFile, store := range stores {
+				if err := store.WriteFeatureCollection(target, district, fc); err != nil {
+					abort()
+					log.Fatalf("Error writing file %s to %s: %v", inputFile, outputFile, err)
+				}
+			}
+		}
+		log.Printf("Stored %d %s file(s)\n", len(inputFiles), target)
+	}
+
+	for outputFile, store := range stores {
+		if err := store.Close(); err != nil {
+			log.Fatalf("Error writing %s: %v", outputFile, err)
+		}
+		if info, err := os.Stat(outputFile); err == nil {
+			log.Printf("Wrote %s (%s)\n", successful(outputFile), humanize.Bytes(uint64(info.Size())))
+		}
+	}
+}
+
+// openArchive starts reading the NSUL tar.bz2 archive, returning a function to
+// close it again.
+func openArchive(tarBz2File string) (*tar.Reader, func()) {
+	f, err := os.Open(tarBz2File)
+	if err != nil {
+		log.Fatalf("Error opening file: %v", err)
+	}
+
+	bz2Reader, err := bzip2.NewReader(f, &bzip2.ReaderConfig{})
+	if err != nil {
+		log.Fatalf("Error creating bzip2 reader: %v", err)
+	}
+	return tar.NewReader(bz2Reader), func() {
+		if err := f.Close(); err != nil {
+			log.Printf("Error closing file: %v", err)
+		}
+	}
+}
+
+// trainZstdDictionary builds the dictionary for zstd files from the features
+// of the first few files in the archive, reprocessed as they will be written,
+// and saves it for reading the files back.
+func trainZstdDictionary(tarBz2File string) {
+	tarReader, closeArchive := openArchive(tarBz2File)
+	defer closeArchive()
+
+	var samples [][]byte
+	size := 0
+	for size < ZSTD_DICTIONARY_SAMPLES {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatalf("Error reading from tar archive: %v", err)
+		}
+
+		fileType, propName := extractFileType(header)
+		if fileType == "" {
+			continue
+		}
+		content := make([]byte, header.Size)
+		if _, err := io.ReadFull(tarReader, content); err != nil {
+			log.Fatalf("Error reading file %s: %v", header.Name, err)
+		}
+		fc, err := geojson.UnmarshalFeatureCollection(content)
+		if err != nil {
+			log.Fatalf("Error unmarshalling GeoJSON: %v", err)
+		}
+		if err := reprocessFeatureCollection(fileType, propName, fc); err != nil {
+			log.Fatalf("Error reprocessing feature collection for file %s: %v", header.Name, err)
+		}
+
+		for _, feature := range fc.Features {
+			sample, err := feature.MarshalJSON()
+			if err != nil {
+				log.Fatalf("Error marshalling feature: %v", err)
+			}
+			samples = append(samples, sample)
+			size += len(sample)
+		}
+	}
+
+	log.Printf("Training zstd dictionary on %d features (%s)", len(samples), humanize.Bytes(uint64(size)))
+	dictionary, err := internal.TrainZstdDictionary(samples)
+	if err != nil {
+		log.Fatalf("Error training zstd dictionary: %v", err)
+	}
+	if err := os.WriteFile(internal.ZSTD_DICTIONARY, dictionary, 0644); err != nil {
+		log.Fatalf("Error writing zstd dictionary: %v", err)
+	}
+	if err := internal.SetZstdDictionary(dictionary); err != nil {
+		log.Fatalf("Error setting zstd dictionary: %v", err)
+	}
+	log.Printf("Wrote zstd dictionary %s (%s)", color.GreenString(internal.ZSTD_DICTIONARY), humanize.Bytes(uint64(len(dictionary))))
+}
+
+// existingOutput reports whether a file has already been written, so can be
+// skipped. If it was written with a different codec, it's recompressed with
+// this one rather than being reprocessed.
+func existingOutput(outputFile string, codec *internal.Codec) bool {
+	skipped := color.New(color.FgBlue).SprintFunc()
+	successful := color.New(color.FgGreen).SprintFunc()
+
+	if exists, err := os.Stat(outputFile); err == nil && !exists.IsDir() {
+		log.Printf("Skipping file %s (already exists)", skipped(outputFile))
+		return true
+	}
+
+	base := strings.TrimSuffix(outputFile, codec.Extension)
+	for _, other := range internal.CODECS {
+		otherFile := base + other.Extension
+		if other == codec {
+			continue
+		} else if exists, err := os.Stat(otherFile); err != nil || exists.IsDir() {
+			continue
+		}
+
+		newSize, err := internal.RecompressFile(otherFile, outputFile)
+		if err != nil {
+			log.Fatalf("Error recompressing file %s: %v", otherFile, err)
+		}
+		if err := os.Remove(otherFile); err != nil {
+			log.Fatalf("Error removing file %s: %v", otherFile, err)
+		}
+		log.Printf("Recompressed file %s as %s (%s)\n", otherFile, successful(outputFile), humanize.Bytes(uint64(newSize)))
+		return true
+	}
+	return false
+}
+
+func mustParse(parse func(string) (postcode.Postcode, error), code string) postcode.Postcode {
+	parsed, err := parse(code)
+	if err != nil {
+		log.Fatalf("Error parsing postcode: %v", err)
+	}
+	return parsed
 }
 
 func extractFileType(header *tar.Header) (string, string) {
@@ -118,6 +558,14 @@ func reprocessFeatureCollection(fileType string, propName string, fc *geojson.Fe
 			return fmt.Errorf("missing or invalid '%s' property for postcode %s: %s", propName, fileType, id)
 		}
 
+		if fileType == "unit" {
+			normalised, err := postcode.Normalise(id)
+			if err != nil {
+				return err
+			}
+			id = normalised
+		}
+
 		feature.ID = id
 		feature.Properties["type"] = fileType
 		truncateCoordinates(feature)
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"postcode-polygons/internal"
+	spatialindex "postcode-polygons/spatial-index"
+	"sync"
+	"time"
+)
+
+// A reload is rejected if the new index is less than this fraction of the size
+// of the one being served, as it most likely comes from a truncated download.
+const MIN_RELOAD_RATIO = 0.9
+
+// indexReloader rebuilds the spatial index from the CodePoint source and swaps
+// it in, leaving the old index serving requests until the new one is ready.
+type indexReloader struct {
+	idx          *spatialindex.SwappableSpatialIndex
+	zipFile      string
+	snapshotFile string
+	mu           sync.Mutex
+}
+
+// Start begins a reload in the background, returning false if there's
+// already one in progress.
+func (r *indexReloader) Start() bool {
+	if !r.mu.TryLock() {
+		return false
+	}
+	go func() {
+		defer r.mu.Unlock()
+		if err := r.reload(); err != nil {
+			log.Printf("failed to reload spatial index: %v", err)
+		}
+	}()
+	return true
+}
+
+// Every reloads the index at the given interval, skipping any that would
+// overlap an admin-triggered reload.
+func (r *indexReloader) Every(interval time.Duration) {
+	for range time.Tick(interval) {
+		if !r.Start() {
+			log.Printf("Skipping scheduled spatial index reload, one is already in progress")
+		}
+	}
+}
+
+func (r *indexReloader) reload() error {
+	start := time.Now()
+	log.Printf("Reloading spatial index from %s", r.zipFile)
+
+	idx, err := internal.TransientDownload(r.zipFile, spatialindex.NewCodePointSpatialIndex)
+	if err != nil {
+		return err
+	}
+
+	current := r.idx.Current().Len()
+	if float64(idx.Len()) < float64(current)*MIN_RELOAD_RATIO {
+		return fmt.Errorf("new index has %d entries, compared to %d currently, keeping the current index", idx.Len(), current)
+	}
+
+	r.idx.Swap(idx)
+	log.Printf("Reloaded spatial index with %d entries (previously %d) in %s", idx.Len(), current, time.Since(start))
+
+	if r.snapshotFile != "" {
+		if err := spatialindex.WriteSnapshot(idx, r.snapshotFile, r.zipFile); err != nil {
+			log.Printf("failed to write spatial index snapshot: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,474 @@
+package flatgeobuf
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// A minimal encoder for the FlatGeobuf format (v3), which only needs to deal
+// with polygon features in WGS84. Features are written in Hilbert curve order
+// after a packed R-tree index, so clients can read just the features in the
+// area they need as the file streams in. See
+// https://github.com/flatgeobuf/flatgeobuf/tree/master/src/fbs
+
+const MIME_TYPE = "application/flatgeobuf"
+
+const NODE_SIZE = 16 // Number of children of each node of the index
+
+var magic = []byte{'f', 'g', 'b', 3, 'f', 'g', 'b', 0}
+
+// GeometryType values from header.fbs
+const (
+	geometryUnknown      = 0
+	geometryPolygon      = 3
+	geometryMultiPolygon = 6
+)
+
+// ColumnType values from header.fbs
+const (
+	columnBool   = 2
+	columnDouble = 10
+	columnString = 11
+	columnJSON   = 12
+)
+
+// Numbers of fields in each table, and the slots of the fields that are used
+const (
+	headerFields        = 14
+	headerName          = 0
+	headerEnvelope      = 1
+	headerGeometryType  = 2
+	headerColumns       = 7
+	headerFeaturesCount = 8
+	headerIndexNodeSize = 9
+	headerCrs           = 10
+	crsFields           = 6
+	crsOrg              = 0
+	crsCode             = 1
+	columnFields        = 11
+	columnName          = 0
+	columnType          = 1
+	geometryFields      = 8
+	geometryEnds        = 0
+	geometryXY          = 1
+	geometryType        = 6
+	geometryParts       = 7
+	featureFields       = 3
+	featureGeometry     = 0
+	featureProperties   = 1
+)
+
+const defaultIndexNodeSize = 16
+const nodeItemSize = 40 // minX, minY, maxX, maxY and offset, 8 bytes each
+const hilbertMax = (1 << 16) - 1
+
+type column struct {
+	name  string
+	ctype byte
+}
+
+// Encode writes the polygon and multipolygon features as a FlatGeobuf file,
+// with the given name. Each feature's ID is carried in an "id" column (unless
+// the features already have an "id" property), alongside columns for all of
+// their properties.
+func Encode(name string, fc *geojson.FeatureCollection) ([]byte, error) {
+	features := make([]*geojson.Feature, 0, len(fc.Features))
+	for _, feature := range fc.Features {
+		switch feature.Geometry.(type) {
+		case orb.Polygon, orb.MultiPolygon:
+			features = append(features, feature)
+		}
+	}
+
+	columns := columnsOf(features)
+	bounds := make([]orb.Bound, len(features))
+	var extent orb.Bound
+	for i, feature := range features {
+		bounds[i] = feature.Geometry.Bound()
+		if i == 0 {
+			extent = bounds[i]
+		} else {
+			extent = extent.Union(bounds[i])
+		}
+	}
+
+	// The index needs the features in the order of their leaves, which are
+	// sorted along a Hilbert curve so that nearby features share nodes
+	order := make([]int, len(features))
+	values := make([]uint32, len(features))
+	for i := range features {
+		order[i] = i
+		values[i] = hilbert(bounds[i].Center(), extent)
+	}
+	sort.SliceStable(order, func(a, b int) bool { return values[order[a]] < values[order[b]] })
+
+	encoded := make([][]byte, len(features))
+	sorted := make([]orb.Bound, len(features))
+	offsets := make([]uint64, len(features))
+	var offset uint64
+	for i, j := range order {
+		data, err := encodeFeature(features[j], columns)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding feature %v: %w", features[j].ID, err)
+		}
+		encoded[i], sorted[i], offsets[i] = data, bounds[j], offset
+		offset += uint64(len(data))
+	}
+
+	out := slices.Clone(magic)
+	out = append(out, encodeHeader(name, extent, geometryTypeOf(features), columns, len(features))...)
+	if len(features) > 0 {
+		out = append(out, index(sorted, offsets)...)
+	}
+	for _, data := range encoded {
+		out = append(out, data...)
+	}
+	return out, nil
+}
+
+// columnsOf works out a column for each property, typed by the values it
+// has. Properties with a mix of types, or which are objects or arrays, are
+// stored as JSON.
+func columnsOf(features []*geojson.Feature) []column {
+	types := make(map[string]byte)
+	hasID := false
+	for _, feature := range features {
+		hasID = hasID || feature.ID != nil
+		for key, value := range feature.Properties {
+			ctype, ok := typeOf(value)
+			if !ok {
+				continue
+			}
+			if existing, found := types[key]; found && existing != ctype {
+				ctype = columnJSON
+			}
+			types[key] = ctype
+		}
+	}
+
+	var columns []column
+	if _, found := types["id"]; hasID && !found {
+		columns = append(columns, column{name: "id", ctype: columnString})
+	}
+	keys := make([]string, 0, len(types))
+	for key := range types {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		columns = append(columns, column{name: key, ctype: types[key]})
+	}
+	return columns
+}
+
+func typeOf(value any) (byte, bool) {
+	switch value.(type) {
+	case nil:
+		return 0, false
+	case string:
+		return columnString, true
+	case float64, int:
+		return columnDouble, true
+	case bool:
+		return columnBool, true
+	default:
+		return columnJSON, true
+	}
+}
+
+// geometryTypeOf returns the type of all the features' geometries, or
+// unknown if they're a mix of polygons and multipolygons.
+func geometryTypeOf(features []*geojson.Feature) byte {
+	gtype := byte(geometryUnknown)
+	for i, feature := range features {
+		t := byte(geometryPolygon)
+		if _, ok := feature.Geometry.(orb.MultiPolygon); ok {
+			t = geometryMultiPolygon
+		}
+		if i > 0 && t != gtype {
+			return geometryUnknown
+		}
+		gtype = t
+	}
+	return gtype
+}
+
+func encodeHeader(name string, extent orb.Bound, gtype byte, columns []column, count int) []byte {
+	b := flatbuffers.NewBuilder(1024)
+
+	nameOffset := b.CreateString(name)
+	envelope := float64Vector(b, []float64{extent.Min.X(), extent.Min.Y(), extent.Max.X(), extent.Max.Y()})
+
+	columnOffsets := make([]flatbuffers.UOffsetT, len(columns))
+	for i, col := range columns {
+		colName := b.CreateString(col.name)
+		b.StartObject(columnFields)
+		b.PrependUOffsetTSlot(columnName, colName, 0)
+		b.PrependByteSlot(columnType, col.ctype, 0)
+		columnOffsets[i] = b.EndObject()
+	}
+	columnsVector := b.CreateVectorOfTables(columnOffsets)
+
+	org := b.CreateString("EPSG")
+	b.StartObject(crsFields)
+	b.PrependUOffsetTSlot(crsOrg, org, 0)
+	b.PrependInt32Slot(crsCode, 4326, 0)
+	crs := b.EndObject()
+
+	nodeSize := uint16(NODE_SIZE)
+	if count == 0 {
+		nodeSize = 0 // No index
+	}
+
+	b.StartObject(headerFields)
+	b.PrependUOffsetTSlot(headerName, nameOffset, 0)
+	b.PrependUOffsetTSlot(headerEnvelope, envelope, 0)
+	b.PrependByteSlot(headerGeometryType, gtype, geometryUnknown)
+	b.PrependUOffsetTSlot(headerColumns, columnsVector, 0)
+	b.PrependUint64Slot(headerFeaturesCount, uint64(count), 0)
+	b.PrependUint16Slot(headerIndexNodeSize, nodeSize, defaultIndexNodeSize)
+	b.PrependUOffsetTSlot(headerCrs, crs, 0)
+	b.FinishSizePrefixed(b.EndObject())
+	return b.FinishedBytes()
+}
+
+func encodeFeature(feature *geojson.Feature, columns []column) ([]byte, error) {
+	b := flatbuffers.NewBuilder(1024)
+
+	properties, err := encodeProperties(feature, columns)
+	if err != nil {
+		return nil, err
+	}
+	propertiesVector := b.CreateByteVector(properties)
+
+	var geometry flatbuffers.UOffsetT
+	switch g := feature.Geometry.(type) {
+	case orb.Polygon:
+		geometry = encodePolygon(b, g)
+	case orb.MultiPolygon:
+		parts := make([]flatbuffers.UOffsetT, len(g))
+		for i, polygon := range g {
+			parts[i] = encodePolygon(b, polygon)
+		}
+		partsVector := b.CreateVectorOfTables(parts)
+		b.StartObject(geometryFields)
+		b.PrependUOffsetTSlot(geometryParts, partsVector, 0)
+		b.PrependByteSlot(geometryType, geometryMultiPolygon, geometryUnknown)
+		geometry = b.EndObject()
+	}
+
+	b.StartObject(featureFields)
+	b.PrependUOffsetTSlot(featureGeometry, geometry, 0)
+	b.PrependUOffsetTSlot(featureProperties, propertiesVector, 0)
+	b.FinishSizePrefixed(b.EndObject())
+	return b.FinishedBytes(), nil
+}
+
+// encodePolygon writes the coordinates of all the rings one after another,
+// with the number of points up to the end of each ring if there's more than
+// one.
+func encodePolygon(b *flatbuffers.Builder, polygon orb.Polygon) flatbuffers.UOffsetT {
+	var xy []float64
+	var ends []uint32
+	for _, ring := range polygon {
+		for _, point := range ring {
+			xy = append(xy, point.X(), point.Y())
+		}
+		ends = append(ends, uint32(len(xy)/2))
+	}
+
+	xyVector := float64Vector(b, xy)
+	var endsVector flatbuffers.UOffsetT
+	if len(ends) > 1 {
+		b.StartVector(4, len(ends), 4)
+		for i := len(ends) - 1; i >= 0; i-- {
+			b.PrependUint32(ends[i])
+		}
+		endsVector = b.EndVector(len(ends))
+	}
+
+	b.StartObject(geometryFields)
+	if endsVector != 0 {
+		b.PrependUOffsetTSlot(geometryEnds, endsVector, 0)
+	}
+	b.PrependUOffsetTSlot(geometryXY, xyVector, 0)
+	b.PrependByteSlot(geometryType, geometryPolygon, geometryUnknown)
+	return b.EndObject()
+}
+
+// encodeProperties writes each of the feature's values as the index of its
+// column followed by the value.
+func encodeProperties(feature *geojson.Feature, columns []column) ([]byte, error) {
+	var out []byte
+	for i, col := range columns {
+		value, found := feature.Properties[col.name]
+		if !found && col.name == "id" {
+			value, found = feature.ID, feature.ID != nil
+		}
+		if !found || value == nil {
+			continue
+		}
+
+		out = binary.LittleEndian.AppendUint16(out, uint16(i))
+		switch col.ctype {
+		case columnString:
+			out = appendString(out, fmt.Sprint(value))
+		case columnDouble:
+			number, ok := value.(float64)
+			if !ok {
+				number = float64(value.(int))
+			}
+			out = binary.LittleEndian.AppendUint64(out, math.Float64bits(number))
+		case columnBool:
+			if value.(bool) {
+				out = append(out, 1)
+			} else {
+				out = append(out, 0)
+			}
+		default:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			out = appendString(out, string(data))
+		}
+	}
+	return out, nil
+}
+
+func appendString(out []byte, s string) []byte {
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(s)))
+	return append(out, s...)
+}
+
+func float64Vector(b *flatbuffers.Builder, values []float64) flatbuffers.UOffsetT {
+	b.StartVector(8, len(values), 8)
+	for i := len(values) - 1; i >= 0; i-- {
+		b.PrependFloat64(values[i])
+	}
+	return b.EndVector(len(values))
+}
+
+type node struct {
+	bound  orb.Bound
+	offset uint64 // Byte offset of a leaf's feature, or index of a node's first child
+}
+
+// index builds the packed R-tree over the bounds of the features, given in
+// the order they're written. The nodes are stored a level at a time, from
+// the root down to the leaves.
+func index(bounds []orb.Bound, offsets []uint64) []byte {
+	levels := levelBounds(len(bounds))
+	nodes := make([]node, levels[0][1])
+
+	leaves := levels[0][0]
+	for i, bound := range bounds {
+		nodes[leaves+i] = node{bound: bound, offset: offsets[i]}
+	}
+	for level := 0; level < len(levels)-1; level++ {
+		parent := levels[level+1][0]
+		for first := levels[level][0]; first < levels[level][1]; first += NODE_SIZE {
+			n := node{bound: nodes[first].bound, offset: uint64(first)}
+			for child := first + 1; child < min(first+NODE_SIZE, levels[level][1]); child++ {
+				n.bound = n.bound.Union(nodes[child].bound)
+			}
+			nodes[parent] = n
+			parent++
+		}
+	}
+
+	out := make([]byte, 0, len(nodes)*nodeItemSize)
+	for _, n := range nodes {
+		for _, value := range []float64{n.bound.Min.X(), n.bound.Min.Y(), n.bound.Max.X(), n.bound.Max.Y()} {
+			out = binary.LittleEndian.AppendUint64(out, math.Float64bits(value))
+		}
+		out = binary.LittleEndian.AppendUint64(out, n.offset)
+	}
+	return out
+}
+
+// levelBounds returns the start and end positions of the nodes in each level
+// of the tree, from the leaves up to the root.
+func levelBounds(count int) [][2]int {
+	sizes := []int{count}
+	total := count
+	for n := count; ; {
+		n = (n + NODE_SIZE - 1) / NODE_SIZE
+		sizes = append(sizes, n)
+		total += n
+		if n == 1 {
+			break
+		}
+	}
+
+	levels := make([][2]int, len(sizes))
+	end := total
+	for i, size := range sizes {
+		levels[i] = [2]int{end - size, end}
+		end -= size
+	}
+	return levels
+}
+
+// hilbert returns the position of the point along a Hilbert curve filling
+// the extent. See https://github.com/rawrunprotected/hilbert_curves
+func hilbert(point orb.Point, extent orb.Bound) uint32 {
+	var x, y uint32
+	if width := extent.Max.X() - extent.Min.X(); width > 0 {
+		x = uint32(math.Floor(hilbertMax * (point.X() - extent.Min.X()) / width))
+	}
+	if height := extent.Max.Y() - extent.Min.Y(); height > 0 {
+		y = uint32(math.Floor(hilbertMax * (point.Y() - extent.Min.Y()) / height))
+	}
+
+	a := x ^ y
+	b := 0xFFFF ^ a
+	c := 0xFFFF ^ (x | y)
+	d := x & (y ^ 0xFFFF)
+
+	A := a | (b >> 1)
+	B := (a >> 1) ^ a
+	C := ((c >> 1) ^ (b & (d >> 1))) ^ c
+	D := ((a & (c >> 1)) ^ (d >> 1)) ^ d
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 2)) ^ (b & (b >> 2))
+	B = (a & (b >> 2)) ^ (b & ((a ^ b) >> 2))
+	C ^= (a & (c >> 2)) ^ (b & (d >> 2))
+	D ^= (b & (c >> 2)) ^ ((a ^ b) & (d >> 2))
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 4)) ^ (b & (b >> 4))
+	B = (a & (b >> 4)) ^ (b & ((a ^ b) >> 4))
+	C ^= (a & (c >> 4)) ^ (b & (d >> 4))
+	D ^= (b & (c >> 4)) ^ ((a ^ b) & (d >> 4))
+
+	a, b, c, d = A, B, C, D
+	C ^= (a & (c >> 8)) ^ (b & (d >> 8))
+	D ^= (b & (c >> 8)) ^ ((a ^ b) & (d >> 8))
+
+	a = C ^ (C >> 1)
+	b = D ^ (D >> 1)
+
+	i0 := x ^ y
+	i1 := b | (0xFFFF ^ (i0 | a))
+
+	return (interleave(i1) << 1) | interleave(i0)
+}
+
+// interleave spreads out the lower 16 bits of x into the even bits.
+func interleave(x uint32) uint32 {
+	x = (x | (x << 8)) & 0x00FF00FF
+	x = (x | (x << 4)) & 0x0F0F0F0F
+	x = (x | (x << 2)) & 0x33333333
+	x = (x | (x << 1)) & 0x55555555
+	return x
+}
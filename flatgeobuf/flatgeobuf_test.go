@@ -0,0 +1,142 @@
+package flatgeobuf
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+// table reads the size-prefixed table at the start of data, returning it and
+// the rest of the data after it.
+func table(t *testing.T, data []byte) (*flatbuffers.Table, []byte) {
+	size := binary.LittleEndian.Uint32(data)
+	require.LessOrEqual(t, int(size)+4, len(data))
+	buf := data[4 : 4+size]
+	return &flatbuffers.Table{Bytes: buf, Pos: flatbuffers.GetUOffsetT(buf)}, data[4+size:]
+}
+
+// field returns the position of a field's value, or 0 if it isn't present.
+func field(tab *flatbuffers.Table, slot int) flatbuffers.UOffsetT {
+	if o := flatbuffers.UOffsetT(tab.Offset(flatbuffers.VOffsetT(4 + 2*slot))); o != 0 {
+		return tab.Pos + o
+	}
+	return 0
+}
+
+func float64s(tab *flatbuffers.Table, slot int) []float64 {
+	pos := field(tab, slot)
+	if pos == 0 {
+		return nil
+	}
+	start, n := tab.Vector(pos-tab.Pos), tab.VectorLen(pos-tab.Pos)
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = tab.GetFloat64(start + flatbuffers.UOffsetT(8*i))
+	}
+	return values
+}
+
+func squares(n int) *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+	for i := 0; i < n; i++ {
+		x, y := float64(i%10), float64(i/10)
+		feature := geojson.NewFeature(orb.Polygon{{{x, y}, {x + 1, y}, {x + 1, y + 1}, {x, y + 1}, {x, y}}})
+		feature.ID = "TR26 1AB"
+		feature.Properties["type"] = "unit"
+		feature.Properties["count"] = float64(i)
+		fc.Append(feature)
+	}
+	return fc
+}
+
+func TestEncode(t *testing.T) {
+	fc := squares(20)
+	island := orb.Polygon{{{20, 20}, {21, 20}, {21, 21}, {20, 21}, {20, 20}}}
+	fc.Features[3].Geometry = orb.MultiPolygon{fc.Features[3].Geometry.(orb.Polygon), island}
+	fc.Append(geojson.NewFeature(orb.Point{1, 2})) // Not polygonal, so left out
+
+	data, err := Encode("units", fc)
+	require.NoError(t, err)
+	require.Equal(t, magic, data[:8])
+
+	header, rest := table(t, data[8:])
+	require.Equal(t, "units", string(header.ByteVector(field(header, headerName))))
+	require.Equal(t, []float64{0, 0, 21, 21}, float64s(header, headerEnvelope))
+	require.Zero(t, field(header, headerGeometryType)) // The default, i.e. unknown as the types are mixed
+	require.Equal(t, uint64(20), header.GetUint64(field(header, headerFeaturesCount)))
+	require.Zero(t, field(header, headerIndexNodeSize)) // The default, i.e. 16
+
+	columns := field(header, headerColumns)
+	require.Equal(t, 3, header.VectorLen(columns-header.Pos))
+	var names []string
+	for i := 0; i < 3; i++ {
+		col := &flatbuffers.Table{Bytes: header.Bytes}
+		header.Union(col, header.Vector(columns-header.Pos)+flatbuffers.UOffsetT(4*i)-header.Pos)
+		names = append(names, string(col.ByteVector(field(col, columnName))))
+	}
+	require.Equal(t, []string{"id", "count", "type"}, names)
+
+	// 20 leaves, then 2 nodes above them, then the root
+	require.GreaterOrEqual(t, len(rest), 23*nodeItemSize)
+	node := func(i int) ([]float64, uint64) {
+		item := rest[i*nodeItemSize:]
+		var bound []float64
+		for j := 0; j < 4; j++ {
+			bound = append(bound, math.Float64frombits(binary.LittleEndian.Uint64(item[8*j:])))
+		}
+		return bound, binary.LittleEndian.Uint64(item[32:])
+	}
+	root, first := node(0)
+	require.Equal(t, []float64{0, 0, 21, 21}, root)
+	require.Equal(t, uint64(1), first)
+
+	// Each leaf points to its feature, which has the same bounds
+	features := rest[23*nodeItemSize:]
+	for i := 3; i < 23; i++ {
+		bound, offset := node(i)
+		feature, _ := table(t, features[offset:])
+		geometry := &flatbuffers.Table{}
+		feature.Union(geometry, field(feature, featureGeometry)-feature.Pos)
+
+		xy := float64s(geometry, geometryXY)
+		if parts := field(geometry, geometryParts); parts != 0 {
+			part := &flatbuffers.Table{Bytes: geometry.Bytes}
+			geometry.Union(part, geometry.Vector(parts-geometry.Pos)-geometry.Pos)
+			xy = float64s(part, geometryXY)
+			bound = bound[:2]
+		}
+		require.Subset(t, xy, bound)
+	}
+}
+
+func TestEncode_Empty(t *testing.T) {
+	data, err := Encode("units", geojson.NewFeatureCollection())
+	require.NoError(t, err)
+
+	header, rest := table(t, data[8:])
+	require.Empty(t, rest)
+	require.Zero(t, header.GetUint16(field(header, headerIndexNodeSize)))
+}
+
+func TestLevelBounds(t *testing.T) {
+	require.Equal(t, [][2]int{{1, 2}, {0, 1}}, levelBounds(1))
+	require.Equal(t, [][2]int{{1, 17}, {0, 1}}, levelBounds(16))
+	require.Equal(t, [][2]int{{3, 23}, {1, 3}, {0, 1}}, levelBounds(20))
+}
+
+func TestHilbert(t *testing.T) {
+	extent := orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{1, 1}}
+
+	// The curve starts in one corner and ends in the adjacent one, visiting the
+	// quadrants in order
+	require.Equal(t, uint32(0), hilbert(orb.Point{0, 0}, extent))
+	quadrants := []orb.Point{{0.25, 0.25}, {0.25, 0.75}, {0.75, 0.75}, {0.75, 0.25}}
+	for i := 1; i < len(quadrants); i++ {
+		require.Less(t, hilbert(quadrants[i-1], extent), hilbert(quadrants[i], extent))
+	}
+}
@@ -0,0 +1,87 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/paulmach/orb/encoding/wkt"
+	"github.com/paulmach/orb/geojson"
+)
+
+const CSV_MIME_TYPE = "text/csv"
+
+// EncodeCSV writes the features as a table with a row for each feature, for
+// loading into spreadsheets. The columns are each feature's ID (unless the
+// features have an "id" property), its properties, and its geometry as WKT.
+func EncodeCSV(name string, fc *geojson.FeatureCollection) ([]byte, error) {
+	keys := make(map[string]struct{})
+	hasID := false
+	for _, feature := range fc.Features {
+		hasID = hasID || feature.ID != nil
+		for key := range feature.Properties {
+			keys[key] = struct{}{}
+		}
+	}
+
+	properties := make([]string, 0, len(keys))
+	for key := range keys {
+		properties = append(properties, key)
+	}
+	sort.Strings(properties)
+
+	var columns []string
+	if _, found := keys["id"]; hasID && !found {
+		columns = append(columns, "id")
+	}
+	columns = append(columns, properties...)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(append(columns, "wkt")); err != nil {
+		return nil, err
+	}
+	for _, feature := range fc.Features {
+		record := make([]string, 0, len(columns)+1)
+		for _, column := range columns {
+			value, found := feature.Properties[column]
+			if !found && column == "id" {
+				value = feature.ID
+			}
+			text, err := formatValue(value)
+			if err != nil {
+				return nil, err
+			}
+			record = append(record, text)
+		}
+		record = append(record, wkt.MarshalString(feature.Geometry))
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// formatValue writes a property value as text, with objects and arrays as
+// JSON.
+func formatValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		data, err := json.Marshal(v)
+		return string(data), err
+	}
+}
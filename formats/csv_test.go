@@ -0,0 +1,15 @@
+package formats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeCSV(t *testing.T) {
+	data, err := EncodeCSV("units", features())
+	require.NoError(t, err)
+	require.Equal(t, "id,easting,tags,type,wkt\n"+
+		"TR26 1AB,,,unit,\"POLYGON((0 0,1 0,1 1,0 1,0 0),(0.2 0.2,0.2 0.4,0.4 0.4,0.2 0.2))\"\n"+
+		"TR26 1AB,151000,\"[\"\"a\"\",\"\"b\"\"]\",,POINT(0.5 0.5)\n", string(data))
+}
@@ -0,0 +1,93 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+	"postcode-polygons/flatgeobuf"
+	"postcode-polygons/geopackage"
+	"postcode-polygons/topology"
+	"strings"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+// The formats that search results can be returned in. Each is registered
+// under a name, so the handlers can offer every format that suits their
+// results without knowing about any of them in particular.
+
+const GEOJSON = "geojson"
+const GEOJSON_MIME_TYPE = "application/geo+json"
+
+const TOPOJSON_QUANTIZATION = 100_000 // Finer than 1m across the widest (areas) search
+
+// Encoder writes the features as a single collection (i.e. a layer, table or
+// document) with the given name.
+type Encoder func(name string, fc *geojson.FeatureCollection) ([]byte, error)
+
+// FeatureWriter writes features as they're found, so that a response can be
+// sent without holding all of its features in memory, and clients can start
+// on the first features sooner. Close finishes off the response.
+type FeatureWriter interface {
+	Write(features ...*geojson.Feature) error
+	Close() error
+}
+
+// StreamEncoder starts writing a collection with the given name.
+type StreamEncoder func(w io.Writer, name string) FeatureWriter
+
+type Format struct {
+	Name         string // As given in the format parameter
+	MimeType     string // As given in the Accept and Content-Type headers
+	Extension    string // For formats that are mostly used as files, which are sent as downloads
+	PolygonsOnly bool   // Whether the format can only encode polygons, rather than points as well
+	Encode       Encoder
+	Stream       StreamEncoder // For formats that can be written a feature at a time
+}
+
+var registry []*Format
+
+func init() {
+	Register(&Format{Name: GEOJSON, MimeType: GEOJSON_MIME_TYPE, Encode: encodeGeoJSON, Stream: newGeoJSONWriter})
+	Register(&Format{Name: GEOJSON_SEQ, MimeType: GEOJSON_SEQ_MIME_TYPE, Encode: encodeStream(newGeoJSONSeqWriter), Stream: newGeoJSONSeqWriter})
+	Register(&Format{Name: "topojson", MimeType: topology.MIME_TYPE, PolygonsOnly: true, Encode: encodeTopoJSON})
+	Register(&Format{Name: "flatgeobuf", MimeType: flatgeobuf.MIME_TYPE, PolygonsOnly: true, Encode: flatgeobuf.Encode})
+	Register(&Format{Name: "geopackage", MimeType: geopackage.MIME_TYPE, Extension: "gpkg", PolygonsOnly: true, Encode: geopackage.Encode})
+	Register(&Format{Name: "csv", MimeType: CSV_MIME_TYPE, Extension: "csv", Encode: EncodeCSV})
+	Register(&Format{Name: "wkt", MimeType: WKT_MIME_TYPE, Encode: EncodeWKT})
+	Register(&Format{Name: "kml", MimeType: KML_MIME_TYPE, Extension: "kml", Encode: EncodeKML})
+}
+
+// Register adds a format, which is then offered by every handler that returns
+// results it can encode. Formats are offered in the order they're registered.
+func Register(format *Format) {
+	if _, found := Lookup(format.Name); found {
+		panic(fmt.Sprintf("format '%s' is already registered", format.Name))
+	}
+	registry = append(registry, format)
+}
+
+// Lookup finds a registered format by name, regardless of case.
+func Lookup(name string) (*Format, bool) {
+	for _, format := range registry {
+		if strings.EqualFold(format.Name, name) {
+			return format, true
+		}
+	}
+	return nil, false
+}
+
+// Registered returns the formats that can encode points as well as polygons,
+// or all formats if points aren't needed.
+func Registered(points bool) []*Format {
+	formats := make([]*Format, 0, len(registry))
+	for _, format := range registry {
+		if !points || !format.PolygonsOnly {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+func encodeTopoJSON(name string, fc *geojson.FeatureCollection) ([]byte, error) {
+	return topology.Build(fc).TopoJSON(name, TOPOJSON_QUANTIZATION)
+}
@@ -0,0 +1,58 @@
+package formats
+
+import (
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+// features returns a unit polygon and a codepoint within it.
+func features() *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+	unit := geojson.NewFeature(orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}, {{0.2, 0.2}, {0.2, 0.4}, {0.4, 0.4}, {0.2, 0.2}}})
+	unit.ID = "TR26 1AB"
+	unit.Properties["type"] = "unit"
+	fc.Append(unit)
+	point := geojson.NewFeature(orb.Point{0.5, 0.5})
+	point.ID = "TR26 1AB"
+	point.Properties["easting"] = 151000.0
+	point.Properties["tags"] = []any{"a", "b"}
+	fc.Append(point)
+	return fc
+}
+
+func TestLookup(t *testing.T) {
+	format, found := Lookup("GeoJSON")
+	require.True(t, found)
+	require.Equal(t, GEOJSON_MIME_TYPE, format.MimeType)
+
+	_, found = Lookup("shapefile")
+	require.False(t, found)
+}
+
+func TestRegister(t *testing.T) {
+	defer func(registered []*Format) { registry = registered }(registry)
+
+	encode := func(name string, fc *geojson.FeatureCollection) ([]byte, error) { return []byte(name), nil }
+	Register(&Format{Name: "test", MimeType: "text/x-test", Encode: encode})
+
+	format, found := Lookup("test")
+	require.True(t, found)
+	require.Equal(t, format, Registered(true)[len(Registered(true))-1])
+
+	require.Panics(t, func() { Register(&Format{Name: "test", MimeType: "text/x-other", Encode: encode}) })
+}
+
+func TestRegistered(t *testing.T) {
+	var all, points []string
+	for _, format := range Registered(false) {
+		all = append(all, format.Name)
+	}
+	for _, format := range Registered(true) {
+		points = append(points, format.Name)
+	}
+	require.Equal(t, []string{"geojson", "geojsonseq", "topojson", "flatgeobuf", "geopackage", "csv", "wkt", "kml"}, all)
+	require.Equal(t, []string{"geojson", "geojsonseq", "csv", "wkt", "kml"}, points)
+}
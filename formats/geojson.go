@@ -0,0 +1,112 @@
+package formats
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+const GEOJSON_SEQ = "geojsonseq"
+const GEOJSON_SEQ_MIME_TYPE = "application/geo+json-seq"
+
+const recordSeparator = 0x1E // Starts each feature of a GeoJSON text sequence
+
+const featureCollectionStart = `{"type":"FeatureCollection","features":[`
+
+func encodeGeoJSON(name string, fc *geojson.FeatureCollection) ([]byte, error) {
+	return fc.MarshalJSON()
+}
+
+// geoJSONWriter writes a FeatureCollection a feature at a time, opening it
+// before the first feature and closing it after the last.
+type geoJSONWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func newGeoJSONWriter(w io.Writer, name string) FeatureWriter {
+	return &geoJSONWriter{w: w}
+}
+
+func (g *geoJSONWriter) Write(features ...*geojson.Feature) error {
+	for _, feature := range features {
+		data, err := feature.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		if err := g.start(); err != nil {
+			return err
+		}
+		if _, err := g.w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *geoJSONWriter) start() error {
+	prefix := ","
+	if !g.started {
+		prefix = featureCollectionStart
+		g.started = true
+	}
+	_, err := io.WriteString(g.w, prefix)
+	return err
+}
+
+func (g *geoJSONWriter) Close() error {
+	if !g.started {
+		if _, err := io.WriteString(g.w, featureCollectionStart); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(g.w, "]}")
+	return err
+}
+
+// geoJSONSeqWriter writes each feature as its own GeoJSON text, in a GeoJSON
+// text sequence. See https://www.rfc-editor.org/rfc/rfc8142
+type geoJSONSeqWriter struct {
+	w io.Writer
+}
+
+func newGeoJSONSeqWriter(w io.Writer, name string) FeatureWriter {
+	return &geoJSONSeqWriter{w: w}
+}
+
+func (g *geoJSONSeqWriter) Write(features ...*geojson.Feature) error {
+	for _, feature := range features {
+		data, err := feature.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		record := make([]byte, 0, len(data)+2)
+		record = append(record, recordSeparator)
+		record = append(record, data...)
+		if _, err := g.w.Write(append(record, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *geoJSONSeqWriter) Close() error {
+	return nil
+}
+
+// encodeStream makes an encoder from a stream encoder, for when the features
+// are all to hand anyway.
+func encodeStream(stream StreamEncoder) Encoder {
+	return func(name string, fc *geojson.FeatureCollection) ([]byte, error) {
+		var buf bytes.Buffer
+		writer := stream(&buf, name)
+		if err := writer.Write(fc.Features...); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
@@ -0,0 +1,45 @@
+package formats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writer := newGeoJSONWriter(&buf, "units")
+	fc := features()
+	require.NoError(t, writer.Write(fc.Features[0]))
+	require.NoError(t, writer.Write())
+	require.NoError(t, writer.Write(fc.Features[1]))
+	require.NoError(t, writer.Close())
+
+	streamed, err := geojson.UnmarshalFeatureCollection(buf.Bytes())
+	require.NoError(t, err)
+	require.Len(t, streamed.Features, 2)
+	require.Equal(t, fc.Features[0].Geometry, streamed.Features[0].Geometry)
+	require.Equal(t, fc.Features[1].Properties, streamed.Features[1].Properties)
+
+	buf.Reset()
+	require.NoError(t, newGeoJSONWriter(&buf, "units").Close())
+	require.Equal(t, `{"type":"FeatureCollection","features":[]}`, buf.String())
+}
+
+func TestGeoJSONSeqWriter(t *testing.T) {
+	data, err := encodeStream(newGeoJSONSeqWriter)("units", features())
+	require.NoError(t, err)
+
+	records := strings.Split(string(data), "\x1e")
+	require.Len(t, records, 3)
+	require.Empty(t, records[0])
+	for _, record := range records[1:] {
+		require.True(t, strings.HasSuffix(record, "\n"))
+		feature, err := geojson.UnmarshalFeature([]byte(record))
+		require.NoError(t, err)
+		require.Equal(t, "TR26 1AB", feature.ID)
+	}
+}
@@ -0,0 +1,108 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/xml"
+	"sort"
+	"strconv"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+const KML_MIME_TYPE = "application/vnd.google-earth.kml+xml"
+
+// EncodeKML writes the features as a KML document, with a placemark for each
+// feature named by its ID, and its properties as extended data. See
+// https://developers.google.com/kml/documentation/kmlreference
+func EncodeKML(name string, fc *geojson.FeatureCollection) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2"><Document>`)
+	writeElement(&buf, "name", name)
+
+	for _, feature := range fc.Features {
+		buf.WriteString("<Placemark>")
+		if feature.ID != nil {
+			text, err := formatValue(feature.ID)
+			if err != nil {
+				return nil, err
+			}
+			writeElement(&buf, "name", text)
+		}
+
+		if len(feature.Properties) > 0 {
+			keys := make([]string, 0, len(feature.Properties))
+			for key := range feature.Properties {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			buf.WriteString("<ExtendedData>")
+			for _, key := range keys {
+				text, err := formatValue(feature.Properties[key])
+				if err != nil {
+					return nil, err
+				}
+				buf.WriteString(`<Data name="`)
+				xml.EscapeText(&buf, []byte(key))
+				buf.WriteString(`">`)
+				writeElement(&buf, "value", text)
+				buf.WriteString("</Data>")
+			}
+			buf.WriteString("</ExtendedData>")
+		}
+
+		writeGeometry(&buf, feature.Geometry)
+		buf.WriteString("</Placemark>")
+	}
+
+	buf.WriteString("</Document></kml>")
+	return buf.Bytes(), nil
+}
+
+func writeElement(buf *bytes.Buffer, name string, text string) {
+	buf.WriteString("<" + name + ">")
+	xml.EscapeText(buf, []byte(text))
+	buf.WriteString("</" + name + ">")
+}
+
+// writeGeometry writes points and polygons. Multipolygons become a
+// MultiGeometry holding each polygon.
+func writeGeometry(buf *bytes.Buffer, geometry orb.Geometry) {
+	switch g := geometry.(type) {
+	case orb.Point:
+		buf.WriteString("<Point><coordinates>")
+		writeCoordinates(buf, []orb.Point{g})
+		buf.WriteString("</coordinates></Point>")
+	case orb.Polygon:
+		buf.WriteString("<Polygon>")
+		for i, ring := range g {
+			boundary := "innerBoundaryIs"
+			if i == 0 {
+				boundary = "outerBoundaryIs"
+			}
+			buf.WriteString("<" + boundary + "><LinearRing><coordinates>")
+			writeCoordinates(buf, ring)
+			buf.WriteString("</coordinates></LinearRing></" + boundary + ">")
+		}
+		buf.WriteString("</Polygon>")
+	case orb.MultiPolygon:
+		buf.WriteString("<MultiGeometry>")
+		for _, polygon := range g {
+			writeGeometry(buf, polygon)
+		}
+		buf.WriteString("</MultiGeometry>")
+	}
+}
+
+func writeCoordinates(buf *bytes.Buffer, points []orb.Point) {
+	for i, point := range points {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(strconv.FormatFloat(point.Lon(), 'f', -1, 64))
+		buf.WriteByte(',')
+		buf.WriteString(strconv.FormatFloat(point.Lat(), 'f', -1, 64))
+	}
+}
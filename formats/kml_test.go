@@ -0,0 +1,55 @@
+package formats
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeKML(t *testing.T) {
+	fc := features()
+	islands := geojson.NewFeature(orb.MultiPolygon{{{{2, 2}, {3, 2}, {3, 3}, {2, 2}}}, {{{4, 4}, {5, 4}, {5, 5}, {4, 4}}}})
+	islands.ID = "TR26 <1AD>"
+	fc.Append(islands)
+
+	data, err := EncodeKML("units", fc)
+	require.NoError(t, err)
+
+	var doc struct {
+		Name       string `xml:"Document>name"`
+		Placemarks []struct {
+			Name string `xml:"name"`
+			Data []struct {
+				Name  string `xml:"name,attr"`
+				Value string `xml:"value"`
+			} `xml:"ExtendedData>Data"`
+			Polygon *struct {
+				Outer string   `xml:"outerBoundaryIs>LinearRing>coordinates"`
+				Inner []string `xml:"innerBoundaryIs>LinearRing>coordinates"`
+			} `xml:"Polygon"`
+			Point    string `xml:"Point>coordinates"`
+			Polygons []any  `xml:"MultiGeometry>Polygon"`
+		} `xml:"Document>Placemark"`
+	}
+	require.NoError(t, xml.Unmarshal(data, &doc))
+	require.Equal(t, "units", doc.Name)
+	require.Len(t, doc.Placemarks, 3)
+
+	unit := doc.Placemarks[0]
+	require.Equal(t, "TR26 1AB", unit.Name)
+	require.Equal(t, "0,0 1,0 1,1 0,1 0,0", unit.Polygon.Outer)
+	require.Equal(t, []string{"0.2,0.2 0.2,0.4 0.4,0.4 0.2,0.2"}, unit.Polygon.Inner)
+
+	point := doc.Placemarks[1]
+	require.Equal(t, "0.5,0.5", point.Point)
+	require.Len(t, point.Data, 2)
+	require.Equal(t, "easting", point.Data[0].Name)
+	require.Equal(t, "151000", point.Data[0].Value)
+	require.Equal(t, `["a","b"]`, point.Data[1].Value)
+
+	require.Equal(t, "TR26 <1AD>", doc.Placemarks[2].Name)
+	require.Len(t, doc.Placemarks[2].Polygons, 2)
+}
@@ -0,0 +1,25 @@
+package formats
+
+import (
+	"bytes"
+
+	"github.com/paulmach/orb/encoding/wkt"
+	"github.com/paulmach/orb/geojson"
+)
+
+const WKT_MIME_TYPE = "text/plain"
+
+// EncodeWKT writes the geometry of each feature as Well-Known Text, one per
+// line. WKT has no way of giving the features' IDs or properties, so they're
+// left out; use CSV to get them alongside the WKT.
+func EncodeWKT(name string, fc *geojson.FeatureCollection) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, feature := range fc.Features {
+		if feature.Geometry == nil {
+			continue
+		}
+		buf.WriteString(wkt.MarshalString(feature.Geometry))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,13 @@
+package formats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeWKT(t *testing.T) {
+	data, err := EncodeWKT("units", features())
+	require.NoError(t, err)
+	require.Equal(t, "POLYGON((0 0,1 0,1 1,0 1,0 0),(0.2 0.2,0.2 0.4,0.4 0.4,0.2 0.2))\nPOINT(0.5 0.5)\n", string(data))
+}
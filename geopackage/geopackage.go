@@ -0,0 +1,304 @@
+package geopackage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/geojson"
+	_ "modernc.org/sqlite" // Pure Go, so doesn't need cgo
+)
+
+// A minimal encoder for OGC GeoPackage (v1.4) files, which only needs to deal
+// with a single table of polygon features in WGS84. The file is built as an
+// in-memory SQLite database, then serialized. See
+// https://www.geopackage.org/spec140/
+
+const MIME_TYPE = "application/geopackage+sqlite3"
+
+const SRS_ID = 4326 // WGS84
+
+const applicationID = 0x47504B47 // "GPKG"
+const userVersion = 10400        // v1.4.0
+
+// The minimal set of tables required by the spec, with the spatial reference
+// systems which must always be defined
+const schema = `
+CREATE TABLE gpkg_spatial_ref_sys (
+	srs_name TEXT NOT NULL,
+	srs_id INTEGER PRIMARY KEY,
+	organization TEXT NOT NULL,
+	organization_coordsys_id INTEGER NOT NULL,
+	definition TEXT NOT NULL,
+	description TEXT
+);
+CREATE TABLE gpkg_contents (
+	table_name TEXT NOT NULL PRIMARY KEY,
+	data_type TEXT NOT NULL,
+	identifier TEXT UNIQUE,
+	description TEXT DEFAULT '',
+	last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+	min_x DOUBLE,
+	min_y DOUBLE,
+	max_x DOUBLE,
+	max_y DOUBLE,
+	srs_id INTEGER,
+	CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id)
+);
+CREATE TABLE gpkg_geometry_columns (
+	table_name TEXT NOT NULL,
+	column_name TEXT NOT NULL,
+	geometry_type_name TEXT NOT NULL,
+	srs_id INTEGER NOT NULL,
+	z TINYINT NOT NULL,
+	m TINYINT NOT NULL,
+	CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name),
+	CONSTRAINT uk_gc_table_name UNIQUE (table_name),
+	CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name),
+	CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id)
+);
+INSERT INTO gpkg_spatial_ref_sys VALUES
+	('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', 'undefined cartesian coordinate reference system'),
+	('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', 'undefined geographic coordinate reference system'),
+	('WGS 84 geodetic', 4326, 'EPSG', 4326, 'GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]]', 'longitude/latitude coordinates in decimal degrees on the WGS 84 spheroid');
+`
+
+type column struct {
+	name  string
+	ctype string
+}
+
+// serializer is implemented by the driver's connections, to read back the
+// whole of an in-memory database.
+type serializer interface {
+	Serialize() ([]byte, error)
+}
+
+// Encode writes the polygon and multipolygon features as a GeoPackage with a
+// single table of the given name. Each feature's ID is carried in an "id"
+// column (unless the features already have an "id" property), alongside
+// columns for all of their properties.
+func Encode(name string, fc *geojson.FeatureCollection) ([]byte, error) {
+	features := make([]*geojson.Feature, 0, len(fc.Features))
+	var extent orb.Bound
+	for _, feature := range fc.Features {
+		switch feature.Geometry.(type) {
+		case orb.Polygon, orb.MultiPolygon:
+			if len(features) == 0 {
+				extent = feature.Geometry.Bound()
+			} else {
+				extent = extent.Union(feature.Geometry.Bound())
+			}
+			features = append(features, feature)
+		}
+	}
+
+	// Every statement has to use the same connection, as each has its own
+	// in-memory database
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	columns := columnsOf(features)
+	definitions := []string{"fid INTEGER PRIMARY KEY AUTOINCREMENT", "geom " + geometryTypeOf(features)}
+	placeholders := []string{"?"}
+	for _, col := range columns {
+		definitions = append(definitions, quote(col.name)+" "+col.ctype)
+		placeholders = append(placeholders, "?")
+	}
+
+	statements := []string{
+		fmt.Sprintf("PRAGMA application_id = %d", applicationID),
+		fmt.Sprintf("PRAGMA user_version = %d", userVersion),
+		schema,
+		fmt.Sprintf("CREATE TABLE %s (%s)", quote(name), strings.Join(definitions, ", ")),
+	}
+	for _, statement := range statements {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return nil, fmt.Errorf("error creating tables: %w", err)
+		}
+	}
+
+	_, err = conn.ExecContext(ctx,
+		"INSERT INTO gpkg_contents (table_name, data_type, identifier, min_x, min_y, max_x, max_y, srs_id) VALUES (?, 'features', ?, ?, ?, ?, ?, ?)",
+		name, name, extent.Min.X(), extent.Min.Y(), extent.Max.X(), extent.Max.Y(), SRS_ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error adding table to contents: %w", err)
+	}
+	_, err = conn.ExecContext(ctx,
+		"INSERT INTO gpkg_geometry_columns VALUES (?, 'geom', ?, ?, 0, 0)",
+		name, geometryTypeOf(features), SRS_ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error adding geometry column: %w", err)
+	}
+
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = ", " + quote(col.name)
+	}
+	insert, err := conn.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (geom%s) VALUES (%s)",
+		quote(name), strings.Join(names, ""), strings.Join(placeholders, ", ")))
+	if err != nil {
+		return nil, fmt.Errorf("error preparing insert: %w", err)
+	}
+	defer func() { _ = insert.Close() }()
+
+	for _, feature := range features {
+		values, err := valuesOf(feature, columns)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding feature %v: %w", feature.ID, err)
+		}
+		if _, err := insert.ExecContext(ctx, values...); err != nil {
+			return nil, fmt.Errorf("error inserting feature %v: %w", feature.ID, err)
+		}
+	}
+
+	var data []byte
+	err = conn.Raw(func(driverConn any) error {
+		s, ok := driverConn.(serializer)
+		if !ok {
+			return errors.New("the SQLite driver can't serialize databases")
+		}
+		data, err = s.Serialize()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error serializing database: %w", err)
+	}
+	return data, nil
+}
+
+// columnsOf works out a column for each property, typed by the values it
+// has. Properties with a mix of types, or which are objects or arrays, are
+// stored as JSON text.
+func columnsOf(features []*geojson.Feature) []column {
+	types := make(map[string]string)
+	hasID := false
+	for _, feature := range features {
+		hasID = hasID || feature.ID != nil
+		for key, value := range feature.Properties {
+			ctype, ok := typeOf(value)
+			if !ok {
+				continue
+			}
+			if existing, found := types[key]; found && existing != ctype {
+				ctype = "TEXT"
+			}
+			types[key] = ctype
+		}
+	}
+
+	var columns []column
+	if _, found := types["id"]; hasID && !found {
+		columns = append(columns, column{name: "id", ctype: "TEXT"})
+	}
+	keys := make([]string, 0, len(types))
+	for key := range types {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		columns = append(columns, column{name: key, ctype: types[key]})
+	}
+	return columns
+}
+
+func typeOf(value any) (string, bool) {
+	switch value.(type) {
+	case nil:
+		return "", false
+	case float64, int:
+		return "DOUBLE", true
+	case bool:
+		return "BOOLEAN", true
+	default:
+		return "TEXT", true
+	}
+}
+
+// geometryTypeOf returns the type of all the features' geometries, or
+// GEOMETRY if they're a mix of polygons and multipolygons.
+func geometryTypeOf(features []*geojson.Feature) string {
+	gtype := "GEOMETRY"
+	for i, feature := range features {
+		t := "POLYGON"
+		if _, ok := feature.Geometry.(orb.MultiPolygon); ok {
+			t = "MULTIPOLYGON"
+		}
+		if i > 0 && t != gtype {
+			return "GEOMETRY"
+		}
+		gtype = t
+	}
+	return gtype
+}
+
+// valuesOf returns the feature's geometry followed by the value of each
+// column, converted to types SQLite can store.
+func valuesOf(feature *geojson.Feature, columns []column) ([]any, error) {
+	geometry, err := encodeGeometry(feature.Geometry)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []any{geometry}
+	for _, col := range columns {
+		value, found := feature.Properties[col.name]
+		if !found && col.name == "id" {
+			value = feature.ID
+		}
+
+		switch v := value.(type) {
+		case nil, string, float64, bool:
+			values = append(values, v)
+		case int:
+			values = append(values, float64(v))
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, string(data))
+		}
+	}
+	return values, nil
+}
+
+// encodeGeometry writes a GeoPackage geometry blob: a header giving the SRS
+// and the envelope of the geometry, followed by the geometry as WKB.
+func encodeGeometry(geometry orb.Geometry) ([]byte, error) {
+	data, err := wkb.Marshal(geometry, binary.LittleEndian)
+	if err != nil {
+		return nil, err
+	}
+
+	bound := geometry.Bound()
+	out := []byte{'G', 'P', 0, 0b0000_0011} // Version 1, little endian with an [minx, maxx, miny, maxy] envelope
+	out = binary.LittleEndian.AppendUint32(out, uint32(int32(SRS_ID)))
+	for _, value := range []float64{bound.Min.X(), bound.Max.X(), bound.Min.Y(), bound.Max.Y()} {
+		out = binary.LittleEndian.AppendUint64(out, math.Float64bits(value))
+	}
+	return append(out, data...), nil
+}
+
+func quote(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
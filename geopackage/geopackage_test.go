@@ -0,0 +1,113 @@
+package geopackage
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+// open writes an encoded GeoPackage to a file, and opens it as a database.
+func open(t *testing.T, data []byte) *sql.DB {
+	path := filepath.Join(t.TempDir(), "test.gpkg")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// query returns all the rows of the query's results.
+func query(t *testing.T, db *sql.DB, q string) [][]any {
+	rows, err := db.Query(q)
+	require.NoError(t, err)
+	defer func() { _ = rows.Close() }()
+
+	columns, err := rows.Columns()
+	require.NoError(t, err)
+	var results [][]any
+	for rows.Next() {
+		row := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range row {
+			pointers[i] = &row[i]
+		}
+		require.NoError(t, rows.Scan(pointers...))
+		results = append(results, row)
+	}
+	require.NoError(t, rows.Err())
+	return results
+}
+
+func TestEncode(t *testing.T) {
+	fc := geojson.NewFeatureCollection()
+	unit := geojson.NewFeature(orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}})
+	unit.ID = "TR26 1AB"
+	unit.Properties["type"] = "unit"
+	unit.Properties["count"] = 2.0
+	unit.Properties["tags"] = []any{"a", "b"}
+	fc.Append(unit)
+	islands := geojson.NewFeature(orb.MultiPolygon{{{{2, 2}, {3, 2}, {3, 3}, {2, 2}}}, {{{4, 4}, {5, 4}, {5, 5}, {4, 4}}}})
+	islands.ID = "TR26 1AD"
+	fc.Append(islands)
+	fc.Append(geojson.NewFeature(orb.Point{1, 2})) // Not polygonal, so left out
+
+	data, err := Encode("units", fc)
+	require.NoError(t, err)
+	require.Equal(t, "SQLite format 3\x00", string(data[:16]))
+
+	db := open(t, data)
+	require.Equal(t, [][]any{{"ok"}}, query(t, db, "PRAGMA integrity_check"))
+	require.Empty(t, query(t, db, "PRAGMA foreign_key_check"))
+	require.Equal(t, [][]any{{int64(0x47504B47)}}, query(t, db, "PRAGMA application_id"))
+	require.Equal(t, [][]any{{int64(10400)}}, query(t, db, "PRAGMA user_version"))
+
+	srs := query(t, db, "SELECT srs_id, srs_name, organization, organization_coordsys_id FROM gpkg_spatial_ref_sys ORDER BY srs_id")
+	require.Len(t, srs, 3)
+	require.Equal(t, []any{int64(4326), "WGS 84 geodetic", "EPSG", int64(4326)}, srs[2])
+
+	contents := query(t, db, "SELECT table_name, data_type, identifier, description, CAST(last_change AS TEXT), min_x, min_y, max_x, max_y, srs_id FROM gpkg_contents")
+	require.Len(t, contents, 1)
+	require.Equal(t, []any{"units", "features", "units", ""}, contents[0][:4])
+	require.Regexp(t, `^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}Z$`, contents[0][4])
+	require.Equal(t, []any{0.0, 0.0, 5.0, 5.0, int64(4326)}, contents[0][5:])
+	require.Equal(t, [][]any{{"units", "geom", "GEOMETRY", int64(4326), int64(0), int64(0)}},
+		query(t, db, "SELECT * FROM gpkg_geometry_columns"))
+
+	require.Equal(t, [][]any{{`CREATE TABLE "units" (fid INTEGER PRIMARY KEY AUTOINCREMENT, geom GEOMETRY, "id" TEXT, "count" DOUBLE, "tags" TEXT, "type" TEXT)`}},
+		query(t, db, "SELECT sql FROM sqlite_master WHERE name = 'units'"))
+	features := query(t, db, `SELECT fid, id, count, tags, type FROM units ORDER BY fid`)
+	require.Equal(t, [][]any{
+		{int64(1), "TR26 1AB", 2.0, `["a","b"]`, "unit"},
+		{int64(2), "TR26 1AD", nil, nil, nil},
+	}, features)
+
+	blob := query(t, db, "SELECT geom FROM units WHERE fid = 2")[0][0].([]byte)
+	require.Equal(t, []byte{'G', 'P', 0, 3}, blob[:4])
+	require.Equal(t, uint32(4326), binary.LittleEndian.Uint32(blob[4:]))
+	var envelope []float64
+	for i := 0; i < 4; i++ {
+		envelope = append(envelope, math.Float64frombits(binary.LittleEndian.Uint64(blob[8+8*i:])))
+	}
+	require.Equal(t, []float64{2, 5, 2, 5}, envelope)
+
+	geometry, err := wkb.Unmarshal(blob[40:])
+	require.NoError(t, err)
+	require.Equal(t, islands.Geometry, geometry)
+}
+
+func TestEncode_Empty(t *testing.T) {
+	data, err := Encode("units", geojson.NewFeatureCollection())
+	require.NoError(t, err)
+
+	db := open(t, data)
+	require.Equal(t, [][]any{{"ok"}}, query(t, db, "PRAGMA integrity_check"))
+	require.Empty(t, query(t, db, "SELECT * FROM units"))
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+type edge struct {
+	from, to orb.Point
+}
+
+// Dissolve merges polygons into the outline(s) of the area they cover.
+//
+// This relies on neighbouring polygons having exactly the same vertices along
+// their shared edges, as the NSUL polygons do (they are all derived from one
+// tessellation). With every exterior ring traced anticlockwise and every hole
+// clockwise, an edge shared by two polygons appears once in each direction
+// and cancels out, so whatever is left over is the outline of the union.
+func Dissolve(polygons []orb.Polygon) orb.MultiPolygon {
+	counts := make(map[edge]int)
+	order := make([]edge, 0, 1024) // Keeps the output stable between runs
+
+	for _, polygon := range polygons {
+		for i, ring := range polygon {
+			want := orb.CCW
+			if i > 0 {
+				want = orb.CW
+			}
+			orientation := ring.Orientation()
+			if orientation == 0 {
+				continue
+			}
+			for j := 0; j < len(ring)-1; j++ {
+				e := edge{ring[j], ring[j+1]}
+				if orientation != want {
+					e = edge{ring[j+1], ring[j]}
+				}
+				if e.from == e.to {
+					continue
+				}
+				if _, seen := counts[e]; !seen {
+					order = append(order, e)
+				}
+				counts[e]++
+			}
+		}
+	}
+
+	// Overlapping polygons can leave an edge with a net count above one, which
+	// then has to be walked that many times
+	outgoing := make(map[orb.Point][]orb.Point)
+	for _, e := range order {
+		net := counts[e] - counts[edge{e.to, e.from}]
+		for ; net > 0; net-- {
+			outgoing[e.from] = append(outgoing[e.from], e.to)
+		}
+	}
+
+	var exteriors, holes []orb.Ring
+	for _, e := range order {
+		for len(outgoing[e.from]) > 0 {
+			ring, closed := walkRing(outgoing, e.from)
+			if !closed {
+				continue
+			}
+			switch ring.Orientation() {
+			case orb.CCW:
+				exteriors = append(exteriors, ring)
+			case orb.CW:
+				holes = append(holes, ring)
+			}
+		}
+	}
+
+	result := make(orb.MultiPolygon, len(exteriors))
+	for i, exterior := range exteriors {
+		result[i] = orb.Polygon{exterior}
+	}
+	for _, hole := range holes {
+		if i := smallestContaining(exteriors, hole); i >= 0 {
+			result[i] = append(result[i], hole)
+		}
+	}
+	return result
+}
+
+// walkRing follows (and consumes) edges from the start point until it comes
+// back round. Edges that don't form a closed ring are discarded.
+func walkRing(outgoing map[orb.Point][]orb.Point, start orb.Point) (orb.Ring, bool) {
+	ring := orb.Ring{start}
+	current := start
+	for {
+		next := outgoing[current]
+		if len(next) == 0 {
+			return nil, false
+		}
+		point := next[len(next)-1]
+		outgoing[current] = next[:len(next)-1]
+
+		ring = append(ring, point)
+		current = point
+		if current == start {
+			return ring, true
+		}
+	}
+}
+
+func smallestContaining(exteriors []orb.Ring, hole orb.Ring) int {
+	found, smallest := -1, 0.0
+	for i, exterior := range exteriors {
+		bound := exterior.Bound()
+		if !bound.Contains(hole.Bound().Min) || !bound.Contains(hole.Bound().Max) {
+			continue
+		}
+		if !planar.RingContains(exterior, hole[0]) {
+			continue
+		}
+		if area := planar.Area(exterior); found < 0 || area < smallest {
+			found, smallest = i, area
+		}
+	}
+	return found
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+	"github.com/stretchr/testify/require"
+)
+
+func square(x, y, size float64) orb.Polygon {
+	return orb.Polygon{{{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size}, {x, y}}}
+}
+
+func TestDissolve_Adjacent(t *testing.T) {
+	// Two squares side by side, the second wound the wrong way round
+	left := square(0, 0, 1)
+	right := square(1, 0, 1)
+	right[0].Reverse()
+
+	result := Dissolve([]orb.Polygon{left, right})
+	require.Len(t, result, 1)
+	require.Len(t, result[0], 1)
+	require.Equal(t, orb.CCW, result[0][0].Orientation())
+	require.InDelta(t, 2.0, planar.Area(result), 1e-9)
+	require.Equal(t, orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{2, 1}}, result.Bound())
+}
+
+func TestDissolve_Disjoint(t *testing.T) {
+	result := Dissolve([]orb.Polygon{square(0, 0, 1), square(5, 5, 1)})
+	require.Len(t, result, 2)
+	require.InDelta(t, 2.0, planar.Area(result), 1e-9)
+}
+
+func TestDissolve_Hole(t *testing.T) {
+	// A 3x3 block of squares with the middle one missing
+	var polygons []orb.Polygon
+	for x := 0.0; x < 3; x++ {
+		for y := 0.0; y < 3; y++ {
+			if x != 1 || y != 1 {
+				polygons = append(polygons, square(x, y, 1))
+			}
+		}
+	}
+
+	result := Dissolve(polygons)
+	require.Len(t, result, 1)
+	require.Len(t, result[0], 2)
+	require.Equal(t, orb.CW, result[0][1].Orientation())
+	require.InDelta(t, 8.0, planar.Area(result), 1e-9)
+	require.False(t, planar.MultiPolygonContains(result, orb.Point{1.5, 1.5}))
+	require.True(t, planar.MultiPolygonContains(result, orb.Point{0.5, 0.5}))
+}
+
+func TestDissolve_Duplicates(t *testing.T) {
+	// The same polygon appearing twice (as happens where postcodes share a
+	// building) must not break the outline of its neighbours
+	result := Dissolve([]orb.Polygon{square(0, 0, 1), square(0, 0, 1), square(1, 0, 1)})
+	require.Equal(t, orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{2, 1}}, result.Bound())
+	require.True(t, planar.MultiPolygonContains(result, orb.Point{1.5, 0.5}))
+}
@@ -1,12 +1,23 @@
 package internal
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/dsnet/compress/bzip2"
 	jsoniter "github.com/json-iterator/go"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
 	"github.com/paulmach/orb/geojson"
 )
 
@@ -17,54 +28,357 @@ func init() {
 	geojson.CustomJSONUnmarshaler = c
 }
 
-func CompressFeatureCollection(bz2filename string, fc *geojson.FeatureCollection) (int, error) {
-	f, err := os.Create(bz2filename)
+// Where extract-data saves the dictionary that zstd files are compressed
+// with, which is needed to decompress them again
+const ZSTD_DICTIONARY = "./data/postcodes/zstd.dict"
+
+const ZSTD_DICTIONARY_SIZE = 112 << 10 // As for the zstd CLI
+
+// Codec compresses files. Files are written with the codec matching their
+// extension, but read with whichever codec their first few bytes match, so a
+// file's extension only matters if it can't be recognised from its content.
+type Codec struct {
+	Name      string
+	Extension string // Added to the file's own extension, e.g. ".geojson.bz2"
+	magic     []byte
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.Reader, error)
+}
+
+// CODECS are in order of preference, for when the same file has been written
+// with more than one.
+var CODECS = []*Codec{
+	{
+		Name:      "bzip2",
+		Extension: ".bz2",
+		magic:     []byte("BZh"),
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: bzip2.BestCompression})
+		},
+		newReader: func(r io.Reader) (io.Reader, error) {
+			return bzip2.NewReader(r, &bzip2.ReaderConfig{})
+		},
+	},
+	{
+		Name:      "zstd",
+		Extension: ".zst",
+		magic:     []byte{0x28, 0xB5, 0x2F, 0xFD},
+		newWriter: newZstdWriter,
+		newReader: newZstdReader,
+	},
+	{
+		Name:      "gzip",
+		Extension: ".gz",
+		magic:     []byte{0x1F, 0x8B},
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, gzip.BestCompression)
+		},
+		newReader: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	{
+		Name:      "none",
+		Extension: "",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil },
+		newReader: func(r io.Reader) (io.Reader, error) { return r, nil },
+	},
+}
+
+// LookupCodec finds a codec by name.
+func LookupCodec(name string) (*Codec, error) {
+	names := make([]string, len(CODECS))
+	for i, codec := range CODECS {
+		if codec.Name == name {
+			return codec, nil
+		}
+		names[i] = codec.Name
+	}
+	return nil, fmt.Errorf("unsupported codec '%s', must be one of %s or %s",
+		name, strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+}
+
+// CodecForFile picks the codec from the file's extension, which is no codec
+// at all if it isn't one of theirs.
+func CodecForFile(filename string) *Codec {
+	for _, codec := range CODECS {
+		if codec.Extension != "" && strings.HasSuffix(filename, codec.Extension) {
+			return codec
+		}
+	}
+	return CODECS[len(CODECS)-1]
+}
+
+func CompressFeatureCollection(filename string, fc *geojson.FeatureCollection) (int, error) {
+	return CompressFile(filename, func(w io.Writer) error {
+		return c.NewEncoder(w).Encode(fc)
+	})
+}
+
+// CompressFile creates a file with whatever content write produces, compressed
+// with the codec for its extension, returning the compressed size.
+func CompressFile(filename string, write func(w io.Writer) error) (int, error) {
+	codec := CodecForFile(filename)
+	f, err := os.Create(filename)
 	if err != nil {
 		return 0, fmt.Errorf("error creating output file: %w", err)
 	}
 	defer func() {
 		if err := f.Close(); err != nil {
-			log.Printf("Error closing file %s: %v", bz2filename, err)
+			log.Printf("Error closing file %s: %v", filename, err)
 		}
 	}()
 
-	w, err := bzip2.NewWriter(f, &bzip2.WriterConfig{Level: bzip2.BestCompression})
+	counter := &countingWriter{w: f}
+	w, err := codec.newWriter(counter)
 	if err != nil {
-		return 0, fmt.Errorf("error creating bzip2 writer: %w", err)
+		return 0, fmt.Errorf("error creating %s writer: %w", codec.Name, err)
 	}
 
-	if err := c.NewEncoder(w).Encode(fc); err != nil {
-		return 0, fmt.Errorf("error writing bzip2 file: %w", err)
+	if err := write(w); err != nil {
+		return 0, fmt.Errorf("error writing %s file: %w", codec.Name, err)
 	}
 
-	err = w.Close() // Ensure to close the writer to flush the data, so that the output offset is correct
+	err = w.Close() // Ensure to close the writer to flush the data, so that the output size is correct
 	if err != nil {
-		return 0, fmt.Errorf("error closing bzip2 writer: %w", err)
+		return 0, fmt.Errorf("error closing %s writer: %w", codec.Name, err)
 	}
 
-	return int(w.OutputOffset), nil
+	return counter.n, nil
 }
 
-func DecompressFeatureCollection(bz2filename string) (*geojson.FeatureCollection, error) {
+// RecompressFile copies a file to another with a different codec.
+func RecompressFile(from string, to string) (int, error) {
+	return CompressFile(to, func(w io.Writer) error {
+		return readCompressedFile(from, func(r io.Reader) error {
+			_, err := io.Copy(w, r)
+			return err
+		})
+	})
+}
 
-	file, err := os.Open(bz2filename)
+func DecompressFeatureCollection(filename string) (*geojson.FeatureCollection, error) {
+	fc := geojson.NewFeatureCollection()
+	err := readCompressedFile(filename, func(r io.Reader) error {
+		return c.NewDecoder(r).Decode(fc)
+	})
 	if err != nil {
 		return nil, err
 	}
+	return fc, nil
+}
+
+// readCompressedFile passes read the decompressed content of a file, using the
+// codec that its content starts with, or otherwise the codec for its
+// extension.
+func readCompressedFile(filename string, read func(r io.Reader) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		if err := file.Close(); err != nil {
-			log.Printf("Error closing file %s: %v", bz2filename, err)
+			log.Printf("Error closing file %s: %v", filename, err)
 		}
 	}()
 
-	r, err := bzip2.NewReader(file, &bzip2.ReaderConfig{})
+	buffered := bufio.NewReader(file)
+	codec := CodecForFile(filename)
+	header, _ := buffered.Peek(4)
+	for _, candidate := range CODECS {
+		if candidate.magic != nil && bytes.HasPrefix(header, candidate.magic) {
+			codec = candidate
+			break
+		}
+	}
+
+	r, err := codec.newReader(buffered)
 	if err != nil {
-		return nil, fmt.Errorf("error creating bzip2 reader: %w", err)
+		return fmt.Errorf("error creating %s reader: %w", codec.Name, err)
 	}
+	return read(r)
+}
 
-	fc := geojson.NewFeatureCollection()
-	if err := c.NewDecoder(r).Decode(fc); err != nil {
+// FindFeatureCollection returns the file holding the named feature collection
+// in the directory, whichever codec it was written with. As with opening a
+// file, it fails with an error satisfying os.IsNotExist if there isn't one.
+func FindFeatureCollection(dir string, name string) (string, error) {
+	for _, codec := range CODECS {
+		filename := filepath.Join(dir, name+".geojson"+codec.Extension)
+		if info, err := os.Stat(filename); err == nil && !info.IsDir() {
+			return filename, nil
+		}
+	}
+	return "", &os.PathError{Op: "open", Path: filepath.Join(dir, name+".geojson"), Err: os.ErrNotExist}
+}
+
+// ListFeatureCollections returns the feature collection files in the
+// directory, whichever codec they were written with. If a feature collection
+// has been written with more than one, only the preferred file is listed.
+func ListFeatureCollections(dir string) ([]string, error) {
+	found := make(map[string]struct{})
+	var filenames []string
+	for _, codec := range CODECS {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.geojson"+codec.Extension))
+		if err != nil {
+			return nil, err
+		}
+		for _, filename := range matches {
+			name := FeatureCollectionName(filename)
+			if _, exists := found[name]; !exists {
+				found[name] = struct{}{}
+				filenames = append(filenames, filename)
+			}
+		}
+	}
+	slices.Sort(filenames)
+	return filenames, nil
+}
+
+// FeatureCollectionName returns the name of a feature collection file without
+// its directory or extensions, e.g. "TR26" for "units/TR26.geojson.bz2".
+func FeatureCollectionName(filename string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(filepath.Base(filename), CodecForFile(filename).Extension), ".geojson")
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += n
+	return n, err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// The dictionary that zstd files are written with. Files are read by a
+// shared decoder, which knows every dictionary that's been set, and picks
+// whichever the file was written with.
+var zstdDictionary struct {
+	sync.Mutex
+	dict    []byte
+	dicts   [][]byte
+	decoder *sharedDecoder
+}
+
+// sharedDecoder is a decoder, and how many files it's reading. Once it's been
+// replaced by one that knows another dictionary, it's closed as soon as
+// they've all been read.
+type sharedDecoder struct {
+	*zstd.Decoder
+	readers  int
+	replaced bool
+}
+
+// SetZstdDictionary sets the dictionary that zstd files are written with, and
+// allows files written with it to be read.
+func SetZstdDictionary(d []byte) error {
+	zstdDictionary.Lock()
+	defer zstdDictionary.Unlock()
+
+	dicts := append(slices.Clone(zstdDictionary.dicts), d)
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dicts...))
+	if err != nil {
+		return fmt.Errorf("invalid zstd dictionary: %w", err)
+	}
+	if old := zstdDictionary.decoder; old != nil {
+		old.replaced = true
+		old.closeIfDone()
+	}
+	zstdDictionary.dict = d
+	zstdDictionary.dicts = dicts
+	zstdDictionary.decoder = &sharedDecoder{Decoder: decoder}
+	return nil
+}
+
+// LoadZstdDictionary reads a dictionary saved by extract-data, and sets it.
+func LoadZstdDictionary(filename string) error {
+	d, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return SetZstdDictionary(d)
+}
+
+// TrainZstdDictionary builds a dictionary from samples of what will be
+// compressed with it.
+func TrainZstdDictionary(samples [][]byte) ([]byte, error) {
+	if len(samples) == 0 {
+		return nil, errors.New("no samples to train zstd dictionary on")
+	}
+	return dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: ZSTD_DICTIONARY_SIZE,
+		HashBytes:   6,
+		ZstdLevel:   zstd.SpeedBestCompression,
+	})
+}
+
+func newZstdWriter(w io.Writer) (io.WriteCloser, error) {
+	zstdDictionary.Lock()
+	d := zstdDictionary.dict
+	zstdDictionary.Unlock()
+
+	options := []zstd.EOption{zstd.WithEncoderLevel(zstd.SpeedBestCompression)}
+	if d != nil {
+		options = append(options, zstd.WithEncoderDict(d))
+	}
+	return zstd.NewWriter(w, options...)
+}
+
+// newZstdReader decompresses the whole file at once, which the shared decoder
+// can do concurrently.
+func newZstdReader(r io.Reader) (io.Reader, error) {
+	compressed, err := io.ReadAll(r)
+	if err != nil {
 		return nil, err
 	}
-	return fc, nil
+
+	decoder, err := zstdDecoder()
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.release()
+	decompressed, err := decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(decompressed), nil
+}
+
+// zstdDecoder returns the shared decoder, which must be released once the
+// file has been read.
+func zstdDecoder() (*sharedDecoder, error) {
+	zstdDictionary.Lock()
+	defer zstdDictionary.Unlock()
+	if zstdDictionary.decoder == nil {
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderDicts(zstdDictionary.dicts...))
+		if err != nil {
+			return nil, err
+		}
+		zstdDictionary.decoder = &sharedDecoder{Decoder: decoder}
+	}
+	zstdDictionary.decoder.readers++
+	return zstdDictionary.decoder, nil
+}
+
+func (d *sharedDecoder) release() {
+	zstdDictionary.Lock()
+	defer zstdDictionary.Unlock()
+	d.readers--
+	d.closeIfDone()
+}
+
+// closeIfDone closes the decoder if it's been replaced, and nothing is still
+// reading with it. The lock must be held.
+func (d *sharedDecoder) closeIfDone() {
+	if d.replaced && d.readers == 0 {
+		d.Close()
+	}
 }
@@ -1,10 +1,12 @@
 package internal
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geojson"
 	"github.com/stretchr/testify/require"
@@ -98,3 +100,154 @@ func TestCompressFeatureCollection_And_DecompressFeatureCollection_RoundTrip(t *
 	require.Equal(t, fc.Features[0].Geometry, result.Features[0].Geometry)
 	require.Equal(t, fc.Features[0].Properties["foo"], result.Features[0].Properties["foo"])
 }
+
+func TestCompressFile_Codecs(t *testing.T) {
+	fc := unitFeatures("TR26 1AB", "TR26 1ZZ")
+	for _, codec := range CODECS {
+		t.Run(codec.Name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "TR26.geojson"+codec.Extension)
+			_, err := CompressFeatureCollection(filename, fc)
+			require.NoError(t, err)
+
+			result, err := DecompressFeatureCollection(filename)
+			require.NoError(t, err)
+			require.Len(t, result.Features, 2)
+			require.Equal(t, fc.Features[1].Geometry, result.Features[1].Geometry)
+
+			// Recognised from the content, whatever the extension
+			renamed := filepath.Join(t.TempDir(), "TR26.geojson.bz2")
+			require.NoError(t, os.Rename(filename, renamed))
+			result, err = DecompressFeatureCollection(renamed)
+			if codec.magic == nil {
+				require.Error(t, err) // Only the extension to go on, so it's read as bzip2
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, result.Features, 2)
+		})
+	}
+}
+
+func TestLookupCodec(t *testing.T) {
+	codec, err := LookupCodec("zstd")
+	require.NoError(t, err)
+	require.Equal(t, ".zst", codec.Extension)
+
+	_, err = LookupCodec("lz4")
+	require.EqualError(t, err, "unsupported codec 'lz4', must be one of bzip2, zstd, gzip or none")
+
+	require.Equal(t, "gzip", CodecForFile("TR26.geojson.gz").Name)
+	require.Equal(t, "none", CodecForFile("TR26.geojson").Name)
+}
+
+func TestRecompressFile(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "TR26.geojson.bz2")
+	_, err := CompressFeatureCollection(from, unitFeatures("TR26 1AB"))
+	require.NoError(t, err)
+
+	to := filepath.Join(dir, "TR26.geojson.gz")
+	n, err := RecompressFile(from, to)
+	require.NoError(t, err)
+	info, err := os.Stat(to)
+	require.NoError(t, err)
+	require.Equal(t, int64(n), info.Size())
+
+	data, err := os.ReadFile(to)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x1F, 0x8B}, data[:2])
+	result, err := DecompressFeatureCollection(to)
+	require.NoError(t, err)
+	require.Equal(t, "TR26 1AB", result.Features[0].ID)
+}
+
+func TestFindFeatureCollection(t *testing.T) {
+	dir := t.TempDir()
+	for _, filename := range []string{"TR26.geojson.gz", "TR26.geojson.bz2", "EX1.geojson", "B1.geojson.zst", "B1.topojson.bz2"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, filename), nil, 0644))
+	}
+
+	filename, err := FindFeatureCollection(dir, "TR26")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "TR26.geojson.bz2"), filename) // Preferred codec
+	_, err = FindFeatureCollection(dir, "TR27")
+	require.True(t, os.IsNotExist(err))
+
+	filenames, err := ListFeatureCollections(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "B1.geojson.zst"),
+		filepath.Join(dir, "EX1.geojson"),
+		filepath.Join(dir, "TR26.geojson.bz2"),
+	}, filenames)
+
+	for _, filename := range filenames {
+		require.Equal(t, filename, filepath.Join(dir, FeatureCollectionName(filename)+".geojson"+CodecForFile(filename).Extension))
+	}
+	require.Equal(t, "TR26", FeatureCollectionName("units/TR26.geojson.bz2"))
+}
+
+func TestZstdDictionary(t *testing.T) {
+	zstdDictionary.Lock()
+	dict, dicts := zstdDictionary.dict, zstdDictionary.dicts
+	zstdDictionary.Unlock()
+	t.Cleanup(func() {
+		// The decoder is rebuilt from the dictionaries when it's next needed
+		zstdDictionary.Lock()
+		defer zstdDictionary.Unlock()
+		if decoder := zstdDictionary.decoder; decoder != nil {
+			decoder.replaced = true
+			decoder.closeIfDone()
+		}
+		zstdDictionary.dict, zstdDictionary.dicts, zstdDictionary.decoder = dict, dicts, nil
+	})
+
+	_, err := TrainZstdDictionary(nil)
+	require.Error(t, err)
+
+	var samples [][]byte
+	for i := range 200 {
+		feature := unitFeatures(fmt.Sprintf("TR%d %dAB", i%30, i)).Features[0]
+		sample, err := feature.MarshalJSON()
+		require.NoError(t, err)
+		samples = append(samples, sample)
+	}
+	d, err := TrainZstdDictionary(samples)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.geojson.zst")
+	_, err = CompressFeatureCollection(plain, unitFeatures("TR26 1AB"))
+	require.NoError(t, err)
+
+	// A file being read when the dictionary is set is read with the decoder
+	// it started with, which is then closed
+	reading, err := zstdDecoder()
+	require.NoError(t, err)
+	compressed, err := os.ReadFile(plain)
+	require.NoError(t, err)
+
+	dictFile := filepath.Join(dir, "zstd.dict")
+	require.NoError(t, os.WriteFile(dictFile, d, 0644))
+	require.NoError(t, LoadZstdDictionary(dictFile))
+
+	_, err = reading.DecodeAll(compressed, nil)
+	require.NoError(t, err)
+	reading.release()
+	_, err = reading.DecodeAll(compressed, nil)
+	require.ErrorIs(t, err, zstd.ErrDecoderClosed)
+
+	withDict := filepath.Join(dir, "dict.geojson.zst")
+	_, err = CompressFeatureCollection(withDict, unitFeatures("TR26 1AB"))
+	require.NoError(t, err)
+
+	// Files written with and without the dictionary can both be read
+	for _, filename := range []string{plain, withDict} {
+		result, err := DecompressFeatureCollection(filename)
+		require.NoError(t, err)
+		require.Equal(t, "TR26 1AB", result.Features[0].ID)
+	}
+
+	require.Error(t, SetZstdDictionary([]byte("not a dictionary")))
+	require.True(t, os.IsNotExist(LoadZstdDictionary(filepath.Join(dir, "missing.dict"))))
+}
@@ -0,0 +1,510 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// A geometry store holds every polygon file as flat arrays of coordinates and
+// offsets, which are memory-mapped rather than read, so the rings of the
+// polygons it returns point straight into the file. Nothing is decompressed
+// or copied onto the heap except each feature's ID and properties, and every
+// process serving the same file shares its pages in the OS page cache.
+// Layout (integers and coordinates are little-endian, and each section starts
+// on an 8 byte boundary):
+//
+//	magic      "PCGM"
+//	version    uint16, then 2 bytes padding
+//	sections   offset uint64 and count uint64 of each of the sections below
+//	points     count x (lon float64, lat float64)
+//	rings      count x uint32   index of each ring's first point, and one more
+//	                            for the end of the last ring
+//	polygons   count x uint32   index of each polygon's first ring, and one
+//	                            more for the end of the last polygon
+//	features   count x (id, properties (as JSON), first polygon uint32,
+//	           polygon count uint32, flags uint32, padding uint32)
+//	files      count x (target/file name, first feature uint32, feature
+//	           count uint32), sorted by name
+//	strings    count bytes, which the strings above are an offset uint32 and
+//	           length uint32 into
+//
+// Each file's features are stored together in order of their IDs, so they can
+// be binary searched in place.
+
+const GEOMETRY_STORE_VERSION = 1
+
+var geometryStoreMagic = []byte("PCGM")
+
+var ErrGeometryStoreVersion = errors.New("geometry store was written by an incompatible version")
+
+const (
+	sectionPoints = iota
+	sectionRings
+	sectionPolygons
+	sectionFeatures
+	sectionFiles
+	sectionStrings
+	sectionCount
+)
+
+const (
+	pointSize         = 16
+	featureRecordSize = 32
+	fileRecordSize    = 16
+	geometryHeaderEnd = 8 + sectionCount*16
+)
+
+const featureMultiPolygon = 1 // Feature flag for multipolygons, rather than a polygon
+
+// GeometryWriter writes a geometry store, a file of features at a time. The
+// points are written as they're added, but everything else is held until the
+// store is closed. As with packs, nothing replaces an existing store until
+// then.
+type GeometryWriter struct {
+	path     string
+	tmp      *os.File
+	w        *bufio.Writer
+	points   uint64
+	rings    []uint32
+	polygons []uint32
+	features []byte
+	files    map[string][2]uint32
+	strings  bytes.Buffer
+}
+
+func CreateGeometryStore(path string) (*GeometryWriter, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create geometry store: %w", err)
+	}
+
+	g := &GeometryWriter{
+		path:     path,
+		tmp:      tmp,
+		w:        bufio.NewWriter(tmp),
+		rings:    []uint32{0},
+		polygons: []uint32{0},
+		files:    make(map[string][2]uint32),
+	}
+	// The header is filled in on close, once the sections are all written
+	if _, err := g.w.Write(make([]byte, alignedOffset(geometryHeaderEnd))); err != nil {
+		g.Abort()
+		return nil, fmt.Errorf("failed to write geometry store: %w", err)
+	}
+	return g, nil
+}
+
+// WriteFeatureCollection adds the features of one file, e.g. a district of
+// units. Each feature needs a unique string ID, and a polygon or multipolygon.
+func (g *GeometryWriter) WriteFeatureCollection(target string, district string, fc *geojson.FeatureCollection) error {
+	name := target + "/" + district
+	if _, exists := g.files[name]; exists {
+		return fmt.Errorf("%s is already in the geometry store", name)
+	}
+
+	features := make([]*geojson.Feature, len(fc.Features))
+	copy(features, fc.Features)
+	ids := make([]string, len(features))
+	for i, feature := range features {
+		id, ok := feature.ID.(string)
+		if !ok || id == "" {
+			return fmt.Errorf("missing or invalid ID for feature in %s", name)
+		}
+		ids[i] = id
+	}
+	sort.Sort(byID{ids, features})
+
+	first := uint32(len(g.features) / featureRecordSize)
+	for i, feature := range features {
+		if i > 0 && ids[i] == ids[i-1] {
+			return fmt.Errorf("duplicate feature %s in %s", ids[i], name)
+		}
+
+		var polygons orb.MultiPolygon
+		var flags uint32
+		switch geometry := feature.Geometry.(type) {
+		case orb.Polygon:
+			polygons = orb.MultiPolygon{geometry}
+		case orb.MultiPolygon:
+			polygons = geometry
+			flags |= featureMultiPolygon
+		default:
+			return fmt.Errorf("geometry type %T of feature %s is not supported", feature.Geometry, ids[i])
+		}
+
+		properties, err := c.Marshal(feature.Properties)
+		if err != nil {
+			return fmt.Errorf("error encoding properties of feature %s: %w", ids[i], err)
+		}
+
+		record := g.appendString(nil, ids[i])
+		record = g.appendString(record, string(properties))
+		record = binary.LittleEndian.AppendUint32(record, uint32(len(g.polygons)-1))
+		record = binary.LittleEndian.AppendUint32(record, uint32(len(polygons)))
+		record = binary.LittleEndian.AppendUint32(record, flags)
+		record = binary.LittleEndian.AppendUint32(record, 0)
+		g.features = append(g.features, record...)
+
+		for _, polygon := range polygons {
+			for _, ring := range polygon {
+				if err := g.writePoints(ring); err != nil {
+					return err
+				}
+				g.rings = append(g.rings, uint32(g.points))
+			}
+			g.polygons = append(g.polygons, uint32(len(g.rings)-1))
+		}
+	}
+
+	if len(g.features)/featureRecordSize > math.MaxUint32 || len(g.rings) > math.MaxUint32 || len(g.polygons) > math.MaxUint32 || g.strings.Len() > math.MaxUint32 {
+		return errors.New("too many features for a geometry store")
+	}
+	g.files[name] = [2]uint32{first, uint32(len(features))}
+	return nil
+}
+
+// Close writes the rest of the sections and the header, and moves the store
+// into place.
+func (g *GeometryWriter) Close() error {
+	names := make([]string, 0, len(g.files))
+	for name := range g.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	files := make([]byte, 0, len(names)*fileRecordSize)
+	for _, name := range names {
+		files = g.appendString(files, name)
+		files = binary.LittleEndian.AppendUint32(files, g.files[name][0])
+		files = binary.LittleEndian.AppendUint32(files, g.files[name][1])
+	}
+
+	var sections [sectionCount][2]uint64
+	offset := uint64(alignedOffset(geometryHeaderEnd))
+	sections[sectionPoints] = [2]uint64{offset, g.points}
+	offset += g.points * pointSize
+
+	rest := []struct {
+		section int
+		count   int
+		data    []byte
+	}{
+		{sectionRings, len(g.rings), uint32s(g.rings)},
+		{sectionPolygons, len(g.polygons), uint32s(g.polygons)},
+		{sectionFeatures, len(g.features) / featureRecordSize, g.features},
+		{sectionFiles, len(names), files},
+		{sectionStrings, g.strings.Len(), g.strings.Bytes()},
+	}
+	for _, s := range rest {
+		padding := alignedOffset(int(offset)) - int(offset)
+		if _, err := g.w.Write(make([]byte, padding)); err != nil {
+			g.Abort()
+			return fmt.Errorf("failed to write geometry store: %w", err)
+		}
+		offset += uint64(padding)
+		sections[s.section] = [2]uint64{offset, uint64(s.count)}
+		if _, err := g.w.Write(s.data); err != nil {
+			g.Abort()
+			return fmt.Errorf("failed to write geometry store: %w", err)
+		}
+		offset += uint64(len(s.data))
+	}
+	if err := g.w.Flush(); err != nil {
+		g.Abort()
+		return fmt.Errorf("failed to write geometry store: %w", err)
+	}
+
+	header := append([]byte{}, geometryStoreMagic...)
+	header = binary.LittleEndian.AppendUint16(header, GEOMETRY_STORE_VERSION)
+	header = binary.LittleEndian.AppendUint16(header, 0)
+	for _, section := range sections {
+		header = binary.LittleEndian.AppendUint64(header, section[0])
+		header = binary.LittleEndian.AppendUint64(header, section[1])
+	}
+	if _, err := g.tmp.WriteAt(header, 0); err != nil {
+		g.Abort()
+		return fmt.Errorf("failed to write geometry store header: %w", err)
+	}
+	if err := g.tmp.Close(); err != nil {
+		_ = os.Remove(g.tmp.Name())
+		return fmt.Errorf("failed to close geometry store: %w", err)
+	}
+
+	if err := os.Rename(g.tmp.Name(), g.path); err != nil {
+		_ = os.Remove(g.tmp.Name())
+		return fmt.Errorf("failed to move geometry store into place: %w", err)
+	}
+	return nil
+}
+
+// Abort discards the store, leaving any existing one in place.
+func (g *GeometryWriter) Abort() {
+	_ = g.tmp.Close()
+	_ = os.Remove(g.tmp.Name())
+}
+
+func (g *GeometryWriter) writePoints(ring orb.Ring) error {
+	if g.points+uint64(len(ring)) > math.MaxUint32 {
+		return errors.New("too many points for a geometry store")
+	}
+	buf := make([]byte, 0, len(ring)*pointSize)
+	for _, point := range ring {
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(point.Lon()))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(point.Lat()))
+	}
+	if _, err := g.w.Write(buf); err != nil {
+		return fmt.Errorf("failed to write geometry store: %w", err)
+	}
+	g.points += uint64(len(ring))
+	return nil
+}
+
+// appendString adds s to the strings section, appending a reference to it.
+func (g *GeometryWriter) appendString(b []byte, s string) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(g.strings.Len()))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(s)))
+	g.strings.WriteString(s)
+	return b
+}
+
+func uint32s(values []uint32) []byte {
+	b := make([]byte, 0, 4*len(values))
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func alignedOffset(offset int) int {
+	return (offset + 7) &^ 7
+}
+
+// GeometryStore reads features from a memory-mapped store written by
+// GeometryWriter. The geometry of the features it returns is backed by the
+// mapping, so is only valid until the store is closed, and must be cloned
+// before being changed, as with any features shared by a PolygonsRepo. It's
+// safe for concurrent use.
+type GeometryStore struct {
+	path     string
+	data     []byte
+	unmap    func() error
+	points   []byte
+	rings    []byte
+	polygons []byte
+	features []byte
+	files    []byte
+	strings  []byte
+}
+
+// OpenGeometryStore maps a geometry store into memory, after checking its
+// version and that its sections are all within the file.
+func OpenGeometryStore(path string) (*GeometryStore, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	store, err := newGeometryStore(path, data)
+	if err != nil {
+		_ = unmap()
+		return nil, err
+	}
+	store.unmap = unmap
+	return store, nil
+}
+
+func newGeometryStore(path string, data []byte) (*GeometryStore, error) {
+	if len(data) < geometryHeaderEnd || !bytes.Equal(data[:len(geometryStoreMagic)], geometryStoreMagic) {
+		return nil, fmt.Errorf("%s is not a geometry store", path)
+	}
+	if version := binary.LittleEndian.Uint16(data[len(geometryStoreMagic):]); version != GEOMETRY_STORE_VERSION {
+		return nil, fmt.Errorf("%w (version %d, expected %d)", ErrGeometryStoreVersion, version, GEOMETRY_STORE_VERSION)
+	}
+
+	sizes := [sectionCount]uint64{pointSize, 4, 4, featureRecordSize, fileRecordSize, 1}
+	var sections [sectionCount][]byte
+	for i := range sections {
+		offset := binary.LittleEndian.Uint64(data[8+16*i:])
+		count := binary.LittleEndian.Uint64(data[16+16*i:])
+		if offset%8 != 0 || offset > uint64(len(data)) || count > (uint64(len(data))-offset)/sizes[i] {
+			return nil, fmt.Errorf("section %d of geometry store %s is out of range", i, path)
+		}
+		sections[i] = data[offset : offset+count*sizes[i] : offset+count*sizes[i]]
+	}
+	if len(sections[sectionRings]) == 0 || len(sections[sectionPolygons]) == 0 {
+		return nil, fmt.Errorf("geometry store %s has no ring or polygon offsets", path)
+	}
+
+	return &GeometryStore{
+		path:     path,
+		data:     data,
+		points:   sections[sectionPoints],
+		rings:    sections[sectionRings],
+		polygons: sections[sectionPolygons],
+		features: sections[sectionFeatures],
+		files:    sections[sectionFiles],
+		strings:  sections[sectionStrings],
+	}, nil
+}
+
+// Close unmaps the store, after which none of the features it returned can
+// be used.
+func (g *GeometryStore) Close() error {
+	return g.unmap()
+}
+
+// lookup finds the range of features in a file. As with the individual files,
+// it fails with an error satisfying os.IsNotExist if the store doesn't have
+// the file.
+func (g *GeometryStore) lookup(target string, district string) (int, int, error) {
+	name := target + "/" + district
+	count := len(g.files) / fileRecordSize
+	i := sort.Search(count, func(i int) bool {
+		return string(g.string(g.files[i*fileRecordSize:])) >= name
+	})
+	if i == count || string(g.string(g.files[i*fileRecordSize:])) != name {
+		return 0, 0, &os.PathError{Op: "open", Path: fmt.Sprintf("%s:%s", g.path, name), Err: os.ErrNotExist}
+	}
+
+	record := g.files[i*fileRecordSize:]
+	first := int(binary.LittleEndian.Uint32(record[8:]))
+	n := int(binary.LittleEndian.Uint32(record[12:]))
+	if first+n > len(g.features)/featureRecordSize {
+		return 0, 0, fmt.Errorf("features of %s are out of range in geometry store", name)
+	}
+	return first, n, nil
+}
+
+func (g *GeometryStore) FeatureCollection(target string, district string) (*geojson.FeatureCollection, error) {
+	first, n, err := g.lookup(target, district)
+	if err != nil {
+		return nil, err
+	}
+
+	fc := geojson.NewFeatureCollection()
+	fc.Features = make([]*geojson.Feature, 0, n)
+	for i := first; i < first+n; i++ {
+		feature, err := g.feature(i)
+		if err != nil {
+			return nil, err
+		}
+		fc.Append(feature)
+	}
+	return fc, nil
+}
+
+// Feature finds a single feature in a file, returning nil if the file doesn't
+// have it.
+func (g *GeometryStore) Feature(target string, district string, id string) (*geojson.Feature, error) {
+	first, n, err := g.lookup(target, district)
+	if err != nil {
+		return nil, err
+	}
+
+	i := sort.Search(n, func(i int) bool {
+		return string(g.string(g.features[(first+i)*featureRecordSize:])) >= id
+	})
+	if i == n || string(g.string(g.features[(first+i)*featureRecordSize:])) != id {
+		return nil, nil
+	}
+	return g.feature(first + i)
+}
+
+func (g *GeometryStore) feature(i int) (*geojson.Feature, error) {
+	record := g.features[i*featureRecordSize : (i+1)*featureRecordSize]
+	id := string(g.string(record))
+
+	first := int(binary.LittleEndian.Uint32(record[16:]))
+	n := int(binary.LittleEndian.Uint32(record[20:]))
+	polygons := make(orb.MultiPolygon, n)
+	for j := range polygons {
+		polygon, err := g.polygon(first + j)
+		if err != nil {
+			return nil, fmt.Errorf("invalid geometry for feature %s in geometry store: %w", id, err)
+		}
+		polygons[j] = polygon
+	}
+
+	var geometry orb.Geometry = polygons
+	if binary.LittleEndian.Uint32(record[24:])&featureMultiPolygon == 0 {
+		if len(polygons) != 1 {
+			return nil, fmt.Errorf("invalid geometry for feature %s in geometry store: %d polygons", id, len(polygons))
+		}
+		geometry = polygons[0]
+	}
+
+	feature := geojson.NewFeature(geometry)
+	feature.ID = id
+	if err := c.Unmarshal(g.string(record[8:]), &feature.Properties); err != nil {
+		return nil, fmt.Errorf("invalid properties for feature %s in geometry store: %w", id, err)
+	}
+	return feature, nil
+}
+
+func (g *GeometryStore) polygon(i int) (orb.Polygon, error) {
+	start, end, err := offsetRange(g.polygons, i, len(g.rings)/4-1)
+	if err != nil {
+		return nil, err
+	}
+	polygon := make(orb.Polygon, end-start)
+	for j := range polygon {
+		first, last, err := offsetRange(g.rings, start+j, len(g.points)/pointSize)
+		if err != nil {
+			return nil, err
+		}
+		polygon[j] = orb.Ring(pointsOf(g.points[first*pointSize : last*pointSize : last*pointSize]))
+	}
+	return polygon, nil
+}
+
+// offsetRange reads the ith range from a section of offsets, checking that it
+// lies within the count of things the offsets refer to.
+func offsetRange(offsets []byte, i int, count int) (int, int, error) {
+	if i < 0 || (i+2)*4 > len(offsets) {
+		return 0, 0, fmt.Errorf("offset %d is out of range", i)
+	}
+	start := int(binary.LittleEndian.Uint32(offsets[i*4:]))
+	end := int(binary.LittleEndian.Uint32(offsets[(i+1)*4:]))
+	if start > end || end > count {
+		return 0, 0, fmt.Errorf("range %d-%d is out of range", start, end)
+	}
+	return start, end, nil
+}
+
+// string returns the string referred to at the start of the record, or
+// nothing if the reference is out of range.
+func (g *GeometryStore) string(record []byte) []byte {
+	offset := uint64(binary.LittleEndian.Uint32(record))
+	length := uint64(binary.LittleEndian.Uint32(record[4:]))
+	if offset+length > uint64(len(g.strings)) {
+		return nil
+	}
+	return g.strings[offset : offset+length]
+}
+
+// MappedPolygonsRepo serves polygons from a memory-mapped geometry store.
+// Features are cheap to read from the store, and their geometry doesn't use
+// the heap, so unlike the other repos it has no cache.
+type MappedPolygonsRepo struct {
+	store *GeometryStore
+}
+
+func NewMappedPolygonsRepo(store *GeometryStore) PolygonsRepo {
+	return &MappedPolygonsRepo{store: store}
+}
+
+func (mp *MappedPolygonsRepo) RetrieveFeatureCollection(target string, district string) (*geojson.FeatureCollection, error) {
+	return mp.store.FeatureCollection(target, district)
+}
+
+func (mp *MappedPolygonsRepo) RetrieveFeature(target string, district string, id string) (*geojson.Feature, error) {
+	return mp.store.Feature(target, district, id)
+}
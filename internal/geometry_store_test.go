@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestGeometryStore(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "polygons.geom")
+	store, err := CreateGeometryStore(path)
+	require.NoError(t, err)
+
+	units := unitFeatures("TR26 2AB", "TR26 1AB", "TR26 1ZZ")
+	units.Features[2].Geometry = orb.Polygon{
+		{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}},
+		{{1, 1}, {1, 2}, {2, 2}, {2, 1}, {1, 1}},
+	}
+	require.NoError(t, store.WriteFeatureCollection("units", "TR26", units))
+	require.NoError(t, store.WriteFeatureCollection("units", "EX1", geojson.NewFeatureCollection()))
+	districts := unitFeatures("TR26")
+	districts.Features[0].Geometry = orb.MultiPolygon{
+		{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}},
+		{{{2, 2}, {3, 2}, {3, 3}, {2, 2}}},
+	}
+	districts.Features[0].Properties["type"] = "district"
+	require.NoError(t, store.WriteFeatureCollection("districts", "TR26", districts))
+	require.NoError(t, store.Close())
+	return path
+}
+
+func TestGeometryStore(t *testing.T) {
+	store, err := OpenGeometryStore(writeTestGeometryStore(t))
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	fc, err := store.FeatureCollection("units", "TR26")
+	require.NoError(t, err)
+	require.Len(t, fc.Features, 3)
+	require.Equal(t, []any{"TR26 1AB", "TR26 1ZZ", "TR26 2AB"}, []any{fc.Features[0].ID, fc.Features[1].ID, fc.Features[2].ID}) // Sorted by ID
+	require.Equal(t, orb.Polygon{{{1, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 0}}}, fc.Features[0].Geometry)
+	require.Equal(t, orb.Polygon{
+		{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}},
+		{{1, 1}, {1, 2}, {2, 2}, {2, 1}, {1, 1}},
+	}, fc.Features[1].Geometry)
+	require.Equal(t, geojson.Properties{"type": "unit"}, fc.Features[2].Properties)
+
+	fc, err = store.FeatureCollection("units", "EX1")
+	require.NoError(t, err)
+	require.Empty(t, fc.Features)
+
+	feature, err := store.Feature("districts", "TR26", "TR26")
+	require.NoError(t, err)
+	require.Equal(t, orb.MultiPolygon{
+		{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}},
+		{{{2, 2}, {3, 2}, {3, 3}, {2, 2}}},
+	}, feature.Geometry)
+	require.Equal(t, "district", feature.Properties["type"])
+
+	for _, id := range []string{"TR26 1AB", "TR26 1ZZ", "TR26 2AB"} {
+		feature, err := store.Feature("units", "TR26", id)
+		require.NoError(t, err)
+		require.Equal(t, id, feature.ID)
+	}
+
+	// Features that aren't in the file
+	for _, id := range []string{"TR26 1AA", "TR26 1", "TR26 3AB", ""} {
+		feature, err := store.Feature("units", "TR26", id)
+		require.NoError(t, err)
+		require.Nil(t, feature, id)
+	}
+
+	// Files that aren't in the store
+	_, err = store.FeatureCollection("units", "TR27")
+	require.True(t, os.IsNotExist(err))
+	_, err = store.Feature("sectors", "TR26", "TR26 1")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestGeometryStore_ZeroCopy(t *testing.T) {
+	if !ZERO_COPY_POINTS {
+		t.Skip("points are copied on this platform")
+	}
+
+	store, err := OpenGeometryStore(writeTestGeometryStore(t))
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	feature, err := store.Feature("units", "TR26", "TR26 1ZZ")
+	require.NoError(t, err)
+	start := uintptr(unsafe.Pointer(&store.data[0]))
+	for _, ring := range feature.Geometry.(orb.Polygon) {
+		point := uintptr(unsafe.Pointer(&ring[0]))
+		require.True(t, point >= start && point < start+uintptr(len(store.data)))
+		require.Equal(t, len(ring), cap(ring)) // So appending can't overwrite the next ring
+	}
+}
+
+func TestGeometryWriter_Invalid(t *testing.T) {
+	store, err := CreateGeometryStore(filepath.Join(t.TempDir(), "polygons.geom"))
+	require.NoError(t, err)
+	defer store.Abort()
+
+	require.EqualError(t, store.WriteFeatureCollection("units", "TR26", unitFeatures("TR26 1AB", "TR26 1AB")), "duplicate feature TR26 1AB in units/TR26")
+	fc := unitFeatures("TR26 1AB")
+	fc.Features[0].ID = nil
+	require.EqualError(t, store.WriteFeatureCollection("units", "TR27", fc), "missing or invalid ID for feature in units/TR27")
+	fc = unitFeatures("TR26 1AB")
+	fc.Features[0].Geometry = orb.Point{1, 2}
+	require.EqualError(t, store.WriteFeatureCollection("units", "TR28", fc), "geometry type orb.Point of feature TR26 1AB is not supported")
+	require.NoError(t, store.WriteFeatureCollection("units", "TR29", unitFeatures("TR29 1AB")))
+	require.EqualError(t, store.WriteFeatureCollection("units", "TR29", unitFeatures("TR29 1AB")), "units/TR29 is already in the geometry store")
+}
+
+func TestOpenGeometryStore_Invalid(t *testing.T) {
+	_, err := OpenGeometryStore(filepath.Join(t.TempDir(), "missing.geom"))
+	require.True(t, os.IsNotExist(err))
+
+	path := writeTestGeometryStore(t)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	for _, invalid := range [][]byte{nil, []byte("not a geometry store at all")} {
+		require.NoError(t, os.WriteFile(path, invalid, 0644))
+		_, err = OpenGeometryStore(path)
+		require.ErrorContains(t, err, "is not a geometry store")
+	}
+
+	version := append([]byte{}, data...)
+	version[4] = 99
+	require.NoError(t, os.WriteFile(path, version, 0644))
+	_, err = OpenGeometryStore(path)
+	require.ErrorIs(t, err, ErrGeometryStoreVersion)
+
+	require.NoError(t, os.WriteFile(path, data[:len(data)-1], 0644))
+	_, err = OpenGeometryStore(path)
+	require.ErrorContains(t, err, "is out of range")
+}
+
+func TestMappedPolygonsRepo(t *testing.T) {
+	store, err := OpenGeometryStore(writeTestGeometryStore(t))
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+	repo := NewMappedPolygonsRepo(store)
+
+	fc, err := repo.RetrieveFeatureCollection("units", "TR26")
+	require.NoError(t, err)
+	require.Len(t, fc.Features, 3)
+
+	feature, err := repo.RetrieveFeature("units", "TR26", "TR26 2AB")
+	require.NoError(t, err)
+	require.Equal(t, "TR26 2AB", feature.ID)
+
+	_, err = repo.RetrieveFeature("units", "TR27", "TR27 1AB")
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package internal
+
+import "os"
+
+// mapFile reads the whole file into memory, on platforms without mmap. The
+// store then works as normal, but isn't shared between processes.
+func mapFile(path string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mapFile maps the whole file into memory. The mapping is private and
+// writable, so that a stray write to a shared geometry only copies the page it
+// touches, rather than crashing the server or changing the file. Pages that
+// aren't written to are shared with every other process mapping the file.
+func mapFile(path string) ([]byte, func() error, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = file.Close() }() // The mapping outlives the file
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Size() == 0 {
+		return nil, func() error { return nil }, nil
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to map %s: %w", path, err)
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
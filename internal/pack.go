@@ -0,0 +1,495 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kofalt/go-memoize"
+	"github.com/paulmach/orb/geojson"
+)
+
+// A pack holds every polygon file in one archive, with each feature compressed
+// on its own, so a single feature can be read without decompressing the rest
+// of its file. Layout (integers are little-endian, strings are prefixed with a
+// uvarint length):
+//
+//	magic      "PCPK"
+//	version    uint16
+//	features   DEFLATE compressed GeoJSON of each feature, with the features of
+//	           each file stored together in order of their IDs
+//	index      uvarint count, count x (target string, uvarint count, count x
+//	           (file string, start uint64, uvarint count, width uint8,
+//	           count x (id [width]byte, end uint32)))
+//	trailer    offset of the index uint64, CRC-32 (IEEE) of the index uint32
+//
+// Each file's features start at its start offset, and each ends at its end
+// offset relative to that. IDs are padded to the widest in the file with NUL
+// bytes, so that they can be binary searched in place.
+
+const PACK_VERSION = 1
+
+var packMagic = []byte("PCPK")
+
+var ErrPackVersion = errors.New("pack was written by an incompatible version")
+var ErrPackChecksum = errors.New("pack index checksum does not match, file may be corrupt")
+
+const packTrailerSize = 8 + 4
+
+// PackWriter writes a pack, a file of features at a time. Nothing replaces an
+// existing pack until it's closed, so that a running server never sees a
+// partly written file.
+type PackWriter struct {
+	path    string
+	tmp     *os.File
+	w       *bufio.Writer
+	offset  uint64
+	targets map[string]map[string]*packEntry
+	order   []string
+	buf     bytes.Buffer
+	flate   *flate.Writer
+}
+
+type packEntry struct {
+	start uint64
+	ids   []string
+	ends  []uint32
+}
+
+func CreatePack(path string) (*PackWriter, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pack file: %w", err)
+	}
+
+	p := &PackWriter{
+		path:    path,
+		tmp:     tmp,
+		w:       bufio.NewWriter(tmp),
+		targets: make(map[string]map[string]*packEntry),
+	}
+	p.flate, _ = flate.NewWriter(&p.buf, flate.BestCompression) // Only fails for invalid levels
+	header := binary.LittleEndian.AppendUint16(append([]byte{}, packMagic...), PACK_VERSION)
+	if err := p.write(header); err != nil {
+		p.Abort()
+		return nil, err
+	}
+	return p, nil
+}
+
+// WriteFeatureCollection adds the features of one file, e.g. a district of
+// units. Each feature needs a unique string ID.
+func (p *PackWriter) WriteFeatureCollection(target string, district string, fc *geojson.FeatureCollection) error {
+	files, ok := p.targets[target]
+	if !ok {
+		files = make(map[string]*packEntry)
+		p.targets[target] = files
+		p.order = append(p.order, target)
+	}
+	if _, exists := files[district]; exists {
+		return fmt.Errorf("%s/%s is already in the pack", target, district)
+	}
+
+	features := make([]*geojson.Feature, len(fc.Features))
+	copy(features, fc.Features)
+	ids := make([]string, len(features))
+	for i, feature := range features {
+		id, ok := feature.ID.(string)
+		if !ok || id == "" || strings.ContainsRune(id, 0) || len(id) > 255 {
+			return fmt.Errorf("missing or invalid ID for feature in %s/%s", target, district)
+		}
+		ids[i] = id
+	}
+	sort.Sort(byID{ids, features})
+
+	entry := &packEntry{start: p.offset, ids: ids, ends: make([]uint32, len(features))}
+	var end uint32
+	for i, feature := range features {
+		if i > 0 && ids[i] == ids[i-1] {
+			return fmt.Errorf("duplicate feature %s in %s/%s", ids[i], target, district)
+		}
+
+		data, err := c.Marshal(feature)
+		if err != nil {
+			return fmt.Errorf("error encoding feature %s: %w", ids[i], err)
+		}
+		p.buf.Reset()
+		p.flate.Reset(&p.buf)
+		_, _ = p.flate.Write(data)
+		if err := p.flate.Close(); err != nil {
+			return fmt.Errorf("error compressing feature %s: %w", ids[i], err)
+		}
+		if err := p.write(p.buf.Bytes()); err != nil {
+			return err
+		}
+
+		end += uint32(p.buf.Len())
+		entry.ends[i] = end
+	}
+	files[district] = entry
+	return nil
+}
+
+// Close writes the index, and moves the pack into place.
+func (p *PackWriter) Close() error {
+	index := binary.AppendUvarint(nil, uint64(len(p.order)))
+	for _, target := range p.order {
+		index = appendPackString(index, target)
+		files := p.targets[target]
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		index = binary.AppendUvarint(index, uint64(len(names)))
+		for _, name := range names {
+			entry := files[name]
+			width := 0
+			for _, id := range entry.ids {
+				width = max(width, len(id))
+			}
+
+			index = appendPackString(index, name)
+			index = binary.LittleEndian.AppendUint64(index, entry.start)
+			index = binary.AppendUvarint(index, uint64(len(entry.ids)))
+			index = append(index, uint8(width))
+			for i, id := range entry.ids {
+				index = append(index, id...)
+				index = append(index, make([]byte, width-len(id))...)
+				index = binary.LittleEndian.AppendUint32(index, entry.ends[i])
+			}
+		}
+	}
+
+	trailer := binary.LittleEndian.AppendUint64(nil, p.offset)
+	trailer = binary.LittleEndian.AppendUint32(trailer, crc32.ChecksumIEEE(index))
+	if err := p.write(index); err != nil {
+		p.Abort()
+		return err
+	}
+	if err := p.write(trailer); err != nil {
+		p.Abort()
+		return err
+	}
+	if err := p.w.Flush(); err != nil {
+		p.Abort()
+		return fmt.Errorf("failed to write pack: %w", err)
+	}
+	if err := p.tmp.Close(); err != nil {
+		_ = os.Remove(p.tmp.Name())
+		return fmt.Errorf("failed to close pack file: %w", err)
+	}
+
+	if err := os.Rename(p.tmp.Name(), p.path); err != nil {
+		_ = os.Remove(p.tmp.Name())
+		return fmt.Errorf("failed to move pack into place: %w", err)
+	}
+	return nil
+}
+
+// Abort discards the pack, leaving any existing one in place.
+func (p *PackWriter) Abort() {
+	_ = p.tmp.Close()
+	_ = os.Remove(p.tmp.Name())
+}
+
+func (p *PackWriter) write(data []byte) error {
+	if _, err := p.w.Write(data); err != nil {
+		return fmt.Errorf("failed to write pack: %w", err)
+	}
+	p.offset += uint64(len(data))
+	return nil
+}
+
+func appendPackString(b []byte, s string) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+}
+
+// byID sorts features along with their IDs.
+type byID struct {
+	ids      []string
+	features []*geojson.Feature
+}
+
+func (b byID) Len() int           { return len(b.ids) }
+func (b byID) Less(i, j int) bool { return b.ids[i] < b.ids[j] }
+func (b byID) Swap(i, j int) {
+	b.ids[i], b.ids[j] = b.ids[j], b.ids[i]
+	b.features[i], b.features[j] = b.features[j], b.features[i]
+}
+
+// Pack reads features from a pack written by PackWriter. Only the index is
+// held in memory, and it's safe for concurrent use.
+type Pack struct {
+	path    string
+	file    *os.File
+	targets map[string]map[string]packFile
+}
+
+// packFile is the index of one file's features. Its records are the IDs and
+// end offsets, still in their encoded form.
+type packFile struct {
+	start   uint64
+	count   int
+	width   int
+	records []byte
+}
+
+// OpenPack reads the index of a pack, after checking its version and checksum.
+func OpenPack(path string) (*Pack, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	pack, err := readPack(path, file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return pack, nil
+}
+
+func readPack(path string, file *os.File) (*Pack, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(packMagic)+2)
+	if info.Size() < int64(len(header)+packTrailerSize) {
+		return nil, fmt.Errorf("%s is not a polygon pack", path)
+	}
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("failed to read pack: %w", err)
+	}
+	if !bytes.Equal(header[:len(packMagic)], packMagic) {
+		return nil, fmt.Errorf("%s is not a polygon pack", path)
+	}
+	if version := binary.LittleEndian.Uint16(header[len(packMagic):]); version != PACK_VERSION {
+		return nil, fmt.Errorf("%w (version %d, expected %d)", ErrPackVersion, version, PACK_VERSION)
+	}
+
+	trailer := make([]byte, packTrailerSize)
+	if _, err := file.ReadAt(trailer, info.Size()-packTrailerSize); err != nil {
+		return nil, fmt.Errorf("failed to read pack: %w", err)
+	}
+	start := binary.LittleEndian.Uint64(trailer)
+	if start < uint64(len(header)) || start > uint64(info.Size()-packTrailerSize) {
+		return nil, fmt.Errorf("pack index offset %d is out of range", start)
+	}
+	index := make([]byte, uint64(info.Size()-packTrailerSize)-start)
+	if _, err := file.ReadAt(index, int64(start)); err != nil {
+		return nil, fmt.Errorf("failed to read pack index: %w", err)
+	}
+	if crc32.ChecksumIEEE(index) != binary.LittleEndian.Uint32(trailer[8:]) {
+		return nil, ErrPackChecksum
+	}
+
+	pack := &Pack{path: path, file: file, targets: make(map[string]map[string]packFile)}
+	r := &packReader{data: index}
+	targets := r.uvarint()
+	for i := uint64(0); i < targets && r.err == nil; i++ {
+		target := r.string()
+		count := r.uvarint()
+		files := make(map[string]packFile)
+		for j := uint64(0); j < count && r.err == nil; j++ {
+			name := r.string()
+			f := packFile{start: r.uint64(), count: int(r.uvarint()), width: int(r.uint8())}
+			f.records = r.next(f.count * (f.width + 4))
+			files[name] = f
+		}
+		pack.targets[target] = files
+	}
+	if r.err == nil && len(r.data) > 0 {
+		r.err = fmt.Errorf("%d unexpected bytes at end of pack index", len(r.data))
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("failed to read pack index: %w", r.err)
+	}
+	return pack, nil
+}
+
+func (p *Pack) Close() error {
+	return p.file.Close()
+}
+
+func (p *Pack) lookup(target string, district string) (packFile, error) {
+	f, ok := p.targets[target][district]
+	if !ok {
+		return packFile{}, &os.PathError{Op: "open", Path: fmt.Sprintf("%s:%s/%s", p.path, target, district), Err: os.ErrNotExist}
+	}
+	return f, nil
+}
+
+// FeatureCollection reads all the features of a file, which are stored
+// together so only need one read. As with the individual files, it fails with
+// an error satisfying os.IsNotExist if the pack doesn't have the file.
+func (p *Pack) FeatureCollection(target string, district string) (*geojson.FeatureCollection, error) {
+	f, err := p.lookup(target, district)
+	if err != nil {
+		return nil, err
+	}
+
+	fc := geojson.NewFeatureCollection()
+	if f.count == 0 {
+		return fc, nil
+	}
+	data := make([]byte, f.end(f.count-1))
+	if _, err := p.file.ReadAt(data, int64(f.start)); err != nil {
+		return nil, fmt.Errorf("failed to read %s/%s from pack: %w", target, district, err)
+	}
+
+	var start uint32
+	for i := 0; i < f.count; i++ {
+		end := f.end(i)
+		if end < start || end > uint32(len(data)) {
+			return nil, fmt.Errorf("invalid offsets for %s/%s in pack", target, district)
+		}
+		feature, err := decodePackedFeature(data[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read feature %s from pack: %w", f.id(i), err)
+		}
+		fc.Append(feature)
+		start = end
+	}
+	return fc, nil
+}
+
+// Feature reads a single feature from a file, with one read, returning nil if
+// the file doesn't have it.
+func (p *Pack) Feature(target string, district string, id string) (*geojson.Feature, error) {
+	f, err := p.lookup(target, district)
+	if err != nil {
+		return nil, err
+	}
+
+	i := sort.Search(f.count, func(i int) bool { return f.id(i) >= id })
+	if i == f.count || f.id(i) != id {
+		return nil, nil
+	}
+
+	var start uint32
+	if i > 0 {
+		start = f.end(i - 1)
+	}
+	end := f.end(i)
+	if end < start {
+		return nil, fmt.Errorf("invalid offsets for %s/%s in pack", target, district)
+	}
+	data := make([]byte, end-start)
+	if _, err := p.file.ReadAt(data, int64(f.start)+int64(start)); err != nil {
+		return nil, fmt.Errorf("failed to read feature %s from pack: %w", id, err)
+	}
+	feature, err := decodePackedFeature(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feature %s from pack: %w", id, err)
+	}
+	return feature, nil
+}
+
+func (f packFile) id(i int) string {
+	record := f.records[i*(f.width+4) : i*(f.width+4)+f.width]
+	return string(bytes.TrimRight(record, "\x00"))
+}
+
+func (f packFile) end(i int) uint32 {
+	return binary.LittleEndian.Uint32(f.records[i*(f.width+4)+f.width:])
+}
+
+func decodePackedFeature(data []byte) (*geojson.Feature, error) {
+	decompressed, err := io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	return geojson.UnmarshalFeature(decompressed)
+}
+
+// packReader decodes values from the pack index, recording the first error
+// rather than returning it from every call.
+type packReader struct {
+	data []byte
+	err  error
+}
+
+func (r *packReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.data) < n {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *packReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *packReader) string() string {
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		r.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+func (r *packReader) uint8() uint8 {
+	if b := r.next(1); len(b) == 1 {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *packReader) uint64() uint64 {
+	if b := r.next(8); len(b) == 8 {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// PackedPolygonsRepo serves polygons from a pack, rather than individual
+// files. Whole files are cached as with CachedPolygonsRepo, but single
+// features are read straight from the pack.
+type PackedPolygonsRepo struct {
+	pack  *Pack
+	cache *memoize.Memoizer
+}
+
+func NewPackedPolygonsRepo(pack *Pack, cache *memoize.Memoizer) PolygonsRepo {
+	return &PackedPolygonsRepo{pack: pack, cache: cache}
+}
+
+func (pp *PackedPolygonsRepo) RetrieveFeatureCollection(target string, district string) (*geojson.FeatureCollection, error) {
+	key := fmt.Sprintf("pack:%s/%s", target, district)
+	featureCollection, err, _ := memoize.Call(pp.cache, key, func() (*geojson.FeatureCollection, error) {
+		return pp.pack.FeatureCollection(target, district)
+	})
+	return featureCollection, err
+}
+
+func (pp *PackedPolygonsRepo) RetrieveFeature(target string, district string, id string) (*geojson.Feature, error) {
+	return pp.pack.Feature(target, district, id)
+}
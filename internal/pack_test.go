@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kofalt/go-memoize"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+func unitFeatures(ids ...string) *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+	for i, id := range ids {
+		x := float64(i)
+		feature := geojson.NewFeature(orb.Polygon{{{x, 0}, {x + 1, 0}, {x + 1, 1}, {x, 1}, {x, 0}}})
+		feature.ID = id
+		feature.Properties["type"] = "unit"
+		fc.Append(feature)
+	}
+	return fc
+}
+
+func writeTestPack(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "polygons.pack")
+	pack, err := CreatePack(path)
+	require.NoError(t, err)
+	require.NoError(t, pack.WriteFeatureCollection("units", "TR26", unitFeatures("TR26 2AB", "TR26 1AB", "TR26 1ZZ")))
+	require.NoError(t, pack.WriteFeatureCollection("units", "B1", unitFeatures("B1 1AA")))
+	require.NoError(t, pack.WriteFeatureCollection("units", "EX1", geojson.NewFeatureCollection()))
+	require.NoError(t, pack.WriteFeatureCollection("districts", "TR", unitFeatures("TR26")))
+	require.NoError(t, pack.Close())
+	return path
+}
+
+func TestPack(t *testing.T) {
+	pack, err := OpenPack(writeTestPack(t))
+	require.NoError(t, err)
+	defer func() { _ = pack.Close() }()
+
+	fc, err := pack.FeatureCollection("units", "TR26")
+	require.NoError(t, err)
+	require.Len(t, fc.Features, 3)
+	expected := unitFeatures("TR26 2AB", "TR26 1AB", "TR26 1ZZ")
+	require.Equal(t, "TR26 1AB", fc.Features[0].ID) // Sorted by ID
+	require.Equal(t, expected.Features[1].Geometry, fc.Features[0].Geometry)
+	require.Equal(t, "TR26 2AB", fc.Features[2].ID)
+	require.Equal(t, expected.Features[0].Geometry, fc.Features[2].Geometry)
+	require.Equal(t, "unit", fc.Features[2].Properties["type"])
+
+	fc, err = pack.FeatureCollection("units", "EX1")
+	require.NoError(t, err)
+	require.Empty(t, fc.Features)
+
+	for _, id := range []string{"TR26 1AB", "TR26 1ZZ", "TR26 2AB"} {
+		feature, err := pack.Feature("units", "TR26", id)
+		require.NoError(t, err)
+		require.Equal(t, id, feature.ID)
+	}
+	feature, err := pack.Feature("units", "B1", "B1 1AA")
+	require.NoError(t, err)
+	require.Equal(t, orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}}, feature.Geometry)
+	feature, err = pack.Feature("districts", "TR", "TR26")
+	require.NoError(t, err)
+	require.Equal(t, "TR26", feature.ID)
+
+	// Features that aren't in the file
+	for _, id := range []string{"TR26 1AA", "TR26 1", "TR26 3AB", ""} {
+		feature, err := pack.Feature("units", "TR26", id)
+		require.NoError(t, err)
+		require.Nil(t, feature, id)
+	}
+
+	// Files that aren't in the pack
+	_, err = pack.FeatureCollection("units", "TR27")
+	require.True(t, os.IsNotExist(err))
+	_, err = pack.Feature("sectors", "TR26", "TR26 1")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestPackWriter_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "polygons.pack")
+	pack, err := CreatePack(path)
+	require.NoError(t, err)
+	defer pack.Abort()
+
+	require.EqualError(t, pack.WriteFeatureCollection("units", "TR26", unitFeatures("TR26 1AB", "TR26 1AB")), "duplicate feature TR26 1AB in units/TR26")
+	fc := unitFeatures("TR26 1AB")
+	fc.Features[0].ID = 1
+	require.EqualError(t, pack.WriteFeatureCollection("units", "TR27", fc), "missing or invalid ID for feature in units/TR27")
+	require.NoError(t, pack.WriteFeatureCollection("units", "TR28", unitFeatures("TR28 1AB")))
+	require.EqualError(t, pack.WriteFeatureCollection("units", "TR28", unitFeatures("TR28 1AB")), "units/TR28 is already in the pack")
+}
+
+func TestPackWriter_Abort(t *testing.T) {
+	path := writeTestPack(t)
+	pack, err := CreatePack(path)
+	require.NoError(t, err)
+	require.NoError(t, pack.WriteFeatureCollection("units", "TR26", unitFeatures("TR26 1AB")))
+	pack.Abort()
+
+	// The existing pack is left alone, and nothing else
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	opened, err := OpenPack(path)
+	require.NoError(t, err)
+	defer func() { _ = opened.Close() }()
+	fc, err := opened.FeatureCollection("units", "TR26")
+	require.NoError(t, err)
+	require.Len(t, fc.Features, 3)
+}
+
+func TestOpenPack_Invalid(t *testing.T) {
+	_, err := OpenPack(filepath.Join(t.TempDir(), "missing.pack"))
+	require.True(t, os.IsNotExist(err))
+
+	path := writeTestPack(t)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	notPack := filepath.Join(t.TempDir(), "not.pack")
+	require.NoError(t, os.WriteFile(notPack, []byte("not a polygon pack at all"), 0644))
+	_, err = OpenPack(notPack)
+	require.ErrorContains(t, err, "is not a polygon pack")
+
+	version := append([]byte{}, data...)
+	version[4] = 99
+	require.NoError(t, os.WriteFile(path, version, 0644))
+	_, err = OpenPack(path)
+	require.ErrorIs(t, err, ErrPackVersion)
+
+	corrupt := append([]byte{}, data...)
+	corrupt[len(corrupt)-packTrailerSize-1] ^= 0xFF
+	require.NoError(t, os.WriteFile(path, corrupt, 0644))
+	_, err = OpenPack(path)
+	require.ErrorIs(t, err, ErrPackChecksum)
+
+	truncated := data[:len(data)-1]
+	require.NoError(t, os.WriteFile(path, truncated, 0644))
+	_, err = OpenPack(path)
+	require.Error(t, err)
+}
+
+func TestPackedPolygonsRepo(t *testing.T) {
+	pack, err := OpenPack(writeTestPack(t))
+	require.NoError(t, err)
+	defer func() { _ = pack.Close() }()
+	repo := NewPackedPolygonsRepo(pack, memoize.NewMemoizer(time.Minute, time.Minute))
+
+	fc, err := repo.RetrieveFeatureCollection("units", "TR26")
+	require.NoError(t, err)
+	require.Len(t, fc.Features, 3)
+	cached, err := repo.RetrieveFeatureCollection("units", "TR26")
+	require.NoError(t, err)
+	require.Same(t, fc, cached)
+
+	feature, err := repo.RetrieveFeature("units", "TR26", "TR26 1ZZ")
+	require.NoError(t, err)
+	require.Equal(t, "TR26 1ZZ", feature.ID)
+
+	_, err = repo.RetrieveFeatureCollection("units", "TR27")
+	require.True(t, os.IsNotExist(err))
+}
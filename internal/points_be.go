@@ -0,0 +1,27 @@
+//go:build !(386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64 || wasm)
+
+package internal
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/paulmach/orb"
+)
+
+// ZERO_COPY_POINTS is whether pointsOf returns points backed by the store
+// itself, which needs the coordinates to be in native byte order.
+const ZERO_COPY_POINTS = false
+
+// pointsOf decodes little-endian coordinates into points, on big-endian
+// platforms where they can't be used in place.
+func pointsOf(data []byte) []orb.Point {
+	points := make([]orb.Point, len(data)/pointSize)
+	for i := range points {
+		points[i] = orb.Point{
+			math.Float64frombits(binary.LittleEndian.Uint64(data[i*pointSize:])),
+			math.Float64frombits(binary.LittleEndian.Uint64(data[i*pointSize+8:])),
+		}
+	}
+	return points
+}
@@ -0,0 +1,22 @@
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64 || wasm
+
+package internal
+
+import (
+	"unsafe"
+
+	"github.com/paulmach/orb"
+)
+
+// ZERO_COPY_POINTS is whether pointsOf returns points backed by the store
+// itself, which needs the coordinates to be in native byte order.
+const ZERO_COPY_POINTS = true
+
+// pointsOf reinterprets little-endian coordinates as points, without copying
+// them. The data must be 8 byte aligned.
+func pointsOf(data []byte) []orb.Point {
+	if len(data) == 0 {
+		return []orb.Point{}
+	}
+	return unsafe.Slice((*orb.Point)(unsafe.Pointer(&data[0])), len(data)/pointSize)
+}
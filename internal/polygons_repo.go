@@ -4,11 +4,22 @@ import (
 	"fmt"
 
 	"github.com/kofalt/go-memoize"
+	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geojson"
 )
 
 type PolygonsRepo interface {
 	RetrieveFeatureCollection(target string, district string) (*geojson.FeatureCollection, error)
+	// RetrieveFeature finds a single feature by its ID in one of the files,
+	// returning nil if the file doesn't have it
+	RetrieveFeature(target string, district string, id string) (*geojson.Feature, error)
+}
+
+// BoundedPolygonsRepo is a PolygonsRepo that can also find the features at a
+// level whose bounds overlap a bound, without knowing which files they're in.
+type BoundedPolygonsRepo interface {
+	PolygonsRepo
+	RetrieveFeaturesInBound(target string, bound orb.Bound) (*geojson.FeatureCollection, error)
 }
 
 type CachedPolygonsRepo struct {
@@ -20,9 +31,31 @@ func NewPolygonsRepo(cache *memoize.Memoizer) PolygonsRepo {
 }
 
 func (cp *CachedPolygonsRepo) RetrieveFeatureCollection(target string, district string) (*geojson.FeatureCollection, error) {
-	filename := fmt.Sprintf("./data/postcodes/%s/%s.geojson.bz2", target, district)
-	featureCollection, err, _ := memoize.Call(cp.cache, filename, func() (*geojson.FeatureCollection, error) {
+	dir := fmt.Sprintf("./data/postcodes/%s", target)
+	featureCollection, err, _ := memoize.Call(cp.cache, dir+"/"+district, func() (*geojson.FeatureCollection, error) {
+		filename, err := FindFeatureCollection(dir, district)
+		if err != nil {
+			return nil, err
+		}
 		return DecompressFeatureCollection(filename)
 	})
 	return featureCollection, err
 }
+
+func (cp *CachedPolygonsRepo) RetrieveFeature(target string, district string, id string) (*geojson.Feature, error) {
+	featureCollection, err := cp.RetrieveFeatureCollection(target, district)
+	if err != nil {
+		return nil, err
+	}
+	return FindFeature(featureCollection, id), nil
+}
+
+// FindFeature returns the feature with the given ID, or nil if there isn't one.
+func FindFeature(fc *geojson.FeatureCollection, id string) *geojson.Feature {
+	for _, feature := range fc.Features {
+		if feature.ID == id {
+			return feature
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"postcode-polygons/topology"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+// Zoom levels that extract-data precomputes simplified polygons for. Each is
+// stored in its own directory alongside the full detail polygons, e.g.
+// "districts-z8".
+var SIMPLIFIED_ZOOMS = []int{8, 10, 12}
+
+// Levels that are precomputed at each of the SIMPLIFIED_ZOOMS
+var SIMPLIFIED_TARGETS = []string{"units", "sectors", "districts", "areas"}
+
+func SimplifiedTarget(target string, zoom int) string {
+	return fmt.Sprintf("%s-z%d", target, zoom)
+}
+
+// SimplifiedZoom returns the precomputed zoom level to use for the requested
+// zoom, i.e. the first with at least as much detail, or false if the request
+// is beyond them and needs the full detail polygons.
+func SimplifiedZoom(zoom int) (int, bool) {
+	for _, z := range SIMPLIFIED_ZOOMS {
+		if z >= zoom {
+			return z, true
+		}
+	}
+	return 0, false
+}
+
+// ToleranceForZoom is the simplification tolerance (in degrees) that only
+// removes detail smaller than a pixel of a 256px web map tile at the zoom.
+func ToleranceForZoom(zoom int) float64 {
+	return 360 / (256 * math.Exp2(float64(zoom)))
+}
+
+// SimplifyFeatureCollection returns a copy of the features with their geometry
+// simplified using the Douglas-Peucker algorithm. Boundaries shared between
+// neighbouring features are simplified once, so that they still meet exactly.
+func SimplifyFeatureCollection(fc *geojson.FeatureCollection, tolerance float64) *geojson.FeatureCollection {
+	topo := topology.Build(fc)
+	topo.Simplify(tolerance)
+	return topo.FeatureCollection()
+}
+
+// SimplifiedPolygonsRepo serves polygons simplified for a zoom level, using
+// those precomputed by extract-data where they exist, or otherwise simplifying
+// the full detail polygons on the fly.
+type SimplifiedPolygonsRepo struct {
+	repo PolygonsRepo
+	zoom int
+}
+
+func NewSimplifiedPolygonsRepo(repo PolygonsRepo, zoom int) PolygonsRepo {
+	return &SimplifiedPolygonsRepo{repo: repo, zoom: zoom}
+}
+
+func (s *SimplifiedPolygonsRepo) RetrieveFeatureCollection(target string, district string) (*geojson.FeatureCollection, error) {
+	featureCollection, err := s.repo.RetrieveFeatureCollection(SimplifiedTarget(target, s.zoom), district)
+	if err == nil || !os.IsNotExist(err) {
+		return featureCollection, err
+	}
+
+	featureCollection, err = s.repo.RetrieveFeatureCollection(target, district)
+	if err != nil {
+		return nil, err
+	}
+	return SimplifyFeatureCollection(featureCollection, ToleranceForZoom(s.zoom)), nil
+}
+
+func (s *SimplifiedPolygonsRepo) RetrieveFeature(target string, district string, id string) (*geojson.Feature, error) {
+	featureCollection, err := s.RetrieveFeatureCollection(target, district)
+	if err != nil {
+		return nil, err
+	}
+	return FindFeature(featureCollection, id), nil
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+type mockPolygonsRepo struct {
+	files map[string]*geojson.FeatureCollection
+	calls []string
+}
+
+func (m *mockPolygonsRepo) RetrieveFeatureCollection(target string, district string) (*geojson.FeatureCollection, error) {
+	m.calls = append(m.calls, target+"/"+district)
+	if fc, ok := m.files[target+"/"+district]; ok {
+		return fc, nil
+	}
+	if target == "broken" {
+		return nil, errors.New("fail")
+	}
+	return nil, os.ErrNotExist
+}
+
+func (m *mockPolygonsRepo) RetrieveFeature(target string, district string, id string) (*geojson.Feature, error) {
+	fc, err := m.RetrieveFeatureCollection(target, district)
+	if err != nil {
+		return nil, err
+	}
+	return FindFeature(fc, id), nil
+}
+
+// A square with a small notch in its bottom edge
+func notchedSquare() orb.Polygon {
+	return orb.Polygon{{{0, 0}, {1, 0}, {1, 0.001}, {2, 0}, {2, 2}, {0, 2}, {0, 0}}}
+}
+
+func TestSimplifyFeatureCollection(t *testing.T) {
+	fc := geojson.NewFeatureCollection()
+	feature := geojson.NewFeature(notchedSquare())
+	feature.ID = "TR26"
+	feature.Properties["type"] = "district"
+	fc.Append(feature)
+	tiny := geojson.NewFeature(orb.MultiPolygon{
+		{{{10, 10}, {10.001, 10}, {10.001, 10.001}, {10, 10}}},
+		{{{20, 20}, {22, 20}, {22, 22}, {20, 20}}},
+	})
+	tiny.ID = "TR27"
+	fc.Append(tiny)
+	fc.Append(geojson.NewFeature(orb.Polygon{{{30, 30}, {30.001, 30}, {30.001, 30.001}, {30, 30}}}))
+
+	simplified := SimplifyFeatureCollection(fc, 0.1)
+
+	require.Len(t, simplified.Features, 3)
+	require.Equal(t, "TR26", simplified.Features[0].ID)
+	require.Equal(t, "district", simplified.Features[0].Properties["type"])
+	require.Equal(t, orb.Polygon{{{0, 0}, {2, 0}, {2, 2}, {0, 2}, {0, 0}}}, simplified.Features[0].Geometry)
+
+	// Rings too small to simplify are kept as they are
+	require.Equal(t, tiny.Geometry, simplified.Features[1].Geometry)
+
+	// The original features are left untouched
+	require.Equal(t, notchedSquare(), feature.Geometry)
+}
+
+func TestSimplifiedZoom(t *testing.T) {
+	for zoom, expected := range map[int]int{0: 8, 8: 8, 9: 10, 12: 12} {
+		simplified, ok := SimplifiedZoom(zoom)
+		require.True(t, ok, zoom)
+		require.Equal(t, expected, simplified, zoom)
+	}
+	_, ok := SimplifiedZoom(13)
+	require.False(t, ok)
+}
+
+func TestToleranceForZoom(t *testing.T) {
+	require.Equal(t, 360.0/256, ToleranceForZoom(0))
+	require.InDelta(t, 0.0055, ToleranceForZoom(8), 0.0001)
+}
+
+func TestSimplifiedPolygonsRepo(t *testing.T) {
+	precomputed := geojson.NewFeatureCollection()
+	full := geojson.NewFeatureCollection()
+	full.Append(geojson.NewFeature(notchedSquare()))
+
+	repo := &mockPolygonsRepo{files: map[string]*geojson.FeatureCollection{
+		"districts-z8/TR": precomputed,
+		"districts/TR":    full,
+		"districts/PL":    full,
+	}}
+	simplified := NewSimplifiedPolygonsRepo(repo, 8)
+
+	// Precomputed polygons are used where they exist
+	fc, err := simplified.RetrieveFeatureCollection("districts", "TR")
+	require.NoError(t, err)
+	require.Same(t, precomputed, fc)
+
+	// Otherwise the full detail polygons are simplified
+	fc, err = simplified.RetrieveFeatureCollection("districts", "PL")
+	require.NoError(t, err)
+	require.Equal(t, orb.Polygon{{{0, 0}, {2, 0}, {2, 2}, {0, 2}, {0, 0}}}, fc.Features[0].Geometry)
+	require.Equal(t, []string{"districts-z8/TR", "districts-z8/PL", "districts/PL"}, repo.calls)
+
+	_, err = simplified.RetrieveFeatureCollection("districts", "EX")
+	require.True(t, os.IsNotExist(err))
+
+	_, err = NewSimplifiedPolygonsRepo(repo, 8).RetrieveFeatureCollection("broken", "EX")
+	require.Error(t, err)
+}
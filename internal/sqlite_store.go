@@ -0,0 +1,374 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/kofalt/go-memoize"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/geojson"
+	_ "modernc.org/sqlite" // Pure Go, so doesn't need cgo
+)
+
+// A SQLite store holds every polygon file in a single SQLite database, for
+// ad-hoc querying as well as serving. Each feature is a row of the polygons
+// table, with its properties as JSON and its geometry as WKB (which SpatiaLite
+// can read with ST_GeomFromWKB), and the polygons_rtree table indexes the
+// bound of each one. The files table lists every file, including any without
+// features, and the version is kept in the user_version pragma.
+
+const SQLITE_STORE_VERSION = 1
+
+var ErrSQLiteStoreVersion = errors.New("SQLite store was written by an incompatible version")
+
+const sqliteSchema = `
+CREATE TABLE files (
+	target TEXT NOT NULL,
+	district TEXT NOT NULL,
+	PRIMARY KEY (target, district)
+);
+CREATE TABLE polygons (
+	fid INTEGER PRIMARY KEY,
+	target TEXT NOT NULL,
+	district TEXT NOT NULL,
+	id TEXT NOT NULL,
+	properties TEXT NOT NULL,
+	geometry BLOB NOT NULL,
+	UNIQUE (target, district, id)
+);
+CREATE INDEX polygons_target_id ON polygons (target, id);
+CREATE VIRTUAL TABLE polygons_rtree USING rtree(fid, min_x, max_x, min_y, max_y);
+`
+
+// SQLiteWriter writes a SQLite store, a file of features at a time, all in one
+// transaction. As with packs, nothing replaces an existing store until it's
+// closed.
+type SQLiteWriter struct {
+	path     string
+	tmp      string
+	db       *sql.DB
+	tx       *sql.Tx
+	file     *sql.Stmt
+	polygon  *sql.Stmt
+	rtree    *sql.Stmt
+	files    map[string]bool
+	features int64
+}
+
+func CreateSQLiteStore(path string) (*SQLiteWriter, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SQLite store file: %w", err)
+	}
+	_ = tmp.Close()
+
+	s := &SQLiteWriter{path: path, tmp: tmp.Name(), files: make(map[string]bool)}
+	if err := s.init(); err != nil {
+		s.Abort()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLiteWriter) init() error {
+	var err error
+	s.db, err = sql.Open("sqlite", sqliteURI(s.tmp, ""))
+	if err != nil {
+		return fmt.Errorf("failed to open SQLite store: %w", err)
+	}
+	s.db.SetMaxOpenConns(1) // So the pragmas apply to every statement
+
+	// Nothing else sees the database until it's moved into place, so it
+	// doesn't need to survive a crash
+	statements := []string{
+		"PRAGMA journal_mode = OFF",
+		"PRAGMA synchronous = OFF",
+		sqliteSchema,
+		fmt.Sprintf("PRAGMA user_version = %d", SQLITE_STORE_VERSION),
+	}
+	for _, statement := range statements {
+		if _, err := s.db.Exec(statement); err != nil {
+			return fmt.Errorf("failed to create SQLite store tables: %w", err)
+		}
+	}
+
+	s.tx, err = s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start SQLite store transaction: %w", err)
+	}
+	for stmt, query := range map[**sql.Stmt]string{
+		&s.file:    "INSERT INTO files (target, district) VALUES (?, ?)",
+		&s.polygon: "INSERT INTO polygons (fid, target, district, id, properties, geometry) VALUES (?, ?, ?, ?, ?, ?)",
+		&s.rtree:   "INSERT INTO polygons_rtree (fid, min_x, max_x, min_y, max_y) VALUES (?, ?, ?, ?, ?)",
+	} {
+		if *stmt, err = s.tx.Prepare(query); err != nil {
+			return fmt.Errorf("failed to prepare SQLite store insert: %w", err)
+		}
+	}
+	return nil
+}
+
+// WriteFeatureCollection adds the features of one file, e.g. a district of
+// units. Each feature needs a unique string ID, and a geometry. The features
+// are all checked before any are added, so a file is either written in full or
+// not at all.
+func (s *SQLiteWriter) WriteFeatureCollection(target string, district string, fc *geojson.FeatureCollection) error {
+	name := target + "/" + district
+	if s.files[name] {
+		return fmt.Errorf("%s is already in the SQLite store", name)
+	}
+
+	features := make([]*geojson.Feature, len(fc.Features))
+	copy(features, fc.Features)
+	ids := make([]string, len(features))
+	for i, feature := range features {
+		id, ok := feature.ID.(string)
+		if !ok || id == "" {
+			return fmt.Errorf("missing or invalid ID for feature in %s", name)
+		}
+		if feature.Geometry == nil {
+			return fmt.Errorf("missing geometry for feature %s in %s", id, name)
+		}
+		ids[i] = id
+	}
+	sort.Sort(byID{ids, features})
+	for i := 1; i < len(ids); i++ {
+		if ids[i] == ids[i-1] {
+			return fmt.Errorf("duplicate feature %s in %s", ids[i], name)
+		}
+	}
+
+	if _, err := s.file.Exec(target, district); err != nil {
+		return fmt.Errorf("failed to add %s to SQLite store: %w", name, err)
+	}
+	s.files[name] = true
+
+	for i, feature := range features {
+		properties := []byte("{}")
+		if len(feature.Properties) > 0 {
+			var err error
+			if properties, err = c.Marshal(feature.Properties); err != nil {
+				return fmt.Errorf("error encoding properties of feature %s: %w", ids[i], err)
+			}
+		}
+		geometry, err := wkb.Marshal(feature.Geometry)
+		if err != nil {
+			return fmt.Errorf("error encoding geometry of feature %s: %w", ids[i], err)
+		}
+
+		s.features++
+		if _, err := s.polygon.Exec(s.features, target, district, ids[i], string(properties), geometry); err != nil {
+			return fmt.Errorf("failed to add feature %s to SQLite store: %w", ids[i], err)
+		}
+		bound := feature.Geometry.Bound()
+		if _, err := s.rtree.Exec(s.features, bound.Min.X(), bound.Max.X(), bound.Min.Y(), bound.Max.Y()); err != nil {
+			return fmt.Errorf("failed to index feature %s in SQLite store: %w", ids[i], err)
+		}
+	}
+	return nil
+}
+
+// Close commits the features, and moves the store into place.
+func (s *SQLiteWriter) Close() error {
+	if err := s.tx.Commit(); err != nil {
+		s.Abort()
+		return fmt.Errorf("failed to write SQLite store: %w", err)
+	}
+	if _, err := s.db.Exec("ANALYZE"); err != nil {
+		s.Abort()
+		return fmt.Errorf("failed to analyze SQLite store: %w", err)
+	}
+	if err := s.db.Close(); err != nil {
+		_ = os.Remove(s.tmp)
+		return fmt.Errorf("failed to close SQLite store: %w", err)
+	}
+
+	if err := os.Rename(s.tmp, s.path); err != nil {
+		_ = os.Remove(s.tmp)
+		return fmt.Errorf("failed to move SQLite store into place: %w", err)
+	}
+	return nil
+}
+
+// Abort discards the store, leaving any existing one in place.
+func (s *SQLiteWriter) Abort() {
+	if s.tx != nil {
+		_ = s.tx.Rollback()
+	}
+	if s.db != nil {
+		_ = s.db.Close()
+	}
+	_ = os.Remove(s.tmp)
+}
+
+// SQLiteStore reads features from a SQLite store written by SQLiteWriter. The
+// database is opened read-only, and it's safe for concurrent use.
+type SQLiteStore struct {
+	path     string
+	db       *sql.DB
+	file     *sql.Stmt
+	features *sql.Stmt
+	feature  *sql.Stmt
+	bound    *sql.Stmt
+}
+
+// OpenSQLiteStore opens a SQLite store, after checking its version.
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err // The driver would otherwise only fail on the first query
+	}
+
+	db, err := sql.Open("sqlite", sqliteURI(path, "mode=ro&immutable=1"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite store: %w", err)
+	}
+	store := &SQLiteStore{path: path, db: db}
+	if err := store.init(); err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *SQLiteStore) init() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("%s is not a SQLite store: %w", s.path, err)
+	}
+	if version == 0 {
+		return fmt.Errorf("%s is not a SQLite store", s.path) // Including any empty file, which SQLite opens as an empty database
+	}
+	if version != SQLITE_STORE_VERSION {
+		return fmt.Errorf("%w (version %d, expected %d)", ErrSQLiteStoreVersion, version, SQLITE_STORE_VERSION)
+	}
+
+	for stmt, query := range map[**sql.Stmt]string{
+		&s.file:     "SELECT 1 FROM files WHERE target = ? AND district = ?",
+		&s.features: "SELECT id, properties, geometry FROM polygons WHERE target = ? AND district = ? ORDER BY id",
+		&s.feature:  "SELECT id, properties, geometry FROM polygons WHERE target = ? AND district = ? AND id = ?",
+		&s.bound: `SELECT p.id, p.properties, p.geometry FROM polygons_rtree r JOIN polygons p ON p.fid = r.fid
+			WHERE r.max_x >= ? AND r.min_x <= ? AND r.max_y >= ? AND r.min_y <= ? AND p.target = ? ORDER BY p.id`,
+	} {
+		var err error
+		if *stmt, err = s.db.Prepare(query); err != nil {
+			return fmt.Errorf("%s is not a SQLite store: %w", s.path, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close() // Also closes the prepared statements
+}
+
+// FeatureCollection reads all the features of a file. As with the individual
+// files, it fails with an error satisfying os.IsNotExist if the store doesn't
+// have the file.
+func (s *SQLiteStore) FeatureCollection(target string, district string) (*geojson.FeatureCollection, error) {
+	if err := s.lookup(target, district); err != nil {
+		return nil, err
+	}
+	return s.query(s.features, target, district)
+}
+
+// Feature reads a single feature from a file, returning nil if the file
+// doesn't have it.
+func (s *SQLiteStore) Feature(target string, district string, id string) (*geojson.Feature, error) {
+	fc, err := s.query(s.feature, target, district, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(fc.Features) == 0 {
+		return nil, s.lookup(target, district)
+	}
+	return fc.Features[0], nil
+}
+
+// FeaturesInBound reads the features at the target level whose bounds overlap
+// the given bound, from whichever files they're in, sorted by their IDs.
+// Their geometry may not overlap it themselves.
+func (s *SQLiteStore) FeaturesInBound(target string, bound orb.Bound) (*geojson.FeatureCollection, error) {
+	return s.query(s.bound, bound.Min.X(), bound.Max.X(), bound.Min.Y(), bound.Max.Y(), target)
+}
+
+func (s *SQLiteStore) lookup(target string, district string) error {
+	var found int
+	err := s.file.QueryRow(target, district).Scan(&found)
+	if err == sql.ErrNoRows {
+		return &os.PathError{Op: "open", Path: fmt.Sprintf("%s:%s/%s", s.path, target, district), Err: os.ErrNotExist}
+	}
+	return err
+}
+
+func (s *SQLiteStore) query(stmt *sql.Stmt, args ...any) (*geojson.FeatureCollection, error) {
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	fc := geojson.NewFeatureCollection()
+	for rows.Next() {
+		var id string
+		var properties, geometry []byte
+		if err := rows.Scan(&id, &properties, &geometry); err != nil {
+			return nil, err
+		}
+
+		g, err := wkb.Unmarshal(geometry)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding geometry of feature %s: %w", id, err)
+		}
+		feature := geojson.NewFeature(g)
+		feature.ID = id
+		if err := c.Unmarshal(properties, &feature.Properties); err != nil {
+			return nil, fmt.Errorf("error decoding properties of feature %s: %w", id, err)
+		}
+		fc.Append(feature)
+	}
+	return fc, rows.Err()
+}
+
+// sqliteURI opens the file with the given query parameters, escaping any
+// characters in its path that would otherwise be taken as part of the URI.
+func sqliteURI(path string, query string) string {
+	uri := "file:" + (&url.URL{Path: path}).EscapedPath()
+	if query != "" {
+		uri += "?" + query
+	}
+	return uri
+}
+
+// SQLitePolygonsRepo serves polygons from a SQLite store. Whole files are
+// cached as with CachedPolygonsRepo, but single features, and features found
+// by their bound, are read straight from the store.
+type SQLitePolygonsRepo struct {
+	store *SQLiteStore
+	cache *memoize.Memoizer
+}
+
+func NewSQLitePolygonsRepo(store *SQLiteStore, cache *memoize.Memoizer) BoundedPolygonsRepo {
+	return &SQLitePolygonsRepo{store: store, cache: cache}
+}
+
+func (sp *SQLitePolygonsRepo) RetrieveFeatureCollection(target string, district string) (*geojson.FeatureCollection, error) {
+	key := fmt.Sprintf("sqlite:%s/%s", target, district)
+	featureCollection, err, _ := memoize.Call(sp.cache, key, func() (*geojson.FeatureCollection, error) {
+		return sp.store.FeatureCollection(target, district)
+	})
+	return featureCollection, err
+}
+
+func (sp *SQLitePolygonsRepo) RetrieveFeature(target string, district string, id string) (*geojson.Feature, error) {
+	return sp.store.Feature(target, district, id)
+}
+
+func (sp *SQLitePolygonsRepo) RetrieveFeaturesInBound(target string, bound orb.Bound) (*geojson.FeatureCollection, error) {
+	return sp.store.FeaturesInBound(target, bound)
+}
@@ -0,0 +1,287 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/kofalt/go-memoize"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestSQLiteStore(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "polygons.sqlite")
+	store, err := CreateSQLiteStore(path)
+	require.NoError(t, err)
+
+	units := unitFeatures("TR26 2AB", "TR26 1AB", "TR26 1ZZ")
+	units.Features[2].Geometry = orb.Polygon{
+		{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}},
+		{{1, 1}, {1, 2}, {2, 2}, {2, 1}, {1, 1}},
+	}
+	units.Features[0].Properties["count"] = 3.0
+	require.NoError(t, store.WriteFeatureCollection("units", "TR26", units))
+	require.NoError(t, store.WriteFeatureCollection("units", "EX1", geojson.NewFeatureCollection()))
+	require.NoError(t, store.WriteFeatureCollection("units", "B1", unitFeatures("B1 1AA")))
+	districts := unitFeatures("TR26")
+	districts.Features[0].Geometry = orb.MultiPolygon{
+		{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}},
+		{{{2, 2}, {3, 2}, {3, 3}, {2, 2}}},
+	}
+	require.NoError(t, store.WriteFeatureCollection("districts", "TR", districts))
+	require.NoError(t, store.Close())
+	return path
+}
+
+func TestSQLiteStore(t *testing.T) {
+	store, err := OpenSQLiteStore(writeTestSQLiteStore(t))
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	fc, err := store.FeatureCollection("units", "TR26")
+	require.NoError(t, err)
+	require.Len(t, fc.Features, 3)
+	require.Equal(t, []any{"TR26 1AB", "TR26 1ZZ", "TR26 2AB"}, []any{fc.Features[0].ID, fc.Features[1].ID, fc.Features[2].ID}) // Sorted by ID
+	require.Equal(t, orb.Polygon{{{1, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 0}}}, fc.Features[0].Geometry)
+	require.Equal(t, orb.Polygon{
+		{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}},
+		{{1, 1}, {1, 2}, {2, 2}, {2, 1}, {1, 1}},
+	}, fc.Features[1].Geometry)
+	require.Equal(t, geojson.Properties{"type": "unit", "count": 3.0}, fc.Features[2].Properties)
+
+	fc, err = store.FeatureCollection("units", "EX1")
+	require.NoError(t, err)
+	require.Empty(t, fc.Features)
+
+	feature, err := store.Feature("districts", "TR", "TR26")
+	require.NoError(t, err)
+	require.Equal(t, orb.MultiPolygon{
+		{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}},
+		{{{2, 2}, {3, 2}, {3, 3}, {2, 2}}},
+	}, feature.Geometry)
+
+	for _, id := range []string{"TR26 1AB", "TR26 1ZZ", "TR26 2AB"} {
+		feature, err := store.Feature("units", "TR26", id)
+		require.NoError(t, err)
+		require.Equal(t, id, feature.ID)
+	}
+
+	// Features that aren't in the file
+	for _, id := range []string{"TR26 1AA", "TR26 1", "B1 1AA", ""} {
+		feature, err := store.Feature("units", "TR26", id)
+		require.NoError(t, err)
+		require.Nil(t, feature, id)
+	}
+
+	// Files that aren't in the store
+	_, err = store.FeatureCollection("units", "TR27")
+	require.True(t, os.IsNotExist(err))
+	_, err = store.Feature("sectors", "TR26", "TR26 1")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestSQLiteStore_FeaturesInBound(t *testing.T) {
+	store, err := OpenSQLiteStore(writeTestSQLiteStore(t))
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	ids := func(fc *geojson.FeatureCollection) []any {
+		ids := make([]any, len(fc.Features))
+		for i, feature := range fc.Features {
+			ids[i] = feature.ID
+		}
+		return ids
+	}
+
+	// From more than one file, only at the target level
+	fc, err := store.FeaturesInBound("units", orb.Bound{Min: orb.Point{0.5, 0.5}, Max: orb.Point{0.5, 0.5}})
+	require.NoError(t, err)
+	require.Equal(t, []any{"B1 1AA", "TR26 1ZZ", "TR26 2AB"}, ids(fc))
+	require.Equal(t, "unit", fc.Features[0].Properties["type"])
+
+	fc, err = store.FeaturesInBound("units", orb.Bound{Min: orb.Point{1.5, 0.5}, Max: orb.Point{5, 5}})
+	require.NoError(t, err)
+	require.Equal(t, []any{"TR26 1AB", "TR26 1ZZ"}, ids(fc))
+
+	fc, err = store.FeaturesInBound("districts", orb.Bound{Min: orb.Point{2.5, 2.5}, Max: orb.Point{2.5, 2.5}})
+	require.NoError(t, err)
+	require.Equal(t, []any{"TR26"}, ids(fc))
+
+	fc, err = store.FeaturesInBound("units", orb.Bound{Min: orb.Point{10, 10}, Max: orb.Point{11, 11}})
+	require.NoError(t, err)
+	require.Empty(t, fc.Features)
+	fc, err = store.FeaturesInBound("sectors", orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{5, 5}})
+	require.NoError(t, err)
+	require.Empty(t, fc.Features)
+}
+
+func TestSQLiteStore_RTree(t *testing.T) {
+	// Enough features for a few levels of nodes, in files across the grid
+	path := filepath.Join(t.TempDir(), "polygons.sqlite")
+	writer, err := CreateSQLiteStore(path)
+	require.NoError(t, err)
+	bounds := make(map[string]orb.Bound)
+	for x := 0; x < 100; x++ {
+		fc := geojson.NewFeatureCollection()
+		for y := 0; y < 100; y++ {
+			bound := orb.Bound{Min: orb.Point{float64(x) / 10, float64(y) / 10}, Max: orb.Point{float64(x+1) / 10, float64(y+1) / 10}}
+			feature := geojson.NewFeature(bound.ToPolygon())
+			feature.ID = fmt.Sprintf("%d/%d", x, y)
+			bounds[feature.ID.(string)] = bound
+			fc.Append(feature)
+		}
+		require.NoError(t, writer.WriteFeatureCollection("units", fmt.Sprint(x), fc))
+	}
+	require.NoError(t, writer.Close())
+
+	store, err := OpenSQLiteStore(path)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	for _, bound := range []orb.Bound{
+		{Min: orb.Point{0.05, 0.05}, Max: orb.Point{0.05, 0.05}},
+		{Min: orb.Point{3.33, 1.5}, Max: orb.Point{4.01, 2.77}},
+		{Min: orb.Point{9.95, 9.95}, Max: orb.Point{20, 20}},
+		{Min: orb.Point{-1, -1}, Max: orb.Point{11, 11}},
+	} {
+		var expected []any
+		for id, b := range bounds {
+			if b.Intersects(bound) {
+				expected = append(expected, id)
+			}
+		}
+		sort.Slice(expected, func(i, j int) bool { return expected[i].(string) < expected[j].(string) })
+
+		fc, err := store.FeaturesInBound("units", bound)
+		require.NoError(t, err)
+		ids := make([]any, len(fc.Features))
+		for i, feature := range fc.Features {
+			ids[i] = feature.ID
+		}
+		require.Equal(t, expected, ids, "%v", bound)
+	}
+}
+
+func TestSQLiteStore_Schema(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteURI(writeTestSQLiteStore(t), "mode=ro"))
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	// SQLite itself finds nothing wrong with the database or the R*Tree
+	var result string
+	require.NoError(t, db.QueryRow("PRAGMA integrity_check").Scan(&result))
+	require.Equal(t, "ok", result)
+	require.NoError(t, db.QueryRow("SELECT rtreecheck('polygons_rtree')").Scan(&result))
+	require.Equal(t, "ok", result)
+
+	// The rows that ad-hoc queries read
+	var target, district, id, properties string
+	require.NoError(t, db.QueryRow("SELECT target, district, id, properties FROM polygons WHERE fid = 4").Scan(&target, &district, &id, &properties))
+	require.Equal(t, []string{"units", "B1", "B1 1AA", `{"type":"unit"}`}, []string{target, district, id, properties})
+
+	rows, err := db.Query("SELECT target || '/' || district FROM files ORDER BY target, district")
+	require.NoError(t, err)
+	var files []string
+	for rows.Next() {
+		var file string
+		require.NoError(t, rows.Scan(&file))
+		files = append(files, file)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []string{"districts/TR", "units/B1", "units/EX1", "units/TR26"}, files)
+
+	var fid int64
+	var minX, maxX, minY, maxY float64
+	require.NoError(t, db.QueryRow("SELECT fid, min_x, max_x, min_y, max_y FROM polygons_rtree WHERE fid = 2").Scan(&fid, &minX, &maxX, &minY, &maxY))
+	require.Equal(t, []float64{0, 4, 0, 4}, []float64{minX, maxX, minY, maxY})
+}
+
+func TestSQLiteWriter_Invalid(t *testing.T) {
+	store, err := CreateSQLiteStore(filepath.Join(t.TempDir(), "polygons.sqlite"))
+	require.NoError(t, err)
+	defer store.Abort()
+
+	require.EqualError(t, store.WriteFeatureCollection("units", "TR26", unitFeatures("TR26 1AB", "TR26 1AB")), "duplicate feature TR26 1AB in units/TR26")
+	fc := unitFeatures("TR26 1AB")
+	fc.Features[0].ID = 1
+	require.EqualError(t, store.WriteFeatureCollection("units", "TR27", fc), "missing or invalid ID for feature in units/TR27")
+	fc = unitFeatures("TR26 1AB")
+	fc.Features[0].Geometry = nil
+	require.EqualError(t, store.WriteFeatureCollection("units", "TR28", fc), "missing geometry for feature TR26 1AB in units/TR28")
+	require.NoError(t, store.WriteFeatureCollection("units", "TR29", unitFeatures("TR29 1AB")))
+	require.EqualError(t, store.WriteFeatureCollection("units", "TR29", unitFeatures("TR29 1AB")), "units/TR29 is already in the SQLite store")
+
+	// Files that failed weren't written at all
+	require.NoError(t, store.WriteFeatureCollection("units", "TR26", unitFeatures("TR26 1AB")))
+}
+
+func TestSQLiteWriter_Abort(t *testing.T) {
+	path := writeTestSQLiteStore(t)
+	store, err := CreateSQLiteStore(path)
+	require.NoError(t, err)
+	require.NoError(t, store.WriteFeatureCollection("units", "TR26", unitFeatures("TR26 1AB")))
+	store.Abort()
+
+	// The existing store is left alone, and nothing else
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	opened, err := OpenSQLiteStore(path)
+	require.NoError(t, err)
+	defer func() { _ = opened.Close() }()
+	fc, err := opened.FeatureCollection("units", "TR26")
+	require.NoError(t, err)
+	require.Len(t, fc.Features, 3)
+}
+
+func TestOpenSQLiteStore_Invalid(t *testing.T) {
+	_, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "missing.sqlite"))
+	require.True(t, os.IsNotExist(err))
+
+	path := filepath.Join(t.TempDir(), "invalid.sqlite")
+	for _, invalid := range [][]byte{nil, []byte("not a SQLite database at all, but long enough to look like one might")} {
+		require.NoError(t, os.WriteFile(path, invalid, 0644))
+		_, err = OpenSQLiteStore(path)
+		require.ErrorContains(t, err, "is not a SQLite store")
+	}
+
+	path = writeTestSQLiteStore(t)
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0, 0, 0, 99}, 60) // The user_version in the header
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = OpenSQLiteStore(path)
+	require.ErrorIs(t, err, ErrSQLiteStoreVersion)
+}
+
+func TestSQLitePolygonsRepo(t *testing.T) {
+	store, err := OpenSQLiteStore(writeTestSQLiteStore(t))
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+	repo := NewSQLitePolygonsRepo(store, memoize.NewMemoizer(time.Minute, time.Minute))
+
+	fc, err := repo.RetrieveFeatureCollection("units", "TR26")
+	require.NoError(t, err)
+	require.Len(t, fc.Features, 3)
+	cached, err := repo.RetrieveFeatureCollection("units", "TR26")
+	require.NoError(t, err)
+	require.Same(t, fc, cached)
+
+	feature, err := repo.RetrieveFeature("units", "TR26", "TR26 1ZZ")
+	require.NoError(t, err)
+	require.Equal(t, "TR26 1ZZ", feature.ID)
+
+	fc, err = repo.RetrieveFeaturesInBound("units", orb.Bound{Min: orb.Point{3.5, 3.5}, Max: orb.Point{3.5, 3.5}})
+	require.NoError(t, err)
+	require.Len(t, fc.Features, 1)
+
+	_, err = repo.RetrieveFeatureCollection("units", "TR27")
+	require.True(t, os.IsNotExist(err))
+}
@@ -2,7 +2,12 @@ package main
 
 import (
 	"log"
+	"os"
 	"postcode-polygons/cmd"
+	"postcode-polygons/internal"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -10,9 +15,20 @@ import (
 func main() {
 	var err error
 	var polygonTarBz2File string
+	var topoJSON bool
+	var codecName string
 	var codePointZipFile string
+	var snapshotFile string
+	var packFile string
+	var geometryFile string
+	var sqliteFile string
+	var polygonStore string
+	var output string
+	var sqliteOutputFile string
 	var port int
 	var debug bool
+	var reloadInterval time.Duration
+	var adminToken string
 
 	rootCmd := &cobra.Command{
 		Use:  "postcode-polygons",
@@ -20,29 +36,87 @@ func main() {
 	}
 
 	apiServerCmd := &cobra.Command{
-		Use:   "api-server [--codepoint <path>] [--port <port>] [--debug]",
+		Use:   "api-server [--codepoint <path>] [--snapshot <path>] [--polygon-store <store>] [--pack <path>] [--geometry <path>] [--sqlite <path>] [--port <port>] [--debug] [--reload-interval <duration>] [--admin-token <token>]",
 		Short: "Start HTTP API server",
 		Run: func(_ *cobra.Command, _ []string) {
-			cmd.ApiServer(codePointZipFile, port, debug)
+			if adminToken == "" {
+				adminToken = os.Getenv("ADMIN_TOKEN")
+			}
+			if !slices.Contains(cmd.POLYGON_STORES, polygonStore) {
+				log.Fatalf("invalid --polygon-store '%s', must be one of %s", polygonStore, strings.Join(cmd.POLYGON_STORES, ", "))
+			}
+			polygons := cmd.PolygonStoreConfig{Store: polygonStore, Pack: packFile, Geometry: geometryFile, SQLite: sqliteFile}
+			cmd.ApiServer(codePointZipFile, snapshotFile, polygons, port, debug, reloadInterval, adminToken)
 		},
 	}
 	apiServerCmd.Flags().StringVar(&codePointZipFile, "codepoint",
 		"https://api.os.uk/downloads/v1/products/CodePointOpen/downloads?area=GB&format=CSV&redirect",
 		"Path or URL to CodePoint Open zip file")
+	apiServerCmd.Flags().StringVar(&snapshotFile, "snapshot", "./data/codepoint.idx", "Path to spatial index snapshot, used in preference to the CodePoint Open zip file if up to date (empty to disable)")
+	apiServerCmd.Flags().StringVar(&polygonStore, "polygon-store", "auto", "Where to serve polygons from: auto (the geometry store if given, otherwise the SQLite store if given, otherwise the pack if it exists, otherwise the individual files), files, pack, geometry or sqlite")
+	apiServerCmd.Flags().StringVar(&packFile, "pack", "./data/postcodes/polygons.pack", "Path to polygon pack, used in preference to the individual polygon files if it exists (empty to disable)")
+	apiServerCmd.Flags().StringVar(&geometryFile, "geometry", "", "Path to memory-mapped polygon geometry store, used in preference to the other stores (empty to disable)")
+	apiServerCmd.Flags().StringVar(&sqliteFile, "sqlite", "", "Path to SQLite polygon store, used in preference to the pack and individual polygon files (empty to disable)")
 	apiServerCmd.Flags().IntVar(&port, "port", 8080, "Port to run HTTP server on")
 	apiServerCmd.Flags().BoolVar(&debug, "debug", false, "Enable debugging (pprof) - WARING: do not enable in production")
+	apiServerCmd.Flags().DurationVar(&reloadInterval, "reload-interval", 0, "How often to reload the CodePoint Open data, e.g. 168h (0 to disable)")
+	apiServerCmd.Flags().StringVar(&adminToken, "admin-token", "", "Bearer token for the /admin endpoints, which are disabled if empty (can also be set with $ADMIN_TOKEN)")
 
 	extractDataCmd := &cobra.Command{
-		Use:   "extract-data [--polygon <path>]",
+		Use:   "extract-data [--polygon <path>] [--codec <codec>] [--topojson] [--pack <path>] [--geometry <path>] [--output <output>] [--sqlite <path>]",
 		Short: "Extract NSUL polygons",
 		Run: func(_ *cobra.Command, _ []string) {
-			cmd.ExtractData(polygonTarBz2File)
+			codec, err := internal.LookupCodec(codecName)
+			if err != nil {
+				log.Fatalf("invalid --codec: %v", err)
+			}
+			if !slices.Contains(cmd.EXTRACT_OUTPUTS, output) {
+				log.Fatalf("invalid --output '%s', must be one of %s", output, strings.Join(cmd.EXTRACT_OUTPUTS, ", "))
+			}
+			if output != "sqlite" {
+				sqliteOutputFile = ""
+			}
+			cmd.ExtractData(polygonTarBz2File, codec, topoJSON, packFile, geometryFile, sqliteOutputFile)
 		},
 	}
 	extractDataCmd.Flags().StringVar(&polygonTarBz2File, "polygon", "./data/gb-postcodes-v5.tar.bz2", "Path to NSUL polygons tar.bz2 file")
+	extractDataCmd.Flags().StringVar(&codecName, "codec", "bzip2", "Codec to compress the polygon files with: bzip2, zstd, gzip or none")
+	extractDataCmd.Flags().BoolVar(&topoJSON, "topojson", false, "Also write the simplified polygons as TopoJSON")
+	extractDataCmd.Flags().StringVar(&packFile, "pack", "./data/postcodes/polygons.pack", "Path to write all the polygons to as a single pack (empty to disable)")
+	extractDataCmd.Flags().StringVar(&geometryFile, "geometry", "", "Path to write all the polygons to as a memory-mapped geometry store (empty to disable)")
+	extractDataCmd.Flags().StringVar(&output, "output", "files", "What to write the polygons to as well as the pack and geometry store: files (only the individual files), or sqlite (also a SQLite store)")
+	extractDataCmd.Flags().StringVar(&sqliteOutputFile, "sqlite", "./data/postcodes/polygons.sqlite", "Path to write the SQLite store to, with --output sqlite")
+
+	simplifyDataCmd := &cobra.Command{
+		Use:   "simplify-data [--codec <codec>] [--topojson]",
+		Short: "Write simplified copies of the extracted polygons",
+		Run: func(_ *cobra.Command, _ []string) {
+			codec, err := internal.LookupCodec(codecName)
+			if err != nil {
+				log.Fatalf("invalid --codec: %v", err)
+			}
+			cmd.SimplifyData(codec, topoJSON)
+		},
+	}
+	simplifyDataCmd.Flags().StringVar(&codecName, "codec", "bzip2", "Codec to compress the simplified polygon files with: bzip2, zstd, gzip or none")
+	simplifyDataCmd.Flags().BoolVar(&topoJSON, "topojson", false, "Also write the simplified polygons as TopoJSON")
+
+	buildIndexCmd := &cobra.Command{
+		Use:   "build-index [--codepoint <path>] [--snapshot <path>]",
+		Short: "Build spatial index snapshot",
+		Run: func(_ *cobra.Command, _ []string) {
+			cmd.BuildIndex(codePointZipFile, snapshotFile)
+		},
+	}
+	buildIndexCmd.Flags().StringVar(&codePointZipFile, "codepoint",
+		"https://api.os.uk/downloads/v1/products/CodePointOpen/downloads?area=GB&format=CSV&redirect",
+		"Path or URL to CodePoint Open zip file")
+	buildIndexCmd.Flags().StringVar(&snapshotFile, "snapshot", "./data/codepoint.idx", "Path to write spatial index snapshot to")
 
 	rootCmd.AddCommand(apiServerCmd)
+	rootCmd.AddCommand(buildIndexCmd)
 	rootCmd.AddCommand(extractDataCmd)
+	rootCmd.AddCommand(simplifyDataCmd)
 
 	if err = rootCmd.Execute(); err != nil {
 		log.Fatalf("failed to execute root command: %v", err)
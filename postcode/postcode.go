@@ -0,0 +1,192 @@
+package postcode
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Parsing, validation and normalisation of UK postcodes. A postcode such as
+// "TR26 1AB" is made up of an outward code ("TR26") and an inward code
+// ("1AB"), and identifies a unit within a hierarchy of:
+//
+//	area:     TR
+//	district: TR26
+//	sector:   TR26 1
+//	unit:     TR26 1AB
+//
+// Besides the standard formats this accepts the special Girobank postcode
+// GIR 0AA, British Forces (BFPO) numbers, and the postcodes of the British
+// Overseas Territories (e.g. ASCN 1ZZ).
+
+var (
+	standardOutward = regexp.MustCompile(`^[A-Z]{1,2}[0-9][A-Z0-9]?$`)
+	standardInward  = regexp.MustCompile(`^[0-9][A-Z]{2}$`)
+	bfpo            = regexp.MustCompile(`^BFPO([0-9]{1,4})$`)
+	partialOutward  = regexp.MustCompile(`^[A-Z]{1,2}([0-9][A-Z0-9]?)?$`)
+	partialInward   = regexp.MustCompile(`^([0-9][A-Z]{0,2})?$`)
+	partialBFPO     = regexp.MustCompile(`^[0-9]{0,4}$`)
+)
+
+// Outward codes outside the standard format, all of which are a single
+// district with a single sector.
+var SPECIAL_OUTWARD_CODES = map[string]bool{
+	"GIR":  true, // Girobank
+	"ASCN": true, // Ascension Island
+	"BBND": true, // British Indian Ocean Territory
+	"BIQQ": true, // British Antarctic Territory
+	"FIQQ": true, // Falkland Islands
+	"PCRN": true, // Pitcairn Islands
+	"SIQQ": true, // South Georgia and the South Sandwich Islands
+	"STHL": true, // Saint Helena
+	"TDCU": true, // Tristan da Cunha
+	"TKCA": true, // Turks and Caicos Islands
+}
+
+type Postcode struct {
+	Outward string // e.g. "TR26"
+	Inward  string // e.g. "1AB"
+}
+
+// Parse accepts a postcode in any case, with or without spacing (or with
+// hyphens in place of spaces), e.g. "sw1a1aa", "SW1A  1AA" or "sw1a-1aa".
+func Parse(s string) (Postcode, error) {
+	compact := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '-' {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, s)
+
+	if match := bfpo.FindStringSubmatch(compact); match != nil {
+		return Postcode{Outward: "BFPO", Inward: match[1]}, nil
+	}
+
+	if len(compact) > 3 {
+		p := Postcode{Outward: compact[:len(compact)-3], Inward: compact[len(compact)-3:]}
+		if standardInward.MatchString(p.Inward) && (standardOutward.MatchString(p.Outward) || SPECIAL_OUTWARD_CODES[p.Outward]) {
+			return p, nil
+		}
+	}
+
+	return Postcode{}, fmt.Errorf("'%s' is not a valid postcode", s)
+}
+
+// ParseOutward accepts just the outward code of a postcode (i.e. a district
+// such as "TR26"), for which only the Area and District are meaningful.
+func ParseOutward(s string) (Postcode, error) {
+	outward := strings.ToUpper(strings.TrimSpace(s))
+	if outward == "BFPO" || standardOutward.MatchString(outward) || SPECIAL_OUTWARD_CODES[outward] {
+		return Postcode{Outward: outward}, nil
+	}
+	return Postcode{}, fmt.Errorf("'%s' is not a valid postcode district", s)
+}
+
+// Prefixes returns the beginnings of canonical postcodes that a partly typed
+// postcode could be completing, e.g. "tr261" gives "TR26 1". Without a space
+// the split between the outward and inward codes can be ambiguous, so there
+// may be several: "W11" is the start of both "W11 1AA" and "W1 1AA".
+func Prefixes(s string) []string {
+	fields := strings.FieldsFunc(strings.ToUpper(s), func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-'
+	})
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// A separator after the first part marks the end of the outward code
+	separated := len(fields) > 1 || strings.TrimRightFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-'
+	}) != s
+	if separated {
+		if prefix, ok := splitPrefix(fields[0], strings.Join(fields[1:], "")); ok {
+			return []string{prefix}
+		}
+	}
+
+	compact := strings.Join(fields, "")
+	var prefixes []string
+	if isPartialOutward(compact) {
+		prefixes = append(prefixes, compact) // Still typing the outward code
+	}
+	for i := 2; i < len(compact); i++ {
+		if prefix, ok := splitPrefix(compact[:i], compact[i:]); ok && compact[i:] != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+func isPartialOutward(s string) bool {
+	if partialOutward.MatchString(s) || strings.HasPrefix("BFPO", s) {
+		return true
+	}
+	for outward := range SPECIAL_OUTWARD_CODES {
+		if strings.HasPrefix(outward, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitPrefix(outward string, inward string) (string, bool) {
+	switch {
+	case outward == "BFPO":
+		return outward + " " + inward, partialBFPO.MatchString(inward)
+	case standardOutward.MatchString(outward) || SPECIAL_OUTWARD_CODES[outward]:
+		return outward + " " + inward, partialInward.MatchString(inward)
+	default:
+		return "", false
+	}
+}
+
+func Valid(s string) bool {
+	_, err := Parse(s)
+	return err == nil
+}
+
+// Normalise returns the postcode in its canonical form, e.g. "SW1A 1AA".
+func Normalise(s string) (string, error) {
+	p, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return p.String(), nil
+}
+
+func (p Postcode) String() string {
+	return p.Outward + " " + p.Inward
+}
+
+func (p Postcode) IsBFPO() bool {
+	return p.Outward == "BFPO"
+}
+
+// Area is the leading letters of the outward code, e.g. "TR".
+func (p Postcode) Area() string {
+	if i := strings.IndexFunc(p.Outward, unicode.IsDigit); i >= 0 {
+		return p.Outward[:i]
+	}
+	return p.Outward
+}
+
+// District is the outward code, e.g. "TR26".
+func (p Postcode) District() string {
+	return p.Outward
+}
+
+// Sector is the outward code plus the first digit of the inward code, e.g.
+// "TR26 1". BFPO numbers have no sectors, so this is just "BFPO", as it is for
+// an outward code parsed on its own.
+func (p Postcode) Sector() string {
+	if p.IsBFPO() || p.Inward == "" {
+		return p.Outward
+	}
+	return p.Outward + " " + p.Inward[:1]
+}
+
+// Unit is the full postcode, e.g. "TR26 1AB".
+func (p Postcode) Unit() string {
+	return p.String()
+}
@@ -0,0 +1,110 @@
+package postcode
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+		area     string
+		district string
+		sector   string
+	}{
+		{input: "TR26 1AB", expected: "TR26 1AB", area: "TR", district: "TR26", sector: "TR26 1"},
+		{input: "sw1a1aa", expected: "SW1A 1AA", area: "SW", district: "SW1A", sector: "SW1A 1"},
+		{input: "SW1A  1AA", expected: "SW1A 1AA", area: "SW", district: "SW1A", sector: "SW1A 1"},
+		{input: "sw1a-1aa", expected: "SW1A 1AA", area: "SW", district: "SW1A", sector: "SW1A 1"},
+		{input: " B1  1AA ", expected: "B1 1AA", area: "B", district: "B1", sector: "B1 1"},
+		{input: "M11AE", expected: "M1 1AE", area: "M", district: "M1", sector: "M1 1"},
+		{input: "EC1Y 8AF", expected: "EC1Y 8AF", area: "EC", district: "EC1Y", sector: "EC1Y 8"},
+		{input: "W1A 0AX", expected: "W1A 0AX", area: "W", district: "W1A", sector: "W1A 0"},
+		{input: "BX1 1LT", expected: "BX1 1LT", area: "BX", district: "BX1", sector: "BX1 1"},
+		{input: "gir0aa", expected: "GIR 0AA", area: "GIR", district: "GIR", sector: "GIR 0"},
+		{input: "ASCN 1ZZ", expected: "ASCN 1ZZ", area: "ASCN", district: "ASCN", sector: "ASCN 1"},
+		{input: "BFPO 1234", expected: "BFPO 1234", area: "BFPO", district: "BFPO", sector: "BFPO"},
+		{input: "bfpo-57", expected: "BFPO 57", area: "BFPO", district: "BFPO", sector: "BFPO"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			p, err := Parse(tc.input)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, p.String())
+			require.Equal(t, tc.expected, p.Unit())
+			require.Equal(t, tc.area, p.Area())
+			require.Equal(t, tc.district, p.District())
+			require.Equal(t, tc.sector, p.Sector())
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, input := range []string{"", "TR26", "1AB", "TR26 1A", "TR26 AAB", "TR261 1AB", "ABC1 1AB", "1R26 1AB", "TR26 1AB X", "BFPO", "BFPO 12345", "XXXX 1ZZ", "TR26_1AB"} {
+		t.Run(input, func(t *testing.T) {
+			_, err := Parse(input)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "is not a valid postcode")
+			require.False(t, Valid(input))
+		})
+	}
+}
+
+func TestNormalise(t *testing.T) {
+	normalised, err := Normalise("tr261ab")
+	require.NoError(t, err)
+	require.Equal(t, "TR26 1AB", normalised)
+
+	_, err = Normalise("not a postcode")
+	require.Error(t, err)
+}
+
+func TestParseOutward(t *testing.T) {
+	p, err := ParseOutward("tr26")
+	require.NoError(t, err)
+	require.Equal(t, "TR", p.Area())
+	require.Equal(t, "TR26", p.District())
+	require.Equal(t, "TR26", p.Sector())
+
+	p, err = ParseOutward("BFPO")
+	require.NoError(t, err)
+	require.Equal(t, "BFPO", p.Area())
+
+	_, err = ParseOutward("TR26 1AB")
+	require.Error(t, err)
+}
+
+func TestPrefixes(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []string
+	}{
+		{input: "", expected: nil},
+		{input: "  ", expected: nil},
+		{input: "t", expected: []string{"T"}},
+		{input: "tr2", expected: []string{"TR2"}},
+		{input: "BF", expected: []string{"BF"}},
+		{input: "ASC", expected: []string{"ASC"}},
+		{input: "TR26", expected: []string{"TR26", "TR2 6"}},
+		{input: "TR26 ", expected: []string{"TR26 "}},
+		{input: "tr261", expected: []string{"TR26 1"}},
+		{input: "TR26 1", expected: []string{"TR26 1"}},
+		{input: "tr26-1a", expected: []string{"TR26 1A"}},
+		{input: "TR261AB", expected: []string{"TR26 1AB"}},
+		{input: "W11", expected: []string{"W11", "W1 1"}},
+		{input: "SW1A1", expected: []string{"SW1A 1"}},
+		{input: "bfpo 12", expected: []string{"BFPO 12"}},
+		{input: "gir0", expected: []string{"GIR 0"}},
+		{input: "TR26 1ABC", expected: nil},
+		{input: "1AB", expected: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			require.Equal(t, tc.expected, Prefixes(tc.input))
+		})
+	}
+}
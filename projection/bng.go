@@ -0,0 +1,180 @@
+package projection
+
+import (
+	"math"
+
+	"github.com/paulmach/orb"
+)
+
+// Conversions between the Ordnance Survey National Grid (OSGB36 datum, Airy
+// 1830 ellipsoid) and WGS84 latitude/longitude, using a transverse Mercator
+// projection plus a 7-parameter Helmert datum shift. This is accurate to
+// within about 5 metres across Great Britain, which is more than adequate for
+// locating postcodes (CodePoint Open coordinates are only given to 1 metre).
+//
+// See "A guide to coordinate systems in Great Britain" (Ordnance Survey).
+
+type ellipsoid struct {
+	a, b float64
+}
+
+var (
+	airy1830 = ellipsoid{a: 6377563.396, b: 6356256.909}
+	wgs84    = ellipsoid{a: 6378137.000, b: 6356752.314245}
+)
+
+// National Grid projection constants
+const (
+	f0   = 0.9996012717           // scale factor on central meridian
+	lat0 = 49 * math.Pi / 180     // latitude of true origin
+	lon0 = -2 * math.Pi / 180     // longitude of true origin
+	n0   = -100000.0              // northing of true origin
+	e0   = 400000.0               // easting of true origin
+	deg  = math.Pi / 180          // one degree in radians
+	arc  = math.Pi / (180 * 3600) // one arc-second in radians
+)
+
+type helmert struct {
+	tx, ty, tz float64 // translation (metres)
+	s          float64 // scale (ppm)
+	rx, ry, rz float64 // rotation (arc-seconds)
+}
+
+var (
+	wgs84ToOSGB36 = helmert{tx: -446.448, ty: 125.157, tz: -542.060, s: 20.4894, rx: -0.1502, ry: -0.2470, rz: -0.8421}
+	osgb36ToWGS84 = helmert{tx: 446.448, ty: -125.157, tz: 542.060, s: -20.4894, rx: 0.1502, ry: 0.2470, rz: 0.8421}
+)
+
+// ToWGS84 converts a National Grid easting/northing to a WGS84 point, with
+// the longitude in X and latitude in Y as per GeoJSON conventions.
+func ToWGS84(easting, northing float64) orb.Point {
+	lat, lon := gridToLatLon(easting, northing)
+	x, y, z := toCartesian(lat, lon, airy1830)
+	x, y, z = osgb36ToWGS84.apply(x, y, z)
+	lat, lon = fromCartesian(x, y, z, wgs84)
+	return orb.Point{roundTo(lon/deg, 1e6), roundTo(lat/deg, 1e6)}
+}
+
+// ToBNG converts a WGS84 point (longitude in X, latitude in Y) to a National
+// Grid easting/northing.
+func ToBNG(point orb.Point) (float64, float64) {
+	x, y, z := toCartesian(point.Lat()*deg, point.Lon()*deg, wgs84)
+	x, y, z = wgs84ToOSGB36.apply(x, y, z)
+	lat, lon := fromCartesian(x, y, z, airy1830)
+	easting, northing := latLonToGrid(lat, lon)
+	return roundTo(easting, 1e3), roundTo(northing, 1e3)
+}
+
+// meridionalArc computes the developed arc of the meridian from the true
+// origin to the given latitude (OS guide, equation C3).
+func meridionalArc(lat float64) float64 {
+	a, b := airy1830.a, airy1830.b
+	n := (a - b) / (a + b)
+	n2, n3 := n*n, n*n*n
+	dLat, sLat := lat-lat0, lat+lat0
+
+	return b * f0 * ((1+n+5.0/4*n2+5.0/4*n3)*dLat -
+		(3*n+3*n2+21.0/8*n3)*math.Sin(dLat)*math.Cos(sLat) +
+		(15.0/8*n2+15.0/8*n3)*math.Sin(2*dLat)*math.Cos(2*sLat) -
+		35.0/24*n3*math.Sin(3*dLat)*math.Cos(3*sLat))
+}
+
+func radii(lat float64) (nu, rho, eta2 float64) {
+	a, b := airy1830.a, airy1830.b
+	e2 := 1 - (b*b)/(a*a)
+	sinLat := math.Sin(lat)
+	nu = a * f0 / math.Sqrt(1-e2*sinLat*sinLat)
+	rho = a * f0 * (1 - e2) / math.Pow(1-e2*sinLat*sinLat, 1.5)
+	eta2 = nu/rho - 1
+	return nu, rho, eta2
+}
+
+// latLonToGrid projects an OSGB36 latitude/longitude (radians) to a National
+// Grid easting/northing.
+func latLonToGrid(lat, lon float64) (float64, float64) {
+	nu, rho, eta2 := radii(lat)
+	m := meridionalArc(lat)
+
+	sinLat, cosLat, tanLat := math.Sin(lat), math.Cos(lat), math.Tan(lat)
+	cos3, cos5 := cosLat*cosLat*cosLat, math.Pow(cosLat, 5)
+	tan2, tan4 := tanLat*tanLat, math.Pow(tanLat, 4)
+
+	i := m + n0
+	ii := nu / 2 * sinLat * cosLat
+	iii := nu / 24 * sinLat * cos3 * (5 - tan2 + 9*eta2)
+	iiiA := nu / 720 * sinLat * cos5 * (61 - 58*tan2 + tan4)
+	iv := nu * cosLat
+	v := nu / 6 * cos3 * (nu/rho - tan2)
+	vi := nu / 120 * cos5 * (5 - 18*tan2 + tan4 + 14*eta2 - 58*tan2*eta2)
+
+	dLon := lon - lon0
+	northing := i + ii*math.Pow(dLon, 2) + iii*math.Pow(dLon, 4) + iiiA*math.Pow(dLon, 6)
+	easting := e0 + iv*dLon + v*math.Pow(dLon, 3) + vi*math.Pow(dLon, 5)
+	return easting, northing
+}
+
+// gridToLatLon unprojects a National Grid easting/northing to an OSGB36
+// latitude/longitude (radians).
+func gridToLatLon(easting, northing float64) (float64, float64) {
+	lat, m := lat0, 0.0
+	for {
+		lat = (northing-n0-m)/(airy1830.a*f0) + lat
+		m = meridionalArc(lat)
+		if math.Abs(northing-n0-m) < 0.00001 { // 0.01 mm
+			break
+		}
+	}
+
+	nu, rho, eta2 := radii(lat)
+	tanLat, secLat := math.Tan(lat), 1/math.Cos(lat)
+	tan2, tan4, tan6 := tanLat*tanLat, math.Pow(tanLat, 4), math.Pow(tanLat, 6)
+
+	vii := tanLat / (2 * rho * nu)
+	viii := tanLat / (24 * rho * math.Pow(nu, 3)) * (5 + 3*tan2 + eta2 - 9*tan2*eta2)
+	ix := tanLat / (720 * rho * math.Pow(nu, 5)) * (61 + 90*tan2 + 45*tan4)
+	x := secLat / nu
+	xi := secLat / (6 * math.Pow(nu, 3)) * (nu/rho + 2*tan2)
+	xii := secLat / (120 * math.Pow(nu, 5)) * (5 + 28*tan2 + 24*tan4)
+	xiiA := secLat / (5040 * math.Pow(nu, 7)) * (61 + 662*tan2 + 1320*tan4 + 720*tan6)
+
+	dE := easting - e0
+	lat = lat - vii*math.Pow(dE, 2) + viii*math.Pow(dE, 4) - ix*math.Pow(dE, 6)
+	lon := lon0 + x*dE - xi*math.Pow(dE, 3) + xii*math.Pow(dE, 5) - xiiA*math.Pow(dE, 7)
+	return lat, lon
+}
+
+func toCartesian(lat, lon float64, e ellipsoid) (float64, float64, float64) {
+	e2 := 1 - (e.b*e.b)/(e.a*e.a)
+	sinLat, cosLat := math.Sin(lat), math.Cos(lat)
+	nu := e.a / math.Sqrt(1-e2*sinLat*sinLat)
+	return nu * cosLat * math.Cos(lon), nu * cosLat * math.Sin(lon), (1 - e2) * nu * sinLat
+}
+
+func fromCartesian(x, y, z float64, e ellipsoid) (float64, float64) {
+	e2 := 1 - (e.b*e.b)/(e.a*e.a)
+	p := math.Sqrt(x*x + y*y)
+	lat := math.Atan2(z, p*(1-e2))
+	for {
+		sinLat := math.Sin(lat)
+		nu := e.a / math.Sqrt(1-e2*sinLat*sinLat)
+		next := math.Atan2(z+e2*nu*sinLat, p)
+		if math.Abs(next-lat) < 1e-12 {
+			lat = next
+			break
+		}
+		lat = next
+	}
+	return lat, math.Atan2(y, x)
+}
+
+func (h helmert) apply(x, y, z float64) (float64, float64, float64) {
+	s1 := h.s/1e6 + 1
+	rx, ry, rz := h.rx*arc, h.ry*arc, h.rz*arc
+	return h.tx + x*s1 - y*rz + z*ry,
+		h.ty + x*rz + y*s1 - z*rx,
+		h.tz - x*ry + y*rx + z*s1
+}
+
+func roundTo(value float64, precision float64) float64 {
+	return math.Round(value*precision) / precision
+}
@@ -0,0 +1,42 @@
+package projection
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatLonToGrid(t *testing.T) {
+	// Worked example from the OS guide (Caister water tower)
+	lat := (52 + 39.0/60 + 27.2531/3600) * deg
+	lon := (1 + 43.0/60 + 4.5177/3600) * deg
+
+	easting, northing := latLonToGrid(lat, lon)
+	require.InDelta(t, 651409.903, easting, 0.001)
+	require.InDelta(t, 313177.270, northing, 0.001)
+}
+
+func TestGridToLatLon(t *testing.T) {
+	lat, lon := gridToLatLon(651409.903, 313177.270)
+	require.InDelta(t, 52+39.0/60+27.2531/3600, lat/deg, 1e-7)
+	require.InDelta(t, 1+43.0/60+4.5177/3600, lon/deg, 1e-7)
+}
+
+func TestToWGS84(t *testing.T) {
+	// OSGB36 and WGS84 diverge by roughly 110 metres around London: a point
+	// on the OSGB36 zero meridian at Greenwich lies just west of it in WGS84.
+	easting, northing := latLonToGrid((51+28.0/60+40.0/3600)*deg, 0)
+	require.InDelta(t, 538873, easting, 1)
+
+	point := ToWGS84(easting, northing)
+	require.InDelta(t, -0.00162, point.Lon(), 0.00005)
+	require.InDelta(t, 51.47829, point.Lat(), 0.00005)
+}
+
+func TestToBNG_RoundTrip(t *testing.T) {
+	for _, en := range [][2]float64{{529090, 179645}, {325167, 673539}, {151812, 40499}, {651409, 313177}} {
+		easting, northing := ToBNG(ToWGS84(en[0], en[1]))
+		require.InDelta(t, en[0], easting, 0.5)
+		require.InDelta(t, en[1], northing, 0.5)
+	}
+}
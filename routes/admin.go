@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Reloader interface {
+	// Start begins reloading in the background, returning false if a reload
+	// is already in progress.
+	Start() bool
+}
+
+// RequireToken rejects requests without an `Authorization: Bearer <token>`
+// header matching the given token.
+func RequireToken(token string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		provided, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "a valid admin token is required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func AdminReload(reloader Reloader) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !reloader.Start() {
+			c.JSON(http.StatusConflict, gin.H{"error": "a reload is already in progress"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"status": "reload started"})
+	}
+}
@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+type mockReloader struct {
+	running bool
+	started int
+}
+
+func (m *mockReloader) Start() bool {
+	if m.running {
+		return false
+	}
+	m.started++
+	return true
+}
+
+func adminRouter(reloader Reloader) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/admin/reload", RequireToken("s3cret"), AdminReload(reloader))
+	return r
+}
+
+func TestAdminReload_Unauthorized(t *testing.T) {
+	for _, header := range []string{"", "Bearer wrong", "s3cret", "Basic s3cret"} {
+		t.Run(header, func(t *testing.T) {
+			reloader := &mockReloader{}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/admin/reload", nil)
+			if header != "" {
+				req.Header.Set("Authorization", header)
+			}
+
+			adminRouter(reloader).ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusUnauthorized, w.Code)
+			require.Contains(t, w.Body.String(), "a valid admin token is required")
+			require.Equal(t, 0, reloader.started)
+		})
+	}
+}
+
+func TestAdminReload_Started(t *testing.T) {
+	reloader := &mockReloader{}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/admin/reload", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+
+	adminRouter(reloader).ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Equal(t, 1, reloader.started)
+}
+
+func TestAdminReload_InProgress(t *testing.T) {
+	reloader := &mockReloader{running: true}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/admin/reload", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+
+	adminRouter(reloader).ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Contains(t, w.Body.String(), "a reload is already in progress")
+}
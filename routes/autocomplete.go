@@ -0,0 +1,51 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	spatialindex "postcode-polygons/spatial-index"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const DEFAULT_AUTOCOMPLETE_LIMIT = 10
+const MAX_AUTOCOMPLETE_LIMIT = 100
+
+type AutocompleteResponse struct {
+	Results     []spatialindex.CodePoint `json:"results"`
+	Attribution []string                 `json:"attribution"`
+}
+
+// PostcodeAutocomplete suggests postcodes starting with a partly typed one,
+// e.g. "TR26 1" or "tr261", for type-ahead in address forms.
+func PostcodeAutocomplete(idx spatialindex.SpatialIndex) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		idx := spatialindex.Current(idx)
+		query := c.Query("q")
+		if strings.TrimSpace(query) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q must be provided"})
+			return
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(DEFAULT_AUTOCOMPLETE_LIMIT)))
+		if err != nil || limit < 1 || limit > MAX_AUTOCOMPLETE_LIMIT {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a number between 1 and %d", MAX_AUTOCOMPLETE_LIMIT)})
+			return
+		}
+
+		results, err := idx.Autocomplete(query, limit)
+		if err != nil {
+			log.Printf("error while fetching postcode data: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
+			return
+		}
+
+		c.JSON(http.StatusOK, AutocompleteResponse{
+			Results:     *results,
+			Attribution: ATTRIBUTION,
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	spatialindex "postcode-polygons/spatial-index"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostcodeAutocomplete_BadParams(t *testing.T) {
+	testCases := []struct {
+		name        string
+		query       string
+		errContains string
+	}{
+		{name: "missing query", query: "limit=5", errContains: "q must be provided"},
+		{name: "blank query", query: "q=%20%20", errContains: "q must be provided"},
+		{name: "limit too small", query: "q=TR26&limit=0", errContains: "limit must be a number between 1 and 100"},
+		{name: "limit too large", query: "q=TR26&limit=101", errContains: "limit must be a number between 1 and 100"},
+		{name: "limit not a number", query: "q=TR26&limit=abc", errContains: "limit must be a number between 1 and 100"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/v1/postcode/autocomplete?"+tc.query, nil)
+
+			handler := PostcodeAutocomplete(&mockSpatialIndex{})
+			handler(c)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Contains(t, w.Body.String(), tc.errContains)
+		})
+	}
+}
+
+func TestPostcodeAutocomplete_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/autocomplete?q=tr26%201&limit=2", nil)
+
+	spatialIdx := &mockSpatialIndex{
+		AutocompleteFunc: func(query string, limit int) (*[]spatialindex.CodePoint, error) {
+			require.Equal(t, "tr26 1", query)
+			require.Equal(t, 2, limit)
+			results := []spatialindex.CodePoint{
+				{PostCode: "TR26 1AB", Easting: 1, Northing: 2},
+				{PostCode: "TR26 1AD", Easting: 3, Northing: 4},
+			}
+			return &results, nil
+		},
+	}
+	handler := PostcodeAutocomplete(spatialIdx)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `{"post_code":"TR26 1AB","easting":1,"northing":2,`)
+	require.Contains(t, w.Body.String(), `{"post_code":"TR26 1AD","easting":3,"northing":4,`)
+}
+
+func TestPostcodeAutocomplete_DefaultLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/autocomplete?q=TR", nil)
+
+	spatialIdx := &mockSpatialIndex{
+		AutocompleteFunc: func(query string, limit int) (*[]spatialindex.CodePoint, error) {
+			require.Equal(t, DEFAULT_AUTOCOMPLETE_LIMIT, limit)
+			results := []spatialindex.CodePoint{}
+			return &results, nil
+		},
+	}
+	handler := PostcodeAutocomplete(spatialIdx)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"results":[]`)
+}
+
+func TestPostcodeAutocomplete_Error(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/autocomplete?q=TR26", nil)
+
+	spatialIdx := &mockSpatialIndex{
+		AutocompleteFunc: func(query string, limit int) (*[]spatialindex.CodePoint, error) {
+			return nil, errors.New("fail")
+		},
+	}
+	handler := PostcodeAutocomplete(spatialIdx)
+	handler(c)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "An internal server error occurred")
+}
@@ -0,0 +1,152 @@
+package routes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"postcode-polygons/internal"
+	"postcode-polygons/postcode"
+	spatialindex "postcode-polygons/spatial-index"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb/geojson"
+)
+
+const MAX_BATCH_SIZE = 10_000       // Maximum number of postcodes per request
+const MAX_BATCH_BYTES = 1024 * 1024 // Maximum request body size (1 MB)
+
+type BatchResult struct {
+	Query     string                  `json:"query"`
+	CodePoint *spatialindex.CodePoint `json:"codepoint,omitempty"`
+	Feature   *geojson.Feature        `json:"feature,omitempty"`
+	Error     string                  `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Results     []BatchResult `json:"results"`
+	Attribution []string      `json:"attribution"`
+}
+
+// PostcodeBatch looks up many postcodes at once, given either as a JSON array
+// of strings or as plain text with one postcode per line. Results are in the
+// same order as the request, with an error for any postcode that is invalid or
+// not found.
+func PostcodeBatch(idx spatialindex.SpatialIndex, repo internal.PolygonsRepo) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		idx := spatialindex.Current(idx)
+		includePolygons, err := strconv.ParseBool(c.DefaultQuery("polygons", "false"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid polygons value '%s'", c.Query("polygons"))})
+			return
+		}
+
+		queries, err := parseBatch(http.MaxBytesReader(c.Writer, c.Request.Body, MAX_BATCH_BYTES))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("request body is too large, must be no more than %d bytes", tooLarge.Limit)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(queries) > MAX_BATCH_SIZE {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("too many postcodes, must be no more than %d", MAX_BATCH_SIZE)})
+			return
+		}
+
+		results := make([]BatchResult, len(queries))
+		for i, query := range queries {
+			results[i].Query = query
+			parsed, err := postcode.Parse(query)
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			codePoint, found := idx.Lookup(parsed.String())
+			if !found {
+				results[i].Error = fmt.Sprintf("postcode '%s' not found", parsed)
+				continue
+			}
+			results[i].CodePoint = codePoint
+		}
+
+		if includePolygons {
+			if err := addFeatures(repo, results); err != nil {
+				log.Printf("error while collecting polygons: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, BatchResponse{
+			Results:     results,
+			Attribution: ATTRIBUTION,
+		})
+	}
+}
+
+// addFeatures fills in the unit polygon for each result that was found,
+// skipping districts without a polygon file.
+func addFeatures(repo internal.PolygonsRepo, results []BatchResult) error {
+	missing := make(map[string]struct{})
+	for i := range results {
+		if results[i].CodePoint == nil {
+			continue
+		}
+		parsed, err := postcode.Parse(results[i].CodePoint.PostCode)
+		if err != nil {
+			continue
+		}
+		district := parsed.District()
+		if _, skip := missing[district]; skip {
+			continue
+		}
+
+		feature, err := repo.RetrieveFeature("units", district, parsed.Unit())
+		if err != nil && os.IsNotExist(err) {
+			log.Printf("polygon file for district %s does not exist, skipping", district)
+			missing[district] = struct{}{}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error loading feature %s for district %s: %w", parsed, district, err)
+		}
+		results[i].Feature = feature
+	}
+	return nil
+}
+
+func parseBatch(body io.Reader) ([]string, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	var postcodes []string
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &postcodes); err != nil {
+			return nil, fmt.Errorf("request body must be a JSON array of postcodes: %w", err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				postcodes = append(postcodes, line)
+			}
+		}
+	}
+
+	if len(postcodes) == 0 {
+		return nil, fmt.Errorf("no postcodes provided")
+	}
+	return postcodes, nil
+}
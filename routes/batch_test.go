@@ -0,0 +1,131 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	spatialindex "postcode-polygons/spatial-index"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+func batchIndex() *mockSpatialIndex {
+	return lookupIndex(
+		spatialindex.CodePoint{PostCode: "AB1 2CD", Easting: 1, Northing: 2},
+		spatialindex.CodePoint{PostCode: "AB1 2CE", Easting: 3, Northing: 4},
+		spatialindex.CodePoint{PostCode: "XY9 9ZZ", Easting: 5, Northing: 6},
+	)
+}
+
+func batchRequest(t *testing.T, handler func(c *gin.Context), query string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/v1/postcode/batch"+query, strings.NewReader(body))
+	handler(c)
+	return w
+}
+
+func TestPostcodeBatch_JSON(t *testing.T) {
+	w := batchRequest(t, PostcodeBatch(batchIndex(), &mockPolygonsRepo{}), "", `["ab12cd", "ZZ1 1ZZ", "XY9 9ZZ", "NOPE"]`)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	require.Contains(t, body, `{"query":"ab12cd","codepoint":{"post_code":"AB1 2CD"`)
+	require.Contains(t, body, `{"query":"ZZ1 1ZZ","error":"postcode 'ZZ1 1ZZ' not found"}`)
+	require.Contains(t, body, `{"query":"XY9 9ZZ","codepoint":{"post_code":"XY9 9ZZ"`)
+	require.Contains(t, body, `{"query":"NOPE","error":"'NOPE' is not a valid postcode"}`)
+	require.Less(t, strings.Index(body, "ab12cd"), strings.Index(body, "ZZ1 1ZZ"))
+	require.NotContains(t, body, `"feature"`)
+}
+
+func TestPostcodeBatch_NewlineDelimited(t *testing.T) {
+	w := batchRequest(t, PostcodeBatch(batchIndex(), &mockPolygonsRepo{}), "", "AB1 2CD\r\n\n  AB1 2CE  \n")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"query":"AB1 2CD"`)
+	require.Contains(t, w.Body.String(), `"query":"AB1 2CE"`)
+	require.Equal(t, 2, strings.Count(w.Body.String(), `"query"`))
+}
+
+func TestPostcodeBatch_Polygons(t *testing.T) {
+	var lookups []string
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureFunc: func(target string, district string, id string) (*geojson.Feature, error) {
+			lookups = append(lookups, id)
+			require.Equal(t, "units", target)
+			if district == "XY9" {
+				return nil, os.ErrNotExist
+			}
+			require.Equal(t, "AB1", district)
+			feature := geojson.NewFeature(orb.Point{1, 2})
+			feature.ID = id
+			return feature, nil
+		},
+	}
+
+	idx := lookupIndex(
+		spatialindex.CodePoint{PostCode: "AB1 2CD", Easting: 1, Northing: 2},
+		spatialindex.CodePoint{PostCode: "AB1 2CE", Easting: 3, Northing: 4},
+		spatialindex.CodePoint{PostCode: "XY9 9ZZ", Easting: 5, Northing: 6},
+		spatialindex.CodePoint{PostCode: "XY9 9ZY", Easting: 7, Northing: 8},
+	)
+	w := batchRequest(t, PostcodeBatch(idx, repo), "?polygons=true", `["AB1 2CD", "AB1 2CE", "XY9 9ZZ", "XY9 9ZY"]`)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, []string{"AB1 2CD", "AB1 2CE", "XY9 9ZZ"}, lookups) // Only once for the missing district
+	require.Contains(t, w.Body.String(), `"id":"AB1 2CD"`)
+	require.Contains(t, w.Body.String(), `"id":"AB1 2CE"`)
+	require.Equal(t, 2, strings.Count(w.Body.String(), `"feature"`))
+}
+
+func TestPostcodeBatch_PolygonRepoError(t *testing.T) {
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			return nil, errors.New("failed to load polygon")
+		},
+	}
+
+	w := batchRequest(t, PostcodeBatch(batchIndex(), repo), "?polygons=true", `["AB1 2CD"]`)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "An internal server error occurred")
+}
+
+func TestPostcodeBatch_BadRequest(t *testing.T) {
+	testCases := []struct {
+		name        string
+		query       string
+		body        string
+		errContains string
+	}{
+		{name: "empty", body: "  \n", errContains: "no postcodes provided"},
+		{name: "empty array", body: "[]", errContains: "no postcodes provided"},
+		{name: "bad json", body: `["AB1 2CD", 12]`, errContains: "request body must be a JSON array of postcodes"},
+		{name: "bad polygons", query: "?polygons=maybe", body: `["AB1 2CD"]`, errContains: "invalid polygons value 'maybe'"},
+		{name: "too many", body: strings.Repeat("AB1 2CD\n", MAX_BATCH_SIZE+1), errContains: "too many postcodes, must be no more than 10000"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := batchRequest(t, PostcodeBatch(batchIndex(), &mockPolygonsRepo{}), tc.query, tc.body)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Contains(t, w.Body.String(), tc.errContains)
+		})
+	}
+}
+
+func TestPostcodeBatch_TooLarge(t *testing.T) {
+	w := batchRequest(t, PostcodeBatch(batchIndex(), &mockPolygonsRepo{}), "", strings.Repeat("A", MAX_BATCH_BYTES+1))
+
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	require.Contains(t, w.Body.String(), "request body is too large, must be no more than 1048576 bytes")
+}
@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/clip"
+	"github.com/paulmach/orb/geojson"
+)
+
+// clipFeatures cuts each feature's geometry down to the bound, dropping any
+// features that fall entirely outside it. The features may be shared with the
+// polygon cache, so anything that needs clipping is copied first.
+func clipFeatures(features []*geojson.Feature, bound orb.Bound) []*geojson.Feature {
+	clipped := make([]*geojson.Feature, 0, len(features))
+
+	for _, feature := range features {
+		if feature.Geometry == nil {
+			continue
+		}
+
+		geometryBound := feature.Geometry.Bound()
+		if bound.Contains(geometryBound.Min) && bound.Contains(geometryBound.Max) {
+			clipped = append(clipped, feature)
+			continue
+		}
+
+		geometry := clip.Geometry(bound, orb.Clone(feature.Geometry))
+		if geometry == nil {
+			continue
+		}
+
+		copied := geojson.NewFeature(geometry)
+		copied.ID = feature.ID
+		copied.Properties = feature.Properties.Clone()
+		clipped = append(clipped, copied)
+	}
+
+	return clipped
+}
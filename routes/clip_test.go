@@ -0,0 +1,40 @@
+package routes
+
+import (
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+func square(minX, minY, maxX, maxY float64) orb.Polygon {
+	return orb.Polygon{{{minX, minY}, {maxX, minY}, {maxX, maxY}, {minX, maxY}, {minX, minY}}}
+}
+
+func TestClipFeatures(t *testing.T) {
+	bound := orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{10, 10}}
+
+	inside := geojson.NewFeature(square(1, 1, 2, 2))
+	inside.ID = "inside"
+	crossing := geojson.NewFeature(orb.MultiPolygon{square(5, 5, 15, 15), square(20, 20, 30, 30)})
+	crossing.ID = "crossing"
+	crossing.Properties["type"] = "unit"
+	outside := geojson.NewFeature(square(20, 20, 30, 30))
+	outside.ID = "outside"
+	empty := geojson.NewFeature(nil)
+	empty.ID = "empty"
+
+	features := []*geojson.Feature{inside, crossing, outside, empty}
+	clipped := clipFeatures(features, bound)
+
+	require.Len(t, clipped, 2)
+	require.Same(t, inside, clipped[0]) // Nothing to clip, so not copied
+	require.Equal(t, "crossing", clipped[1].ID)
+	require.Equal(t, "unit", clipped[1].Properties["type"])
+	require.Equal(t, orb.Bound{Min: orb.Point{5, 5}, Max: orb.Point{10, 10}}, clipped[1].Geometry.Bound())
+
+	// The original features are left untouched
+	require.Len(t, features, 4)
+	require.Equal(t, orb.MultiPolygon{square(5, 5, 15, 15), square(20, 20, 30, 30)}, crossing.Geometry)
+}
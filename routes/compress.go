@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"compress/gzip"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const uncompressedWriterKey = "routes.uncompressedWriter"
+
+// KeepUncompressedWriter records the response writer before the compress
+// middleware wraps it. That middleware can't flush what its compressor has
+// buffered, so streamed responses write around it and compress themselves.
+// It must be used before compress.Compress().
+func KeepUncompressedWriter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(uncompressedWriterKey, c.Writer)
+		c.Next()
+	}
+}
+
+// streamWriter writes a streamed response straight to the client, gzipped
+// if the compress middleware would otherwise have compressed it, so that each
+// flush sends everything written so far. Streamed responses are only ever
+// gzipped: a client that accepts br or zstd but not gzip gets them
+// uncompressed, even though the middleware would have used one of those.
+type streamWriter struct {
+	w  gin.ResponseWriter
+	gz *gzip.Writer
+}
+
+// newStreamWriter starts a streamed response. Headers must already be set.
+func newStreamWriter(c *gin.Context) *streamWriter {
+	uncompressed, ok := c.Value(uncompressedWriterKey).(gin.ResponseWriter)
+	if !ok || uncompressed == c.Writer {
+		return &streamWriter{w: c.Writer}
+	}
+
+	// The compress middleware has wrapped the writer, but nothing will have
+	// been written to it, so it has nothing to send when it's closed
+	if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+		return &streamWriter{w: uncompressed}
+	}
+	uncompressed.Header().Set("Content-Encoding", "gzip")
+	uncompressed.Header().Add("Vary", "Accept-Encoding")
+	uncompressed.Header().Del("Content-Length")
+	return &streamWriter{w: uncompressed, gz: gzip.NewWriter(uncompressed)}
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.gz != nil {
+		return s.gz.Write(p)
+	}
+	return s.w.Write(p)
+}
+
+// Flush sends everything written so far to the client.
+func (s *streamWriter) Flush() error {
+	if s.gz != nil {
+		if err := s.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	s.w.Flush()
+	return nil
+}
+
+// Close finishes the response, once everything has been written.
+func (s *streamWriter) Close() error {
+	if s.gz != nil {
+		return s.gz.Close()
+	}
+	return nil
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, either
+// by name or through "*". A weight given for gzip itself takes precedence over
+// one for "*", whichever comes first.
+func acceptsGzip(acceptEncoding string) bool {
+	gzipWeight, anyWeight := -1.0, -1.0
+	for _, encoding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(encoding, ";")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip":
+			gzipWeight = max(gzipWeight, encodingWeight(params))
+		case "*":
+			anyWeight = max(anyWeight, encodingWeight(params))
+		}
+	}
+	if gzipWeight >= 0 {
+		return gzipWeight > 0
+	}
+	return anyWeight > 0
+}
+
+// encodingWeight reads the q-value from the parameters of an Accept-Encoding
+// entry, which is 1 if it's missing. An invalid q-value counts as 0.
+func encodingWeight(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(key), "q") {
+			continue
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 || weight > 1 {
+			return 0
+		}
+		return weight
+	}
+	return 1
+}
@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"postcode-polygons/projection"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+)
+
+const CRS_BNG = "EPSG:27700"  // British National Grid eastings/northings
+const CRS_WGS84 = "EPSG:4326" // WGS84 longitude/latitude
+
+func parseCRS(c *gin.Context) (string, error) {
+	crs := strings.ToUpper(c.DefaultQuery("crs", CRS_BNG))
+	if crs != CRS_BNG && crs != CRS_WGS84 {
+		return "", fmt.Errorf("unsupported crs '%s', must be one of %s or %s", c.Query("crs"), CRS_BNG, CRS_WGS84)
+	}
+	return crs, nil
+}
+
+// parseWGS84BBox reads a min_lon,min_lat,max_lon,max_lat bounding box, and
+// returns the smallest eastings/northings bounding box that encloses it.
+func parseWGS84BBox(bboxStr string) ([]uint32, error) {
+	bboxParts := strings.Split(bboxStr, ",")
+	if len(bboxParts) != 4 {
+		return nil, fmt.Errorf("bbox must have 4 comma-separated values")
+	}
+
+	values := make([]float64, 4)
+	for i, part := range bboxParts {
+		val, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bbox value '%s': not a valid float", part)
+		}
+		values[i] = val
+	}
+
+	bound := orb.Bound{Min: orb.Point{values[0], values[1]}, Max: orb.Point{values[2], values[3]}}
+	if bound.Min.Lon() > bound.Max.Lon() || bound.Min.Lat() > bound.Max.Lat() {
+		return nil, fmt.Errorf("invalid bbox: min values must be less than or equal to max values")
+	}
+	if bound.Min.Lon() < -180 || bound.Max.Lon() > 180 || bound.Min.Lat() < -90 || bound.Max.Lat() > 90 {
+		return nil, fmt.Errorf("invalid bbox: longitude/latitude values out of range")
+	}
+
+	return boundToBNG(bound), nil
+}
+
+// boundToBNG returns the smallest eastings/northings bounding box enclosing
+// the WGS84 bound. Grid lines are not parallel to lines of latitude/longitude,
+// so all four corners need reprojecting.
+func boundToBNG(bound orb.Bound) []uint32 {
+	minE, minN, maxE, maxN := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, corner := range []orb.Point{bound.Min, bound.Max, bound.LeftTop(), bound.RightBottom()} {
+		easting, northing := projection.ToBNG(corner)
+		minE, maxE = math.Min(minE, easting), math.Max(maxE, easting)
+		minN, maxN = math.Min(minN, northing), math.Max(maxN, northing)
+	}
+
+	return []uint32{
+		uint32(math.Max(math.Floor(minE), 0)),
+		uint32(math.Max(math.Floor(minN), 0)),
+		uint32(math.Max(math.Ceil(maxE), 0)),
+		uint32(math.Max(math.Ceil(maxN), 0)),
+	}
+}
+
+// bngToBound returns the smallest WGS84 bound enclosing the eastings/northings
+// bounding box, i.e. the reverse of boundToBNG.
+func bngToBound(bbox []uint32) orb.Bound {
+	corners := []orb.Point{
+		projection.ToWGS84(float64(bbox[0]), float64(bbox[1])),
+		projection.ToWGS84(float64(bbox[2]), float64(bbox[3])),
+		projection.ToWGS84(float64(bbox[0]), float64(bbox[3])),
+		projection.ToWGS84(float64(bbox[2]), float64(bbox[1])),
+	}
+
+	bound := corners[0].Bound()
+	for _, corner := range corners[1:] {
+		bound = bound.Extend(corner)
+	}
+	return bound
+}
@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"postcode-polygons/projection"
+	spatialindex "postcode-polygons/spatial-index"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWGS84BBox(t *testing.T) {
+	testCases := []struct {
+		name        string
+		bboxStr     string
+		expectErr   bool
+		errContains string
+	}{
+		{name: "valid", bboxStr: "-0.13,51.50,-0.12,51.51", expectErr: false},
+		{name: "too few parts", bboxStr: "-0.13,51.50,-0.12", expectErr: true, errContains: "bbox must have 4 comma-separated values"},
+		{name: "not numbers", bboxStr: "a,b,c,d", expectErr: true, errContains: "invalid bbox value"},
+		{name: "min greater than max", bboxStr: "-0.12,51.51,-0.13,51.50", expectErr: true, errContains: "min values must be less than or equal to max values"},
+		{name: "out of range", bboxStr: "-0.13,51.50,-0.12,91", expectErr: true, errContains: "out of range"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseWGS84BBox(tc.bboxStr)
+			if tc.expectErr {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.errContains)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParseWGS84BBox_EnclosesAllCorners(t *testing.T) {
+	bbox, err := parseWGS84BBox("-0.13,51.50,-0.12,51.51")
+	require.NoError(t, err)
+
+	for _, corner := range [][2]float64{{-0.13, 51.50}, {-0.12, 51.50}, {-0.13, 51.51}, {-0.12, 51.51}} {
+		easting, northing := projection.ToBNG(corner)
+		require.GreaterOrEqual(t, easting, float64(bbox[0]))
+		require.GreaterOrEqual(t, northing, float64(bbox[1]))
+		require.LessOrEqual(t, easting, float64(bbox[2]))
+		require.LessOrEqual(t, northing, float64(bbox[3]))
+	}
+	require.False(t, isTooBig(bbox))
+}
+
+func TestBNGToBound(t *testing.T) {
+	bbox, err := parseWGS84BBox("-0.13,51.50,-0.12,51.51")
+	require.NoError(t, err)
+
+	// The round trip can only grow the bound
+	bound := bngToBound(bbox)
+	for _, corner := range []orb.Point{{-0.13, 51.50}, {-0.12, 51.50}, {-0.13, 51.51}, {-0.12, 51.51}} {
+		require.True(t, bound.Contains(corner), corner)
+	}
+	require.InDelta(t, -0.13, bound.Min.Lon(), 0.001)
+	require.InDelta(t, 51.51, bound.Max.Lat(), 0.001)
+}
+
+func TestCodePointSearch_WGS84(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/search?crs=EPSG:4326&bbox=-0.13,51.50,-0.12,51.51", nil)
+
+	spatialIdx := &mockSpatialIndex{
+		SearchFunc: func(bounds []uint32) (*[]spatialindex.CodePoint, error) {
+			require.InDelta(t, 529800, bounds[0], 100)
+			require.InDelta(t, 179500, bounds[1], 100)
+			results := []spatialindex.CodePoint{spatialindex.NewCodePoint("SW1A 2AA", 530047, 179951)}
+			return &results, nil
+		},
+	}
+	handler := CodePointSearch(spatialIdx)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"post_code":"SW1A 2AA","easting":530047,"northing":179951,"lat":51.50`)
+}
+
+func TestCodePointSearch_UnsupportedCRS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/search?crs=EPSG:3857&bbox=0,0,1,1", nil)
+
+	handler := CodePointSearch(&mockSpatialIndex{})
+	handler(c)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "unsupported crs 'EPSG:3857'")
+}
+
+func TestPolygonSearch_WGS84(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?crs=epsg:4326&bbox=-0.13,51.50,-0.12,51.51", nil)
+
+	var searched []uint32
+	spatialIdx := &mockSpatialIndex{
+		SearchIterFunc: func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error {
+			searched = bounds
+			return nil
+		},
+	}
+	handler := PolygonSearch(spatialIdx, &mockPolygonsRepo{})
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.InDelta(t, 529800-UNITS_BOUNDS_EXPANSION, searched[0], 100)
+	require.InDelta(t, 179500-UNITS_BOUNDS_EXPANSION, searched[1], 100)
+}
@@ -0,0 +1,49 @@
+package routes
+
+import (
+	spatialindex "postcode-polygons/spatial-index"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Query parameters that can be used to filter search results by the codepoint
+// attributes. Values match any code starting with them, so e.g. `country=S`
+// selects Scottish postcodes (country code S92000003).
+var FILTER_PARAMS = map[string]func(cp *spatialindex.CodePoint) string{
+	"country":              func(cp *spatialindex.CodePoint) string { return cp.CountryCode },
+	"country_code":         func(cp *spatialindex.CodePoint) string { return cp.CountryCode },
+	"nhs_regional_ha_code": func(cp *spatialindex.CodePoint) string { return cp.NHSRegionalHACode },
+	"nhs_ha_code":          func(cp *spatialindex.CodePoint) string { return cp.NHSHACode },
+	"admin_county_code":    func(cp *spatialindex.CodePoint) string { return cp.AdminCountyCode },
+	"admin_district_code":  func(cp *spatialindex.CodePoint) string { return cp.AdminDistrictCode },
+	"admin_ward_code":      func(cp *spatialindex.CodePoint) string { return cp.AdminWardCode },
+}
+
+// parseFilter builds a filter from any attribute parameters in the request,
+// returning nil if there are none.
+func parseFilter(c *gin.Context) spatialindex.Filter {
+	type condition struct {
+		field  func(cp *spatialindex.CodePoint) string
+		prefix string
+	}
+
+	var conditions []condition
+	for param, field := range FILTER_PARAMS {
+		if value := strings.TrimSpace(c.Query(param)); value != "" {
+			conditions = append(conditions, condition{field, strings.ToUpper(value)})
+		}
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+
+	return func(cp *spatialindex.CodePoint) bool {
+		for _, cond := range conditions {
+			if !strings.HasPrefix(cond.field(cp), cond.prefix) {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,129 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	spatialindex "postcode-polygons/spatial-index"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	scottishCodePoint = spatialindex.CodePoint{
+		PostCode: "AB10 1AB", Easting: 100, Northing: 100,
+		Attributes: spatialindex.Attributes{CountryCode: "S92000003", AdminDistrictCode: "S12000033"},
+	}
+	englishCodePoint = spatialindex.CodePoint{
+		PostCode: "TR26 1AB", Easting: 200, Northing: 200,
+		Attributes: spatialindex.Attributes{CountryCode: "E92000001", AdminDistrictCode: "E07000045"},
+	}
+)
+
+func TestParseFilter(t *testing.T) {
+	testCases := []struct {
+		query    string
+		scottish bool
+		english  bool
+	}{
+		{query: "country=S", scottish: true, english: false},
+		{query: "country=e", scottish: false, english: true},
+		{query: "country_code=E92000001", scottish: false, english: true},
+		{query: "admin_district_code=S12000033", scottish: true, english: false},
+		{query: "country=S&admin_district_code=E07", scottish: false, english: false},
+		{query: "admin_ward_code=S13", scottish: false, english: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/search?"+tc.query, nil)
+
+			filter := parseFilter(c)
+			require.NotNil(t, filter)
+			require.Equal(t, tc.scottish, filter(&scottishCodePoint))
+			require.Equal(t, tc.english, filter(&englishCodePoint))
+		})
+	}
+}
+
+func TestParseFilter_None(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/search?bbox=0,0,1,1&country=", nil)
+
+	require.Nil(t, parseFilter(c))
+}
+
+func TestCodePointSearch_Filter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/search?bbox=0,0,1000,1000&country=S", nil)
+
+	spatialIdx := &mockSpatialIndex{
+		SearchFunc: func(bounds []uint32) (*[]spatialindex.CodePoint, error) {
+			return &[]spatialindex.CodePoint{scottishCodePoint, englishCodePoint}, nil
+		},
+	}
+	handler := CodePointSearch(spatialIdx)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"country_code":"S92000003"`)
+	require.NotContains(t, w.Body.String(), "TR26 1AB")
+}
+
+func TestPolygonSearch_Filter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?bbox=0,0,1000,1000&admin_district_code=E07000045", nil)
+
+	spatialIdx := lookupIndex(scottishCodePoint, englishCodePoint)
+	spatialIdx.SearchIterFunc = func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error {
+		for _, cp := range []spatialindex.CodePoint{scottishCodePoint, englishCodePoint} {
+			point := [2]uint32{cp.Easting, cp.Northing}
+			iter(point, point, cp.PostCode)
+		}
+		return nil
+	}
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			require.Equal(t, "TR26", district)
+			fc := geojson.NewFeatureCollection()
+			feature := geojson.NewFeature(orb.Point{1, 2})
+			feature.ID = "TR26 1AB"
+			fc.Append(feature)
+			return fc, nil
+		},
+	}
+
+	handler := PolygonSearch(spatialIdx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "TR26 1AB")
+}
+
+func TestNearestCodePoints_Filter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/codepoints/nearest?easting=1&northing=2&country=S", nil)
+
+	spatialIdx := &mockSpatialIndex{
+		NearestFunc: func(easting, northing float64, k int, maxDistance float64, filter spatialindex.Filter) (*[]spatialindex.NearestCodePoint, error) {
+			require.NotNil(t, filter)
+			require.True(t, filter(&scottishCodePoint))
+			require.False(t, filter(&englishCodePoint))
+			return &[]spatialindex.NearestCodePoint{{CodePoint: scottishCodePoint}}, nil
+		},
+	}
+	handler := NearestCodePoints(spatialIdx)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+}
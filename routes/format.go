@@ -0,0 +1,136 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"postcode-polygons/formats"
+	spatialindex "postcode-polygons/spatial-index"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// parseFormat picks which of the offered formats to respond with, from the
+// format parameter if given, or otherwise the Accept header. It returns nil
+// if the handler's default response (of defaultMimeType) should be used.
+func parseFormat(c *gin.Context, defaultMimeType string, offered []*formats.Format) (*formats.Format, error) {
+	c.Header("Vary", "Accept")
+
+	if name := c.Query("format"); name != "" {
+		for _, format := range offered {
+			if strings.EqualFold(format.Name, name) {
+				return format, nil
+			}
+		}
+
+		names := make([]string, len(offered))
+		for i, format := range offered {
+			names[i] = format.Name
+		}
+		return nil, fmt.Errorf("unsupported format '%s', must be one of %s or %s",
+			name, strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+	}
+
+	mimeTypes := []string{defaultMimeType}
+	for _, format := range offered {
+		mimeTypes = append(mimeTypes, format.MimeType)
+	}
+	negotiated := c.NegotiateFormat(mimeTypes...)
+	if negotiated == defaultMimeType {
+		return nil, nil
+	}
+	for _, format := range offered {
+		if format.MimeType == negotiated {
+			return format, nil
+		}
+	}
+	return nil, nil
+}
+
+// writeFeatures responds with the features in the given format, as a
+// collection with the given name. Formats with a file extension are sent as
+// downloads.
+func writeFeatures(c *gin.Context, format *formats.Format, name string, fc *geojson.FeatureCollection) {
+	data, err := format.Encode(name, fc)
+	if err != nil {
+		log.Printf("error while encoding %s as %s: %v", name, format.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
+		return
+	}
+
+	if format.Extension != "" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="postcodes-%s.%s"`, name, format.Extension))
+	}
+	c.Data(http.StatusOK, format.MimeType, data)
+}
+
+// streamFeatures responds with features as find passes them to write, in a
+// format that can be written a feature at a time. Each batch is flushed to the
+// client straight away, through the compressor if the response is compressed. Once the response has started, errors can no longer be
+// reported to the client, so the response is cut short instead.
+func streamFeatures(c *gin.Context, format *formats.Format, name string, find func(write func(features []*geojson.Feature) error) error) {
+	var stream *streamWriter
+	var writer formats.FeatureWriter
+	start := func() {
+		c.Header("Content-Type", format.MimeType)
+		c.Status(http.StatusOK)
+		stream = newStreamWriter(c)
+		writer = format.Stream(stream, name)
+	}
+
+	err := find(func(features []*geojson.Feature) error {
+		if len(features) == 0 {
+			return nil
+		}
+		if writer == nil {
+			start()
+		}
+		if err := writer.Write(features...); err != nil {
+			return err
+		}
+		return stream.Flush()
+	})
+	if err != nil {
+		log.Printf("error while streaming %s as %s: %v", name, format.Name, err)
+		if writer == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
+		}
+		return
+	}
+
+	if writer == nil {
+		start()
+	}
+	if err := writer.Close(); err != nil {
+		log.Printf("error while streaming %s as %s: %v", name, format.Name, err)
+		return
+	}
+	if err := stream.Close(); err != nil {
+		log.Printf("error while streaming %s as %s: %v", name, format.Name, err)
+	}
+}
+
+// codePointFeatures converts codepoints to point features, identified by
+// their postcodes, with the rest of their fields (as in the default JSON
+// response) as properties.
+func codePointFeatures(codePoints []spatialindex.CodePoint) (*geojson.FeatureCollection, error) {
+	fc := geojson.NewFeatureCollection()
+	for _, cp := range codePoints {
+		data, err := json.Marshal(cp)
+		if err != nil {
+			return nil, err
+		}
+		feature := geojson.NewFeature(orb.Point{cp.Lon, cp.Lat})
+		if err := json.Unmarshal(data, &feature.Properties); err != nil {
+			return nil, err
+		}
+		delete(feature.Properties, "post_code")
+		feature.ID = cp.PostCode
+		fc.Append(feature)
+	}
+	return fc, nil
+}
@@ -0,0 +1,201 @@
+package routes
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"postcode-polygons/formats"
+	spatialindex "postcode-polygons/spatial-index"
+	"testing"
+	"time"
+
+	"github.com/aurowora/compress"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFormat(t *testing.T) {
+	testCases := []struct {
+		query  string
+		accept string
+		format string // Empty for the default
+	}{
+		{query: "", accept: "", format: ""},
+		{query: "", accept: "*/*", format: ""},
+		{query: "", accept: "application/json", format: ""},
+		{query: "", accept: "application/topo+json", format: "topojson"},
+		{query: "", accept: "application/json, application/topo+json", format: ""},
+		{query: "", accept: "application/xml, application/topo+json", format: "topojson"},
+		{query: "format=TopoJSON", accept: "", format: "topojson"},
+		{query: "format=geojson", accept: "application/topo+json", format: "geojson"},
+		{query: "", accept: "application/flatgeobuf", format: "flatgeobuf"},
+		{query: "format=geopackage", accept: "", format: "geopackage"},
+		{query: "", accept: "text/csv", format: "csv"},
+		{query: "format=kml", accept: "", format: "kml"},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tc := range testCases {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/polygon?"+tc.query, nil)
+		if tc.accept != "" {
+			c.Request.Header.Set("Accept", tc.accept)
+		}
+
+		format, err := parseFormat(c, "application/json", formats.Registered(false))
+		require.NoError(t, err)
+		if tc.format == "" {
+			require.Nil(t, format, "query %q, accept %q", tc.query, tc.accept)
+		} else {
+			require.Equal(t, tc.format, format.Name, "query %q, accept %q", tc.query, tc.accept)
+		}
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/polygon?format=shapefile", nil)
+	_, err := parseFormat(c, "application/json", formats.Registered(false))
+	require.EqualError(t, err, "unsupported format 'shapefile', must be one of geojson, geojsonseq, topojson, flatgeobuf, geopackage, csv, wkt or kml")
+
+	// Formats that can't encode points aren't offered for them
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/codepoints?format=topojson", nil)
+	_, err = parseFormat(c, "application/json", formats.Registered(true))
+	require.EqualError(t, err, "unsupported format 'topojson', must be one of geojson, geojsonseq, csv, wkt or kml")
+}
+
+func TestCodePointFeatures(t *testing.T) {
+	cp := spatialindex.NewCodePoint("TR26 1AB", 151000, 40000)
+	cp.CountryCode = "E92000001"
+
+	fc, err := codePointFeatures([]spatialindex.CodePoint{cp})
+	require.NoError(t, err)
+	require.Len(t, fc.Features, 1)
+
+	feature := fc.Features[0]
+	require.Equal(t, "TR26 1AB", feature.ID)
+	require.Equal(t, orb.Point{cp.Lon, cp.Lat}, feature.Geometry)
+	require.Equal(t, 151000.0, feature.Properties["easting"])
+	require.Equal(t, "E92000001", feature.Properties["country_code"])
+	require.NotContains(t, feature.Properties, "post_code")
+}
+
+func TestStreamFeatures(t *testing.T) {
+	geoJSON, _ := formats.Lookup(formats.GEOJSON)
+	feature := geojson.NewFeature(square(0, 0, 1, 1))
+	feature.ID = "AB1 2CD"
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	streamFeatures(c, geoJSON, "units", func(write func(features []*geojson.Feature) error) error {
+		require.NoError(t, write([]*geojson.Feature{feature}))
+		require.NoError(t, write(nil))
+		return write([]*geojson.Feature{feature})
+	})
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, formats.GEOJSON_MIME_TYPE, w.Header().Get("Content-Type"))
+	fc, err := geojson.UnmarshalFeatureCollection(w.Body.Bytes())
+	require.NoError(t, err)
+	require.Len(t, fc.Features, 2)
+
+	// Errors before anything is written can still be reported
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	streamFeatures(c, geoJSON, "units", func(write func(features []*geojson.Feature) error) error {
+		require.NoError(t, write(nil))
+		return errors.New("failed to load polygons")
+	})
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "An internal server error occurred")
+
+	// Afterwards, the response is cut short
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	streamFeatures(c, geoJSON, "units", func(write func(features []*geojson.Feature) error) error {
+		require.NoError(t, write([]*geojson.Feature{feature}))
+		return errors.New("failed to load polygons")
+	})
+
+	require.Equal(t, http.StatusOK, w.Code)
+	_, err = geojson.UnmarshalFeatureCollection(w.Body.Bytes())
+	require.Error(t, err)
+}
+
+func TestStreamFeatures_Compressed(t *testing.T) {
+	geoJSONSeq, _ := formats.Lookup(formats.GEOJSON_SEQ)
+	feature := geojson.NewFeature(square(0, 0, 1, 1))
+	feature.ID = "AB1 2CD"
+
+	// The handler doesn't finish until the first feature has been received
+	received := make(chan struct{})
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(KeepUncompressedWriter(), compress.Compress())
+	r.GET("/stream", func(c *gin.Context) {
+		streamFeatures(c, geoJSONSeq, "units", func(write func(features []*geojson.Feature) error) error {
+			require.NoError(t, write([]*geojson.Feature{feature}))
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+			}
+			return write([]*geojson.Feature{feature})
+		})
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/stream", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := (&http.Client{Transport: &http.Transport{DisableCompression: true}}).Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	require.Equal(t, formats.GEOJSON_SEQ_MIME_TYPE, resp.Header.Get("Content-Type"))
+
+	gz, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	records := bufio.NewReader(gz)
+	first := make(chan string, 1)
+	go func() {
+		record, _ := records.ReadString('\n')
+		first <- record
+	}()
+	select {
+	case record := <-first:
+		require.Contains(t, record, "AB1 2CD")
+	case <-time.After(5 * time.Second):
+		t.Fatal("first feature wasn't received before the handler finished")
+	}
+	close(received)
+
+	record, err := records.ReadString('\n')
+	require.NoError(t, err)
+	require.Contains(t, record, "AB1 2CD")
+	_, err = records.ReadString('\n')
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	require.True(t, acceptsGzip("gzip"))
+	require.True(t, acceptsGzip("br, gzip;q=0.5"))
+	require.True(t, acceptsGzip("*"))
+	require.False(t, acceptsGzip(""))
+	require.False(t, acceptsGzip("br, zstd"))
+	require.False(t, acceptsGzip("gzip;q=0"))
+	require.False(t, acceptsGzip("gzip; q=0.000"))
+	require.True(t, acceptsGzip("*;q=0, gzip"))
+	require.False(t, acceptsGzip("*, gzip;q=0"))
+	require.False(t, acceptsGzip("gzip;q=0, *"))
+	require.False(t, acceptsGzip("*;q=0"))
+	require.True(t, acceptsGzip("GZIP;Q=0.1"))
+	require.False(t, acceptsGzip("gzip;q=nope"))
+}
@@ -0,0 +1,57 @@
+package routes
+
+import (
+	"fmt"
+	"postcode-polygons/postcode"
+	"strings"
+)
+
+// Polygon levels from the most to least detailed, each of which is served from
+// its own directory (i.e. "units", "sectors", ...)
+var LEVELS = []string{"unit", "sector", "district", "area"}
+
+// Widest bbox (in meters) that each level is automatically chosen for. Beyond
+// the last of these, areas are used.
+var LEVEL_MAX_BOUNDS = []uint32{MAX_BOUNDS, 20_000, 100_000}
+
+func parseLevel(level string) (string, error) {
+	for _, l := range LEVELS {
+		if strings.EqualFold(level, l) {
+			return l + "s", nil
+		}
+	}
+	return "", fmt.Errorf("level must be one of %s", strings.Join(LEVELS, ", "))
+}
+
+// targetForBounds picks the most detailed level that will have a reasonable
+// number of polygons in the bbox.
+func targetForBounds(bbox []uint32) string {
+	width := max(bbox[2]-bbox[0], bbox[3]-bbox[1])
+	for i, limit := range LEVEL_MAX_BOUNDS {
+		if width <= limit {
+			return LEVELS[i] + "s"
+		}
+	}
+	return LEVELS[len(LEVELS)-1] + "s"
+}
+
+// polygonKeys returns the file containing the postcode's polygon at the given
+// level, and the ID of the polygon's feature within that file. It returns false
+// if the postcode isn't valid, as it then can't have a polygon.
+func polygonKeys(target string, pc string) (string, string, bool) {
+	parsed, err := postcode.Parse(pc)
+	if err != nil {
+		return "", "", false
+	}
+
+	switch target {
+	case "units":
+		return parsed.District(), parsed.Unit(), true
+	case "sectors":
+		return parsed.District(), parsed.Sector(), true
+	case "areas":
+		return parsed.Area(), parsed.Area(), true
+	default:
+		return parsed.District(), parsed.District(), true
+	}
+}
@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevel(t *testing.T) {
+	for level, target := range map[string]string{"unit": "units", "Sector": "sectors", "district": "districts", "AREA": "areas"} {
+		parsed, err := parseLevel(level)
+		require.NoError(t, err)
+		require.Equal(t, target, parsed)
+	}
+
+	_, err := parseLevel("units")
+	require.Error(t, err)
+}
+
+func TestTargetForBounds(t *testing.T) {
+	require.Equal(t, "units", targetForBounds([]uint32{0, 0, 5000, 5000}))
+	require.Equal(t, "sectors", targetForBounds([]uint32{0, 0, 5001, 10}))
+	require.Equal(t, "sectors", targetForBounds([]uint32{0, 0, 10, 20000}))
+	require.Equal(t, "districts", targetForBounds([]uint32{0, 0, 20001, 20000}))
+	require.Equal(t, "districts", targetForBounds([]uint32{0, 0, 100000, 100000}))
+	require.Equal(t, "areas", targetForBounds([]uint32{0, 0, 700000, 1300000}))
+}
+
+func TestPolygonKeys(t *testing.T) {
+	testCases := []struct {
+		target string
+		file   string
+		id     string
+	}{
+		{target: "units", file: "TR26", id: "TR26 1AB"},
+		{target: "sectors", file: "TR26", id: "TR26 1"},
+		{target: "districts", file: "TR26", id: "TR26"},
+		{target: "areas", file: "TR", id: "TR"},
+	}
+
+	for _, tc := range testCases {
+		file, id, ok := polygonKeys(tc.target, "tr261ab")
+		require.True(t, ok, tc.target)
+		require.Equal(t, tc.file, file, tc.target)
+		require.Equal(t, tc.id, id, tc.target)
+	}
+}
+
+func TestPolygonKeys_Invalid(t *testing.T) {
+	_, _, ok := polygonKeys("units", "NOT A POSTCODE")
+	require.False(t, ok)
+}
@@ -0,0 +1,54 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	spatialindex "postcode-polygons/spatial-index"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const DEFAULT_NEIGHBOURS = 10
+const MAX_NEIGHBOURS = 100
+
+type NearestResponse struct {
+	Results     []spatialindex.NearestCodePoint `json:"results"`
+	Attribution []string                        `json:"attribution"`
+}
+
+func NearestCodePoints(idx spatialindex.SpatialIndex) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		idx := spatialindex.Current(idx)
+		easting, northing, _, err := parseLocation(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		k, err := strconv.Atoi(c.DefaultQuery("k", strconv.Itoa(DEFAULT_NEIGHBOURS)))
+		if err != nil || k < 1 || k > MAX_NEIGHBOURS {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("k must be a number between 1 and %d", MAX_NEIGHBOURS)})
+			return
+		}
+
+		maxDistance, err := strconv.ParseFloat(c.DefaultQuery("max_distance", "0"), 64)
+		if err != nil || maxDistance < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid max_distance value '%s'", c.Query("max_distance"))})
+			return
+		}
+
+		results, err := idx.Nearest(easting, northing, k, maxDistance, parseFilter(c))
+		if err != nil {
+			log.Printf("error while fetching postcode data: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
+			return
+		}
+
+		c.JSON(http.StatusOK, NearestResponse{
+			Results:     *results,
+			Attribution: ATTRIBUTION,
+		})
+	}
+}
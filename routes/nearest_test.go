@@ -0,0 +1,86 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	spatialindex "postcode-polygons/spatial-index"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNearestCodePoints_BadParams(t *testing.T) {
+	testCases := []struct {
+		name        string
+		query       string
+		errContains string
+	}{
+		{name: "missing location", query: "k=5", errContains: "either lat and lon, or easting and northing must be provided"},
+		{name: "k too small", query: "easting=1&northing=2&k=0", errContains: "k must be a number between 1 and 100"},
+		{name: "k too large", query: "easting=1&northing=2&k=101", errContains: "k must be a number between 1 and 100"},
+		{name: "k not a number", query: "easting=1&northing=2&k=abc", errContains: "k must be a number between 1 and 100"},
+		{name: "bad max distance", query: "easting=1&northing=2&max_distance=-5", errContains: "invalid max_distance value '-5'"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/v1/postcode/codepoints/nearest?"+tc.query, nil)
+
+			handler := NearestCodePoints(&mockSpatialIndex{})
+			handler(c)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Contains(t, w.Body.String(), tc.errContains)
+		})
+	}
+}
+
+func TestNearestCodePoints_InternalError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/codepoints/nearest?easting=1&northing=2", nil)
+
+	spatialIdx := &mockSpatialIndex{
+		NearestFunc: func(easting, northing float64, k int, maxDistance float64, filter spatialindex.Filter) (*[]spatialindex.NearestCodePoint, error) {
+			return nil, errors.New("fail")
+		},
+	}
+	handler := NearestCodePoints(spatialIdx)
+	handler(c)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "An internal server error occurred")
+}
+
+func TestNearestCodePoints_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/codepoints/nearest?easting=1&northing=2&k=2&max_distance=500", nil)
+
+	spatialIdx := &mockSpatialIndex{
+		NearestFunc: func(easting, northing float64, k int, maxDistance float64, filter spatialindex.Filter) (*[]spatialindex.NearestCodePoint, error) {
+			require.Equal(t, 1.0, easting)
+			require.Equal(t, 2.0, northing)
+			require.Equal(t, 2, k)
+			require.Equal(t, 500.0, maxDistance)
+			results := []spatialindex.NearestCodePoint{
+				{CodePoint: spatialindex.CodePoint{PostCode: "AB1 2CD", Easting: 1, Northing: 2}, Distance: 0},
+				{CodePoint: spatialindex.CodePoint{PostCode: "AB1 2CE", Easting: 4, Northing: 6}, Distance: 5},
+			}
+			return &results, nil
+		},
+	}
+	handler := NearestCodePoints(spatialIdx)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `{"post_code":"AB1 2CD","easting":1,"northing":2,"lat":0,"lon":0,"positional_quality":0,"country_code":"","nhs_regional_ha_code":"","nhs_ha_code":"","admin_county_code":"","admin_district_code":"","admin_ward_code":"","distance":0}`)
+	require.Contains(t, w.Body.String(), `{"post_code":"AB1 2CE","easting":4,"northing":6,"lat":0,"lon":0,"positional_quality":0,"country_code":"","nhs_regional_ha_code":"","nhs_ha_code":"","admin_county_code":"","admin_district_code":"","admin_ward_code":"","distance":5}`)
+}
@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"postcode-polygons/internal"
+	"postcode-polygons/postcode"
+	spatialindex "postcode-polygons/spatial-index"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb/geojson"
+)
+
+type PostcodeResponse struct {
+	CodePoint   spatialindex.CodePoint `json:"codepoint"`
+	Feature     *geojson.Feature       `json:"feature"`
+	Attribution []string               `json:"attribution"`
+}
+
+func PostcodeLookup(idx spatialindex.SpatialIndex, repo internal.PolygonsRepo) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		idx := spatialindex.Current(idx)
+		parsed, err := postcode.Parse(c.Param("postcode"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		codePoint, found := idx.Lookup(parsed.String())
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("postcode '%s' not found", parsed)})
+			return
+		}
+
+		district := parsed.District()
+		feature, err := repo.RetrieveFeature("units", district, parsed.Unit())
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("error loading feature %s for district %s: %v", parsed, district, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
+			return
+		}
+
+		c.JSON(http.StatusOK, PostcodeResponse{
+			CodePoint:   *codePoint,
+			Feature:     feature,
+			Attribution: ATTRIBUTION,
+		})
+	}
+}
+
+// samePostcode compares two postcodes regardless of their case and spacing.
+// Invalid postcodes never match.
+func samePostcode(a, b string) bool {
+	parsedA, err := postcode.Parse(a)
+	if err != nil {
+		return false
+	}
+	parsedB, err := postcode.Parse(b)
+	return err == nil && parsedA == parsedB
+}
@@ -0,0 +1,130 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	spatialindex "postcode-polygons/spatial-index"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+func lookupIndex(codePoints ...spatialindex.CodePoint) *mockSpatialIndex {
+	return &mockSpatialIndex{
+		LookupFunc: func(postcode string) (*spatialindex.CodePoint, bool) {
+			for _, cp := range codePoints {
+				if samePostcode(cp.PostCode, postcode) {
+					return &cp, true
+				}
+			}
+			return nil, false
+		},
+	}
+}
+
+func TestPostcodeLookup_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/ZZ99%209ZZ", nil)
+	c.Params = gin.Params{{Key: "postcode", Value: "ZZ99 9ZZ"}}
+
+	handler := PostcodeLookup(lookupIndex(), &mockPolygonsRepo{})
+	handler(c)
+
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Contains(t, w.Body.String(), "postcode 'ZZ99 9ZZ' not found")
+}
+
+func TestPostcodeLookup_Invalid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/ABC", nil)
+	c.Params = gin.Params{{Key: "postcode", Value: "ABC"}}
+
+	handler := PostcodeLookup(lookupIndex(), &mockPolygonsRepo{})
+	handler(c)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "'ABC' is not a valid postcode")
+}
+
+func TestPostcodeLookup_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/ab1-2cd", nil)
+	c.Params = gin.Params{{Key: "postcode", Value: "ab1-2cd"}}
+
+	idx := lookupIndex(spatialindex.CodePoint{PostCode: "AB1 2CD", Easting: 1, Northing: 2})
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			require.Equal(t, "units", target)
+			require.Equal(t, "AB1", district)
+			fc := geojson.NewFeatureCollection()
+			for _, id := range []string{"AB1 2CC", "AB1 2CD"} {
+				feature := geojson.NewFeature(orb.Point{1, 2})
+				feature.ID = id
+				fc.Append(feature)
+			}
+			return fc, nil
+		},
+	}
+
+	handler := PostcodeLookup(idx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"post_code":"AB1 2CD"`)
+	require.Contains(t, w.Body.String(), `"id":"AB1 2CD"`)
+	require.NotContains(t, w.Body.String(), "AB1 2CC")
+}
+
+func TestPostcodeLookup_PolygonNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/AB1%202CD", nil)
+	c.Params = gin.Params{{Key: "postcode", Value: "AB1 2CD"}}
+
+	idx := lookupIndex(spatialindex.CodePoint{PostCode: "AB1 2CD", Easting: 1, Northing: 2})
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			return nil, os.ErrNotExist
+		},
+	}
+
+	handler := PostcodeLookup(idx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"post_code":"AB1 2CD"`)
+	require.Contains(t, w.Body.String(), `"feature":null`)
+}
+
+func TestPostcodeLookup_PolygonRepoError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/AB1%202CD", nil)
+	c.Params = gin.Params{{Key: "postcode", Value: "AB1 2CD"}}
+
+	idx := lookupIndex(spatialindex.CodePoint{PostCode: "AB1 2CD", Easting: 1, Northing: 2})
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			return nil, errors.New("failed to load polygon")
+		},
+	}
+
+	handler := PostcodeLookup(idx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "An internal server error occurred")
+}
@@ -0,0 +1,221 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"postcode-polygons/internal"
+	"postcode-polygons/postcode"
+	"postcode-polygons/projection"
+	spatialindex "postcode-polygons/spatial-index"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+)
+
+// Successively larger search radii (in meters) used to find candidate districts
+// around the requested location.
+var REVERSE_SEARCH_RADII = []uint32{250, 1000, MAX_BOUNDS / 2}
+
+type ReverseResponse struct {
+	Match       string                  `json:"match"` // either "polygon" or "nearest"
+	CodePoint   *spatialindex.CodePoint `json:"codepoint"`
+	Feature     *geojson.Feature        `json:"feature"`
+	Attribution []string                `json:"attribution"`
+}
+
+func ReverseGeocode(idx spatialindex.SpatialIndex, repo internal.PolygonsRepo) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		idx := spatialindex.Current(idx)
+		easting, northing, point, err := parseLocation(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		feature, err := containingUnit(idx, repo, easting, northing, point)
+		if err != nil {
+			log.Printf("error while finding polygon: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
+			return
+		}
+		if feature != nil {
+			codePoint, _ := idx.Lookup(feature.ID.(string))
+			c.JSON(http.StatusOK, ReverseResponse{
+				Match:       "polygon",
+				CodePoint:   codePoint,
+				Feature:     feature,
+				Attribution: ATTRIBUTION,
+			})
+			return
+		}
+
+		neighbours, err := idx.Nearest(easting, northing, 1, MAX_BOUNDS, nil)
+		if err != nil {
+			log.Printf("error while fetching postcode data: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
+			return
+		}
+		if len(*neighbours) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no postcodes found near the requested location"})
+			return
+		}
+
+		nearest := (*neighbours)[0].CodePoint
+		if parsed, err := postcode.Parse(nearest.PostCode); err == nil {
+			feature, err = repo.RetrieveFeature("units", parsed.District(), parsed.Unit())
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("error loading feature %s for district %s: %v", parsed, parsed.District(), err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, ReverseResponse{
+			Match:       "nearest",
+			CodePoint:   &nearest,
+			Feature:     feature,
+			Attribution: ATTRIBUTION,
+		})
+	}
+}
+
+// containingUnit finds the unit polygon containing the point, if any. Repos
+// that can find polygons by their bounds are asked for the polygons around the
+// point directly. Otherwise, the districts of the codepoints around it are
+// searched, starting with the district of the nearest codepoint.
+func containingUnit(idx spatialindex.SpatialIndex, repo internal.PolygonsRepo, easting, northing float64, point orb.Point) (*geojson.Feature, error) {
+	if bounded, ok := repo.(internal.BoundedPolygonsRepo); ok {
+		featureCollection, err := bounded.RetrieveFeaturesInBound("units", orb.Bound{Min: point, Max: point})
+		if err != nil {
+			return nil, fmt.Errorf("error loading features around %v: %w", point, err)
+		}
+		return containingFeature(featureCollection, point), nil
+	}
+
+	tested := make(map[string]struct{}, 20)
+	for _, radius := range REVERSE_SEARCH_RADII {
+		// The distance (squared) to the nearest codepoint of each district
+		distances := make(map[string]float64, 20)
+		err := idx.SearchIter(boundsAround(easting, northing, radius), func(min, max [2]uint32, pc string) bool {
+			parsed, err := postcode.Parse(pc)
+			if err != nil {
+				return true
+			}
+			district := parsed.District()
+			if _, done := tested[district]; done {
+				return true
+			}
+			dx, dy := float64(min[0])-easting, float64(min[1])-northing
+			if distance, found := distances[district]; !found || dx*dx+dy*dy < distance {
+				distances[district] = dx*dx + dy*dy
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error while fetching postcode data: %w", err)
+		}
+
+		districts := make([]string, 0, len(distances))
+		for district := range distances {
+			districts = append(districts, district)
+		}
+		sort.Slice(districts, func(i, j int) bool {
+			if distances[districts[i]] != distances[districts[j]] {
+				return distances[districts[i]] < distances[districts[j]]
+			}
+			return districts[i] < districts[j]
+		})
+
+		for _, district := range districts {
+			tested[district] = struct{}{}
+			featureCollection, err := repo.RetrieveFeatureCollection("units", district)
+			if err != nil && os.IsNotExist(err) {
+				log.Printf("polygon file for district %s does not exist, skipping", district)
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error loading feature collection for district %s: %w", district, err)
+			}
+
+			if feature := containingFeature(featureCollection, point); feature != nil {
+				return feature, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// parseLocation accepts either a WGS84 lat/lon or a BNG easting/northing, and
+// returns the location in both coordinate systems.
+func parseLocation(c *gin.Context) (float64, float64, orb.Point, error) {
+	if c.Query("lat") != "" || c.Query("lon") != "" {
+		lat, ok := parseFinite(c.Query("lat"))
+		if !ok || lat < -90 || lat > 90 {
+			return 0, 0, orb.Point{}, fmt.Errorf("invalid lat value '%s'", c.Query("lat"))
+		}
+		lon, ok := parseFinite(c.Query("lon"))
+		if !ok || lon < -180 || lon > 180 {
+			return 0, 0, orb.Point{}, fmt.Errorf("invalid lon value '%s'", c.Query("lon"))
+		}
+		point := orb.Point{lon, lat}
+		easting, northing := projection.ToBNG(point)
+		return easting, northing, point, nil
+	}
+
+	if c.Query("easting") != "" || c.Query("northing") != "" {
+		easting, ok := parseFinite(c.Query("easting"))
+		if !ok || easting < 0 {
+			return 0, 0, orb.Point{}, fmt.Errorf("invalid easting value '%s'", c.Query("easting"))
+		}
+		northing, ok := parseFinite(c.Query("northing"))
+		if !ok || northing < 0 {
+			return 0, 0, orb.Point{}, fmt.Errorf("invalid northing value '%s'", c.Query("northing"))
+		}
+		return easting, northing, projection.ToWGS84(easting, northing), nil
+	}
+
+	return 0, 0, orb.Point{}, fmt.Errorf("either lat and lon, or easting and northing must be provided")
+}
+
+// parseFinite parses a number, rejecting NaN (which would pass any range check)
+// and infinities.
+func parseFinite(value string) (float64, bool) {
+	f, err := strconv.ParseFloat(value, 64)
+	return f, err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+func boundsAround(easting, northing float64, radius uint32) []uint32 {
+	r := float64(radius)
+	return []uint32{
+		uint32(math.Max(easting-r, 0)),
+		uint32(math.Max(northing-r, 0)),
+		uint32(easting + r),
+		uint32(northing + r),
+	}
+}
+
+func containingFeature(fc *geojson.FeatureCollection, point orb.Point) *geojson.Feature {
+	for _, feature := range fc.Features {
+		if feature.Geometry == nil || !feature.Geometry.Bound().Contains(point) {
+			continue
+		}
+		switch geom := feature.Geometry.(type) {
+		case orb.Polygon:
+			if planar.PolygonContains(geom, point) {
+				return feature
+			}
+		case orb.MultiPolygon:
+			if planar.MultiPolygonContains(geom, point) {
+				return feature
+			}
+		}
+	}
+	return nil
+}
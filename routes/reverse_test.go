@@ -0,0 +1,308 @@
+package routes
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"postcode-polygons/projection"
+	spatialindex "postcode-polygons/spatial-index"
+	"sort"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+func reverseIndex(codePoints ...spatialindex.CodePoint) *mockSpatialIndex {
+	idx := lookupIndex(codePoints...)
+	idx.SearchIterFunc = func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error {
+		for _, cp := range codePoints {
+			if cp.Easting >= bounds[0] && cp.Easting <= bounds[2] && cp.Northing >= bounds[1] && cp.Northing <= bounds[3] {
+				point := [2]uint32{cp.Easting, cp.Northing}
+				if !iter(point, point, cp.PostCode) {
+					break
+				}
+			}
+		}
+		return nil
+	}
+	idx.NearestFunc = func(easting, northing float64, k int, maxDistance float64, filter spatialindex.Filter) (*[]spatialindex.NearestCodePoint, error) {
+		results := make([]spatialindex.NearestCodePoint, 0, k)
+		for _, cp := range codePoints {
+			d := math.Hypot(float64(cp.Easting)-easting, float64(cp.Northing)-northing)
+			if d <= maxDistance {
+				results = append(results, spatialindex.NearestCodePoint{CodePoint: cp, Distance: d})
+			}
+		}
+		sort.Slice(results, func(i, j int) bool { return results[i].Distance < results[j].Distance })
+		results = results[:min(k, len(results))]
+		return &results, nil
+	}
+	return idx
+}
+
+// squareAround builds a unit feature covering roughly 200m either side of
+// the given easting/northing.
+func squareAround(postcode string, easting, northing float64) *geojson.Feature {
+	min := projection.ToWGS84(easting-200, northing-200)
+	max := projection.ToWGS84(easting+200, northing+200)
+	feature := geojson.NewFeature(orb.Bound{Min: min, Max: max}.ToPolygon())
+	feature.ID = postcode
+	return feature
+}
+
+func TestReverseGeocode_BadLocation(t *testing.T) {
+	testCases := []struct {
+		name        string
+		query       string
+		errContains string
+	}{
+		{name: "missing", query: "", errContains: "either lat and lon, or easting and northing must be provided"},
+		{name: "bad lat", query: "lat=abc&lon=1", errContains: "invalid lat value 'abc'"},
+		{name: "missing lon", query: "lat=51.5", errContains: "invalid lon value ''"},
+		{name: "negative easting", query: "easting=-1&northing=1", errContains: "invalid easting value '-1'"},
+		{name: "NaN lat and lon", query: "lat=NaN&lon=NaN", errContains: "invalid lat value 'NaN'"},
+		{name: "NaN easting", query: "easting=NaN&northing=1", errContains: "invalid easting value 'NaN'"},
+		{name: "infinite northing", query: "easting=1&northing=Inf", errContains: "invalid northing value 'Inf'"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/v1/postcode/reverse?"+tc.query, nil)
+
+			handler := ReverseGeocode(&mockSpatialIndex{}, &mockPolygonsRepo{})
+			handler(c)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Contains(t, w.Body.String(), tc.errContains)
+		})
+	}
+}
+
+func TestReverseGeocode_PolygonMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/reverse?easting=530150&northing=180000", nil)
+
+	// The nearest codepoint is AB1 2CC, but the location is inside AB1 2CD's polygon
+	idx := reverseIndex(
+		spatialindex.CodePoint{PostCode: "AB1 2CC", Easting: 530200, Northing: 180000},
+		spatialindex.CodePoint{PostCode: "AB1 2CD", Easting: 530000, Northing: 180000},
+	)
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			require.Equal(t, "units", target)
+			require.Equal(t, "AB1", district)
+			fc := geojson.NewFeatureCollection()
+			fc.Append(squareAround("AB1 2CC", 530500, 180000))
+			fc.Append(squareAround("AB1 2CD", 530000, 180000))
+			return fc, nil
+		},
+	}
+
+	handler := ReverseGeocode(idx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"match":"polygon"`)
+	require.Contains(t, w.Body.String(), `"post_code":"AB1 2CD"`)
+	require.Contains(t, w.Body.String(), `"id":"AB1 2CD"`)
+}
+
+func TestReverseGeocode_NearestDistrictFirst(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Both districts have a polygon containing the location (as they might
+	// if their boundaries don't quite meet), so the nearer one wins
+	idx := reverseIndex(
+		spatialindex.CodePoint{PostCode: "AB1 2CC", Easting: 530200, Northing: 180000},
+		spatialindex.CodePoint{PostCode: "AB2 2CC", Easting: 530010, Northing: 180000},
+		spatialindex.CodePoint{PostCode: "AB3 2CC", Easting: 530100, Northing: 180000},
+	)
+	var loaded []string
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			loaded = append(loaded, district)
+			fc := geojson.NewFeatureCollection()
+			if district != "AB3" {
+				fc.Append(squareAround(district+" 2CC", 530000, 180000))
+			}
+			return fc, nil
+		},
+	}
+
+	for i := 0; i < 10; i++ {
+		loaded = nil
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/v1/postcode/reverse?easting=530000&northing=180000", nil)
+		ReverseGeocode(idx, repo)(c)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `"id":"AB2 2CC"`)
+		require.Equal(t, []string{"AB2"}, loaded)
+	}
+}
+
+func TestReverseGeocode_LatLon(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/reverse?lat=51.508&lon=-0.128", nil)
+
+	easting, northing := projection.ToBNG(orb.Point{-0.128, 51.508})
+	idx := reverseIndex(spatialindex.CodePoint{PostCode: "WC2N 5DN", Easting: uint32(easting), Northing: uint32(northing)})
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			fc := geojson.NewFeatureCollection()
+			fc.Append(squareAround("WC2N 5DN", easting, northing))
+			return fc, nil
+		},
+	}
+
+	handler := ReverseGeocode(idx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"match":"polygon"`)
+	require.Contains(t, w.Body.String(), `"post_code":"WC2N 5DN"`)
+}
+
+func TestReverseGeocode_NearestFallback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/reverse?easting=531000&northing=180000", nil)
+
+	idx := reverseIndex(
+		spatialindex.CodePoint{PostCode: "AB1 2CC", Easting: 530200, Northing: 180000},
+		spatialindex.CodePoint{PostCode: "AB1 2CD", Easting: 530000, Northing: 180000},
+	)
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			fc := geojson.NewFeatureCollection()
+			fc.Append(squareAround("AB1 2CC", 530200, 180000))
+			fc.Append(squareAround("AB1 2CD", 530000, 180000))
+			return fc, nil
+		},
+	}
+
+	handler := ReverseGeocode(idx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"match":"nearest"`)
+	require.Contains(t, w.Body.String(), `"post_code":"AB1 2CC"`)
+	require.Contains(t, w.Body.String(), `"id":"AB1 2CC"`)
+}
+
+func TestReverseGeocode_NothingNearby(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/reverse?easting=100&northing=100", nil)
+
+	handler := ReverseGeocode(reverseIndex(), &mockPolygonsRepo{})
+	handler(c)
+
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Contains(t, w.Body.String(), "no postcodes found near the requested location")
+}
+
+func TestReverseGeocode_PolygonRepoError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/reverse?easting=530000&northing=180000", nil)
+
+	idx := reverseIndex(spatialindex.CodePoint{PostCode: "AB1 2CD", Easting: 530000, Northing: 180000})
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			return nil, errors.New("failed to load polygon")
+		},
+	}
+
+	handler := ReverseGeocode(idx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "An internal server error occurred")
+}
+
+type mockBoundedPolygonsRepo struct {
+	mockPolygonsRepo
+	RetrieveFeaturesInBoundFunc func(target string, bound orb.Bound) (*geojson.FeatureCollection, error)
+}
+
+func (m *mockBoundedPolygonsRepo) RetrieveFeaturesInBound(target string, bound orb.Bound) (*geojson.FeatureCollection, error) {
+	return m.RetrieveFeaturesInBoundFunc(target, bound)
+}
+
+func TestReverseGeocode_BoundedRepo(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// The polygons are found by their bounds, without searching the districts
+	// of the codepoints around the location
+	idx := reverseIndex(
+		spatialindex.CodePoint{PostCode: "AB1 2CC", Easting: 530200, Northing: 180000},
+		spatialindex.CodePoint{PostCode: "AB1 2CD", Easting: 530000, Northing: 180000},
+	)
+	repo := &mockBoundedPolygonsRepo{
+		mockPolygonsRepo: mockPolygonsRepo{
+			RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+				require.Fail(t, "unexpected feature collection lookup")
+				return nil, nil
+			},
+			RetrieveFeatureFunc: func(target string, district string, id string) (*geojson.Feature, error) {
+				return squareAround(id, 530200, 180000), nil
+			},
+		},
+		RetrieveFeaturesInBoundFunc: func(target string, bound orb.Bound) (*geojson.FeatureCollection, error) {
+			require.Equal(t, "units", target)
+			require.Equal(t, bound.Min, bound.Max)
+			fc := geojson.NewFeatureCollection()
+			for _, feature := range []*geojson.Feature{squareAround("AB1 2CC", 530500, 180000), squareAround("AB1 2CD", 530000, 180000)} {
+				if feature.Geometry.Bound().Contains(bound.Min) {
+					fc.Append(feature)
+				}
+			}
+			return fc, nil
+		},
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/reverse?easting=530150&northing=180000", nil)
+	ReverseGeocode(idx, repo)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"match":"polygon"`)
+	require.Contains(t, w.Body.String(), `"post_code":"AB1 2CD"`)
+
+	// Falling back to the nearest codepoint's polygon if none contain it
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/reverse?easting=531000&northing=180000", nil)
+	ReverseGeocode(idx, repo)(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"match":"nearest"`)
+	require.Contains(t, w.Body.String(), `"id":"AB1 2CC"`)
+
+	repo.RetrieveFeaturesInBoundFunc = func(target string, bound orb.Bound) (*geojson.FeatureCollection, error) {
+		return nil, errors.New("failed to query polygons")
+	}
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/postcode/reverse?easting=530150&northing=180000", nil)
+	ReverseGeocode(idx, repo)(c)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"postcode-polygons/formats"
 	"postcode-polygons/internal"
 	spatialindex "postcode-polygons/spatial-index"
 	"strconv"
@@ -23,28 +24,62 @@ type SearchResponse struct {
 	Attribution []string                 `json:"attribution"`
 }
 
-const MAX_BOUNDS = 5000 // Maximum bounds in meters (5 KM)
+const MAX_BOUNDS = 5000   // Maximum bounds in meters (5 KM)
+const MAX_RADIUS = 2500   // Maximum radius in meters (2.5 KM)
+const MAX_TOLERANCE = 0.1 // Maximum simplification tolerance in degrees
+
+// searchArea is the region being queried: either a plain bounding box, or
+// the bounding box enclosing a circle when searching by radius, in which case
+// within filters out the points that fall outside the circle.
+type searchArea struct {
+	bbox   []uint32
+	within func(point [2]uint32) bool
+}
 
 func CodePointSearch(idx spatialindex.SpatialIndex) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		bbox, err := parseBBox(c.Query("bbox"))
+		idx := spatialindex.Current(idx)
+		crs, err := parseCRS(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		area, err := parseSearchArea(c, crs)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		if isTooBig(bbox) {
+		if isTooBig(area.bbox) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "bbox is too large, must be less than 5km in width and height"})
 			return
 		}
 
-		results, err := idx.Search(bbox)
+		format, err := parseFormat(c, "application/json", formats.Registered(true))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		results, err := area.search(idx, parseFilter(c))
 		if err != nil {
 			log.Printf("error while fetching postcode data: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
 			return
 		}
 
+		if format != nil {
+			fc, err := codePointFeatures(*results)
+			if err != nil {
+				log.Printf("error while converting codepoints to features: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
+				return
+			}
+			writeFeatures(c, format, "codepoints", fc)
+			return
+		}
+
 		c.JSON(http.StatusOK, SearchResponse{
 			Results:     *results,
 			Attribution: ATTRIBUTION,
@@ -54,71 +89,235 @@ func CodePointSearch(idx spatialindex.SpatialIndex) func(c *gin.Context) {
 
 func PolygonSearch(idx spatialindex.SpatialIndex, repo internal.PolygonsRepo) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		bbox, err := parseBBox(c.Query("bbox"))
+		idx := spatialindex.Current(idx)
+		crs, err := parseCRS(c)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		tooBig := isTooBig(bbox)
-		target := map[bool]string{true: "districts", false: "units"}[tooBig]
+		area, err := parseSearchArea(c, crs)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-		if target == "units" {
-			expandBounds(&bbox, UNITS_BOUNDS_EXPANSION)
+		tolerance, err := strconv.ParseFloat(c.DefaultQuery("tolerance", "0"), 64)
+		if err != nil || tolerance < 0 || tolerance > MAX_TOLERANCE {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("tolerance must be a number between 0 and %g", MAX_TOLERANCE)})
+			return
+		}
+
+		clipToBBox, err := strconv.ParseBool(c.DefaultQuery("clip", "false"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid clip value '%s'", c.Query("clip"))})
+			return
 		}
 
-		requested := make(map[string]struct{}, 100)
-		districts := make(map[string]struct{}, 20)
+		format, err := parseFormat(c, formats.GEOJSON_MIME_TYPE, formats.Registered(false))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if format == nil {
+			format, _ = formats.Lookup(formats.GEOJSON)
+		}
 
-		err = idx.SearchIter(bbox, func(min, max [2]uint32, postcode string) bool {
-			district := strings.Split(postcode, " ")[0] // Take the first part of the postcode
-			districts[district] = struct{}{}
-			if tooBig {
-				requested[district] = struct{}{}
-			} else {
-				requested[postcode] = struct{}{}
+		bbox := area.bbox
+		target := targetForBounds(bbox)
+		polygons := repo
+		if c.Query("zoom") != "" {
+			zoom, err := strconv.ParseUint(c.Query("zoom"), 10, 32)
+			if err != nil || zoom > MAX_TILE_ZOOM {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("zoom must be a number between 0 and %d", MAX_TILE_ZOOM)})
+				return
 			}
-			return true
-		})
+			target = targetForZoom(int(zoom))
+			if simplifiedZoom, ok := internal.SimplifiedZoom(int(zoom)); ok {
+				polygons = internal.NewSimplifiedPolygonsRepo(repo, simplifiedZoom)
+			}
+		}
+		if c.Query("level") != "" {
+			target, err = parseLevel(c.Query("level"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		// Clip to the requested area, not the expanded one searched below
+		clipBound := bngToBound(area.bbox)
+		if target == "units" && area.within == nil {
+			expandBounds(&bbox, UNITS_BOUNDS_EXPANSION)
+		}
+
+		filter := parseFilter(c)
+
+		// Simplification needs all the polygons together, but otherwise they
+		// can be sent as each file is loaded, if the format allows
+		if format.Stream != nil && tolerance == 0 {
+			streamFeatures(c, format, target, func(write func(features []*geojson.Feature) error) error {
+				return visitFeatures(idx, polygons, target, bbox, area.within, filter, func(features []*geojson.Feature) error {
+					if clipToBBox {
+						features = clipFeatures(features, clipBound)
+					}
+					return write(features)
+				})
+			})
+			return
+		}
+
+		fc, err := collectFeatures(idx, polygons, target, bbox, area.within, filter)
 		if err != nil {
-			log.Printf("error while fetching postcode data: %v", err)
+			log.Printf("error while collecting polygons: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
 			return
 		}
+		if tolerance > 0 {
+			fc = internal.SimplifyFeatureCollection(fc, tolerance)
+		}
+		if clipToBBox {
+			fc.Features = clipFeatures(fc.Features, clipBound)
+		}
 
-		fc := geojson.NewFeatureCollection()
-		fc.Features = make([]*geojson.Feature, 0, len(requested))
+		writeFeatures(c, format, target, fc)
+	}
+}
+
+// collectFeatures gathers the polygons at the target level for codepoints inside
+// the bbox (optionally further restricted by within and/or filter).
+func collectFeatures(idx spatialindex.SpatialIndex, repo internal.PolygonsRepo, target string, bbox []uint32, within func(point [2]uint32) bool, filter spatialindex.Filter) (*geojson.FeatureCollection, error) {
+	fc := geojson.NewFeatureCollection()
+	err := visitFeatures(idx, repo, target, bbox, within, filter, func(features []*geojson.Feature) error {
+		fc.Features = append(fc.Features, features...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
 
-		for district := range districts {
+// visitFeatures finds the same polygons as collectFeatures, but passes them to
+// visit as each file of polygons is loaded, stopping at the first error.
+func visitFeatures(idx spatialindex.SpatialIndex, repo internal.PolygonsRepo, target string, bbox []uint32, within func(point [2]uint32) bool, filter spatialindex.Filter, visit func(features []*geojson.Feature) error) error {
+	requested := make(map[string]struct{}, 100)
+	files := make(map[string]struct{}, 20)
 
-			featureCollection, err := repo.RetrieveFeatureCollection(target, district)
-			if err != nil && os.IsNotExist(err) {
-				log.Printf("polygon file for district %s does not exist, skipping", district)
-				continue
+	err := idx.SearchIter(bbox, func(min, max [2]uint32, postcode string) bool {
+		if within != nil && !within(min) {
+			return true
+		}
+		if filter != nil {
+			if cp, found := idx.Lookup(postcode); !found || !filter(cp) {
+				return true
 			}
-			if err != nil {
-				log.Printf("error loading feature collection for district %s: %v", district, err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
-				return
+		}
+		file, id, ok := polygonKeys(target, postcode)
+		if !ok {
+			return true
+		}
+		files[file] = struct{}{}
+		requested[id] = struct{}{}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("error while fetching postcode data: %w", err)
+	}
+
+	for file := range files {
+
+		featureCollection, err := repo.RetrieveFeatureCollection(target, file)
+		if err != nil && os.IsNotExist(err) {
+			log.Printf("polygon file %s/%s does not exist, skipping", target, file)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error loading feature collection %s/%s: %w", target, file, err)
+		}
+
+		features := make([]*geojson.Feature, 0, len(featureCollection.Features))
+		for _, feature := range featureCollection.Features {
+			id, ok := feature.ID.(string)
+			if !ok {
+				continue
 			}
-			for _, feature := range featureCollection.Features {
-				if _, exists := requested[feature.ID.(string)]; exists {
-					fc.Append(feature)
-				}
+			if _, exists := requested[id]; exists {
+				features = append(features, feature)
 			}
 		}
+		if err := visit(features); err != nil {
+			return err
+		}
+	}
 
-		c.Header("Content-Type", "application/geo+json")
-		c.JSON(http.StatusOK, &fc)
+	return nil
+}
+
+// search returns the codepoints inside the area, accepted by the filter (if
+// any).
+func (area *searchArea) search(idx spatialindex.SpatialIndex, filter spatialindex.Filter) (*[]spatialindex.CodePoint, error) {
+	results, err := idx.Search(area.bbox)
+	if err != nil || results == nil || (area.within == nil && filter == nil) {
+		return results, err
+	}
+
+	matching := make([]spatialindex.CodePoint, 0, len(*results))
+	for _, cp := range *results {
+		if area.within != nil && !area.within([2]uint32{cp.Easting, cp.Northing}) {
+			continue
+		}
+		if filter != nil && !filter(&cp) {
+			continue
+		}
+		matching = append(matching, cp)
 	}
+	return &matching, nil
+}
+
+// parseSearchArea reads either a radius (with easting/northing or lat/lon
+// for its centre), or otherwise a bbox in the given CRS, from the request.
+func parseSearchArea(c *gin.Context, crs string) (*searchArea, error) {
+	if c.Query("radius") == "" {
+		parse := map[string]func(string) ([]uint32, error){CRS_BNG: parseBBox, CRS_WGS84: parseWGS84BBox}[crs]
+		bbox, err := parse(c.Query("bbox"))
+		if err != nil {
+			return nil, err
+		}
+		return &searchArea{bbox: bbox}, nil
+	}
+
+	radius, err := strconv.ParseUint(c.Query("radius"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid radius value '%s': not a valid number", c.Query("radius"))
+	}
+	if radius > MAX_RADIUS {
+		return nil, fmt.Errorf("radius is too large, must be no more than %dm", MAX_RADIUS)
+	}
+
+	easting, northing, _, err := parseLocation(c)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := float64(radius * radius)
+	return &searchArea{
+		bbox: boundsAround(easting, northing, uint32(radius)),
+		within: func(point [2]uint32) bool {
+			dx, dy := float64(point[0])-easting, float64(point[1])-northing
+			return dx*dx+dy*dy <= limit
+		},
+	}, nil
 }
 
+// expandBounds grows the bbox by extendBy on every side, stopping at 0 rather
+// than wrapping around.
 func expandBounds(bbox *[]uint32, extendBy uint32) {
 	b := *bbox
-	b[0] -= extendBy // min_easting
-	b[1] -= extendBy // min_northing
-	b[2] += extendBy // max_easting
-	b[3] += extendBy // max_northing
+	b[0] -= min(b[0], extendBy) // min_easting
+	b[1] -= min(b[1], extendBy) // min_northing
+	b[2] += extendBy            // max_easting
+	b[3] += extendBy            // max_northing
 }
 
 func parseBBox(bboxStr string) ([]uint32, error) {
@@ -1,22 +1,29 @@
 package routes
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"postcode-polygons/internal"
 	spatialindex "postcode-polygons/spatial-index"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geojson"
 	"github.com/stretchr/testify/require"
 )
 
 type mockSpatialIndex struct {
-	SearchFunc     func(bounds []uint32) (*[]spatialindex.CodePoint, error)
-	SearchIterFunc func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error
-	LenFunc        func() int
+	SearchFunc       func(bounds []uint32) (*[]spatialindex.CodePoint, error)
+	SearchIterFunc   func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error
+	LookupFunc       func(postcode string) (*spatialindex.CodePoint, bool)
+	NearestFunc      func(easting, northing float64, k int, maxDistance float64, filter spatialindex.Filter) (*[]spatialindex.NearestCodePoint, error)
+	AutocompleteFunc func(query string, limit int) (*[]spatialindex.CodePoint, error)
+	LenFunc          func() int
 }
 
 func (m *mockSpatialIndex) Search(bounds []uint32) (*[]spatialindex.CodePoint, error) {
@@ -31,6 +38,24 @@ func (m *mockSpatialIndex) SearchIter(bounds []uint32, iter func([2]uint32, [2]u
 	}
 	return nil
 }
+func (m *mockSpatialIndex) Lookup(postcode string) (*spatialindex.CodePoint, bool) {
+	if m.LookupFunc != nil {
+		return m.LookupFunc(postcode)
+	}
+	return nil, false
+}
+func (m *mockSpatialIndex) Nearest(easting, northing float64, k int, maxDistance float64, filter spatialindex.Filter) (*[]spatialindex.NearestCodePoint, error) {
+	if m.NearestFunc != nil {
+		return m.NearestFunc(easting, northing, k, maxDistance, filter)
+	}
+	return nil, nil
+}
+func (m *mockSpatialIndex) Autocomplete(query string, limit int) (*[]spatialindex.CodePoint, error) {
+	if m.AutocompleteFunc != nil {
+		return m.AutocompleteFunc(query, limit)
+	}
+	return nil, nil
+}
 func (m *mockSpatialIndex) Len() int {
 	if m.LenFunc != nil {
 		return m.LenFunc()
@@ -40,6 +65,7 @@ func (m *mockSpatialIndex) Len() int {
 
 type mockPolygonsRepo struct {
 	RetrieveFeatureCollectionFunc func(target string, district string) (*geojson.FeatureCollection, error)
+	RetrieveFeatureFunc           func(target string, district string, id string) (*geojson.Feature, error)
 }
 
 func (m *mockPolygonsRepo) RetrieveFeatureCollection(target string, district string) (*geojson.FeatureCollection, error) {
@@ -49,6 +75,19 @@ func (m *mockPolygonsRepo) RetrieveFeatureCollection(target string, district str
 	return nil, nil
 }
 
+// RetrieveFeature finds the feature in the file from RetrieveFeatureCollectionFunc,
+// unless RetrieveFeatureFunc is given
+func (m *mockPolygonsRepo) RetrieveFeature(target string, district string, id string) (*geojson.Feature, error) {
+	if m.RetrieveFeatureFunc != nil {
+		return m.RetrieveFeatureFunc(target, district, id)
+	}
+	fc, err := m.RetrieveFeatureCollection(target, district)
+	if err != nil || fc == nil {
+		return nil, err
+	}
+	return internal.FindFeature(fc, id), nil
+}
+
 func TestCodePointSearch_BadBBox(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	w := httptest.NewRecorder()
@@ -118,6 +157,86 @@ func TestCodePointSearch_Success(t *testing.T) {
 	require.Contains(t, w.Body.String(), "AB1 2CD")
 }
 
+func TestCodePointSearch_Formats(t *testing.T) {
+	spatialIdx := &mockSpatialIndex{
+		SearchFunc: func(bounds []uint32) (*[]spatialindex.CodePoint, error) {
+			results := []spatialindex.CodePoint{{PostCode: "AB1 2CD", Easting: 1, Northing: 2, Lat: 49.8, Lon: -7.5}}
+			return &results, nil
+		},
+	}
+	handler := CodePointSearch(spatialIdx)
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/search?bbox=0,0,1,1&format=csv", nil)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	require.Equal(t, `attachment; filename="postcodes-codepoints.csv"`, w.Header().Get("Content-Disposition"))
+	require.Contains(t, w.Body.String(), "AB1 2CD,,,,,1,49.8,-7.5,,,2,0,POINT(-7.5 49.8)")
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/search?bbox=0,0,1,1&format=flatgeobuf", nil)
+	handler(c)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "unsupported format 'flatgeobuf'")
+}
+
+func TestCodePointSearch_Radius(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/search?easting=1000&northing=1000&radius=100", nil)
+
+	spatialIdx := &mockSpatialIndex{
+		SearchFunc: func(bounds []uint32) (*[]spatialindex.CodePoint, error) {
+			require.Equal(t, []uint32{900, 900, 1100, 1100}, bounds)
+			results := []spatialindex.CodePoint{
+				{PostCode: "AB1 2CD", Easting: 1060, Northing: 1080}, // exactly 100m away
+				{PostCode: "AB1 2CE", Easting: 1090, Northing: 1090}, // in the bbox corner, but outside the radius
+			}
+			return &results, nil
+		},
+	}
+	handler := CodePointSearch(spatialIdx)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "AB1 2CD")
+	require.NotContains(t, w.Body.String(), "AB1 2CE")
+}
+
+func TestCodePointSearch_BadRadius(t *testing.T) {
+	testCases := []struct {
+		name        string
+		query       string
+		errContains string
+	}{
+		{name: "too big", query: "easting=1000&northing=1000&radius=2501", errContains: "radius is too large, must be no more than 2500m"},
+		{name: "not a number", query: "easting=1000&northing=1000&radius=abc", errContains: "invalid radius value 'abc'"},
+		{name: "missing centre", query: "radius=100", errContains: "either lat and lon, or easting and northing must be provided"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/search?"+tc.query, nil)
+
+			handler := CodePointSearch(&mockSpatialIndex{})
+			handler(c)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Contains(t, w.Body.String(), tc.errContains)
+		})
+	}
+}
+
 func TestPolygonSearch_BadBBox(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	w := httptest.NewRecorder()
@@ -185,6 +304,381 @@ func TestPolygonSearch_Success(t *testing.T) {
 	require.Contains(t, w.Body.String(), "AB1 2CD")
 }
 
+func TestPolygonSearch_NearOrigin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?bbox=50,0,1000,1000", nil)
+
+	spatialIdx := &mockSpatialIndex{
+		SearchIterFunc: func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error {
+			require.Equal(t, []uint32{0, 0, 1100, 1100}, bounds)
+			iter([2]uint32{0, 0}, [2]uint32{1, 1}, "AB1 2CD")
+			return nil
+		},
+	}
+
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			fc := geojson.NewFeatureCollection()
+			fc.Append(geojson.NewFeature(nil)) // Without an ID, so skipped
+			numbered := geojson.NewFeature(nil)
+			numbered.ID = 1.0
+			fc.Append(numbered)
+			feature := geojson.NewFeature(nil)
+			feature.ID = "AB1 2CD"
+			fc.Append(feature)
+			return fc, nil
+		},
+	}
+
+	handler := PolygonSearch(spatialIdx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var fc geojson.FeatureCollection
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fc))
+	require.Len(t, fc.Features, 1)
+	require.Equal(t, "AB1 2CD", fc.Features[0].ID)
+}
+
+func TestPolygonSearch_Radius(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?easting=1000&northing=1000&radius=2000", nil)
+
+	spatialIdx := &mockSpatialIndex{
+		SearchIterFunc: func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error {
+			require.Equal(t, []uint32{0, 0, 3000, 3000}, bounds)
+			iter([2]uint32{1000, 2500}, [2]uint32{1000, 2500}, "AB1 2CD")
+			iter([2]uint32{2500, 2500}, [2]uint32{2500, 2500}, "AB1 2CE")
+			return nil
+		},
+	}
+
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			require.Equal(t, "units", target)
+			fc := geojson.NewFeatureCollection()
+			for _, id := range []string{"AB1 2CD", "AB1 2CE"} {
+				feature := geojson.NewFeature(nil)
+				feature.ID = id
+				fc.Append(feature)
+			}
+			return fc, nil
+		},
+	}
+
+	handler := PolygonSearch(spatialIdx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "AB1 2CD")
+	require.NotContains(t, w.Body.String(), "AB1 2CE")
+}
+
+func TestPolygonSearch_Zoom(t *testing.T) {
+	testCases := []struct {
+		query  string
+		target string
+	}{
+		{query: "bbox=0,0,1,1&zoom=10", target: "districts-z10"}, // Precomputed simplified polygons
+		{query: "bbox=0,0,1,1&zoom=9", target: "districts-z10"},
+		{query: "bbox=0,0,10000,10000&zoom=15", target: "units"},
+		{query: "bbox=0,0,10000,10000", target: "sectors"},
+		{query: "bbox=0,0,10000,10000&level=district", target: "districts"},
+		{query: "bbox=0,0,10000,10000&zoom=15&level=AREA", target: "areas"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/polygon?"+tc.query, nil)
+
+			spatialIdx := &mockSpatialIndex{
+				SearchIterFunc: func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error {
+					iter([2]uint32{0, 0}, [2]uint32{1, 1}, "AB1 2CD")
+					return nil
+				},
+			}
+			repo := &mockPolygonsRepo{
+				RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+					require.Equal(t, tc.target, target)
+					return geojson.NewFeatureCollection(), nil
+				},
+			}
+
+			handler := PolygonSearch(spatialIdx, repo)
+			handler(c)
+
+			require.Equal(t, http.StatusOK, w.Code)
+		})
+	}
+}
+
+func TestPolygonSearch_Tolerance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?bbox=0,0,1,1&tolerance=0.5&level=district", nil)
+
+	spatialIdx := &mockSpatialIndex{
+		SearchIterFunc: func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error {
+			iter([2]uint32{0, 0}, [2]uint32{1, 1}, "AB1 2CD")
+			return nil
+		},
+	}
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			require.Equal(t, "districts", target)
+			fc := geojson.NewFeatureCollection()
+			feature := geojson.NewFeature(orb.Polygon{{{0, 0}, {1, 0}, {1, 0.01}, {2, 0}, {2, 2}, {0, 2}, {0, 0}}})
+			feature.ID = "AB1"
+			fc.Append(feature)
+			return fc, nil
+		},
+	}
+
+	handler := PolygonSearch(spatialIdx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "tolerance must be a number between 0 and 0.1")
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?bbox=0,0,1,1&tolerance=0.05&level=district", nil)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]`)
+}
+
+func TestPolygonSearch_BadLevel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?bbox=0,0,1,1&level=county", nil)
+
+	handler := PolygonSearch(&mockSpatialIndex{}, &mockPolygonsRepo{})
+	handler(c)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "level must be one of unit, sector, district, area")
+}
+
+func TestPolygonSearch_BadZoom(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?bbox=0,0,1,1&zoom=99", nil)
+
+	handler := PolygonSearch(&mockSpatialIndex{}, &mockPolygonsRepo{})
+	handler(c)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "zoom must be a number between 0 and 22")
+}
+
+func TestPolygonSearch_BadClip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?bbox=0,0,1,1&clip=maybe", nil)
+
+	handler := PolygonSearch(&mockSpatialIndex{}, &mockPolygonsRepo{})
+	handler(c)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "invalid clip value 'maybe'")
+}
+
+func TestPolygonSearch_Clip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?crs=EPSG:4326&bbox=-0.13,51.50,-0.12,51.51&level=district&clip=true", nil)
+
+	spatialIdx := &mockSpatialIndex{
+		SearchIterFunc: func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error {
+			iter([2]uint32{529500, 180000}, [2]uint32{529500, 180000}, "SW1A 1AA")
+			return nil
+		},
+	}
+
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			fc := geojson.NewFeatureCollection()
+			feature := geojson.NewFeature(square(-0.2, 51.4, -0.125, 51.6))
+			feature.ID = district
+			fc.Append(feature)
+			return fc, nil
+		},
+	}
+
+	handler := PolygonSearch(spatialIdx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	fc, err := geojson.UnmarshalFeatureCollection(w.Body.Bytes())
+	require.NoError(t, err)
+	require.Len(t, fc.Features, 1)
+	require.Equal(t, "SW1A", fc.Features[0].ID)
+
+	bound := fc.Features[0].Geometry.Bound()
+	require.InDelta(t, -0.13, bound.Min.Lon(), 0.001)
+	require.InDelta(t, -0.125, bound.Max.Lon(), 0.001)
+	require.InDelta(t, 51.50, bound.Min.Lat(), 0.001)
+	require.InDelta(t, 51.51, bound.Max.Lat(), 0.001)
+}
+
+func TestPolygonSearch_GeoJSONSeq(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?bbox=0,0,1,1&clip=true", nil)
+	c.Request.Header.Set("Accept", "application/geo+json-seq")
+
+	spatialIdx := &mockSpatialIndex{
+		SearchIterFunc: func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error {
+			iter([2]uint32{0, 0}, [2]uint32{1, 1}, "AB1 2CD")
+			iter([2]uint32{0, 0}, [2]uint32{1, 1}, "AB2 3EF")
+			return nil
+		},
+	}
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			fc := geojson.NewFeatureCollection()
+			feature := geojson.NewFeature(square(-7.56, 49.76, -7.55, 49.77))
+			feature.ID = map[string]string{"AB1": "AB1 2CD", "AB2": "AB2 3EF"}[district]
+			fc.Append(feature)
+			return fc, nil
+		},
+	}
+
+	handler := PolygonSearch(spatialIdx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/geo+json-seq", w.Header().Get("Content-Type"))
+
+	// One feature from each file, clipped to the bbox
+	records := strings.Split(strings.TrimPrefix(w.Body.String(), "\x1e"), "\x1e")
+	require.Len(t, records, 2)
+	for _, record := range records {
+		feature, err := geojson.UnmarshalFeature([]byte(record))
+		require.NoError(t, err)
+		require.Less(t, feature.Geometry.Bound().Max.Lon()-feature.Geometry.Bound().Min.Lon(), 0.001)
+	}
+}
+
+func TestPolygonSearch_BadFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?bbox=0,0,1,1&format=shapefile", nil)
+
+	handler := PolygonSearch(&mockSpatialIndex{}, &mockPolygonsRepo{})
+	handler(c)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "unsupported format 'shapefile'")
+}
+
+func TestPolygonSearch_TopoJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?bbox=0,0,1,1", nil)
+	c.Request.Header.Set("Accept", "application/topo+json")
+
+	spatialIdx := &mockSpatialIndex{
+		SearchIterFunc: func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error {
+			iter([2]uint32{0, 0}, [2]uint32{1, 1}, "AB1 2CD")
+			iter([2]uint32{0, 0}, [2]uint32{1, 1}, "AB1 2CE")
+			return nil
+		},
+	}
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			fc := geojson.NewFeatureCollection()
+			for i, id := range []string{"AB1 2CD", "AB1 2CE"} {
+				feature := geojson.NewFeature(square(float64(i), 0, float64(i+1), 1))
+				feature.ID = id
+				fc.Append(feature)
+			}
+			return fc, nil
+		},
+	}
+
+	handler := PolygonSearch(spatialIdx, repo)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/topo+json", w.Header().Get("Content-Type"))
+
+	var doc struct {
+		Type      string         `json:"type"`
+		Transform map[string]any `json:"transform"`
+		Objects   map[string]struct {
+			Geometries []struct {
+				ID string `json:"id"`
+			} `json:"geometries"`
+		} `json:"objects"`
+		Arcs [][][2]int `json:"arcs"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	require.Equal(t, "Topology", doc.Type)
+	require.NotNil(t, doc.Transform)
+	require.Len(t, doc.Objects["units"].Geometries, 2)
+
+	// The edge between the squares is only included once
+	require.Len(t, doc.Arcs, 3)
+}
+
+func TestPolygonSearch_BinaryFormats(t *testing.T) {
+	spatialIdx := &mockSpatialIndex{
+		SearchIterFunc: func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error {
+			iter([2]uint32{0, 0}, [2]uint32{1, 1}, "AB1 2CD")
+			return nil
+		},
+	}
+	repo := &mockPolygonsRepo{
+		RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+			fc := geojson.NewFeatureCollection()
+			feature := geojson.NewFeature(square(0, 0, 1, 1))
+			feature.ID = "AB1 2CD"
+			fc.Append(feature)
+			return fc, nil
+		},
+	}
+	handler := PolygonSearch(spatialIdx, repo)
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?bbox=0,0,1,1", nil)
+	c.Request.Header.Set("Accept", "application/flatgeobuf")
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/flatgeobuf", w.Header().Get("Content-Type"))
+	require.Equal(t, "fgb\x03fgb\x00", w.Body.String()[:8])
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/polygon?bbox=0,0,1,1&format=geopackage", nil)
+	handler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/geopackage+sqlite3", w.Header().Get("Content-Type"))
+	require.Equal(t, `attachment; filename="postcodes-units.gpkg"`, w.Header().Get("Content-Disposition"))
+	require.Equal(t, "SQLite format 3\x00", w.Body.String()[:16])
+}
+
 func TestPolygonSearch_PolygonNotFound(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	w := httptest.NewRecorder()
@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"postcode-polygons/internal"
+	spatialindex "postcode-polygons/spatial-index"
+	"postcode-polygons/tiles"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb/maptile"
+)
+
+const MIN_TILE_ZOOM = 8     // Below this, tiles would cover too much of the country to build on demand
+const MAX_TILE_ZOOM = 22    // Deepest zoom supported by most web mapping clients
+const UNITS_MIN_ZOOM = 13   // Roughly where a tile becomes smaller than MAX_BOUNDS across GB
+const SECTORS_MIN_ZOOM = 11 // Likewise for the widest bbox that sectors are used for
+
+func PolygonTiles(idx spatialindex.SpatialIndex, repo internal.PolygonsRepo) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		idx := spatialindex.Current(idx)
+		tile, err := parseTile(c.Param("z"), c.Param("x"), c.Param("y"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		target := targetForZoom(int(tile.Z))
+		bbox := boundToBNG(tile.Bound(float64(tiles.BUFFER) / tiles.EXTENT))
+		if target == "units" {
+			expandBounds(&bbox, UNITS_BOUNDS_EXPANSION)
+		}
+
+		fc, err := collectFeatures(idx, repo, target, bbox, nil, nil)
+		if err != nil {
+			log.Printf("error while collecting polygons for tile %v: %v", tile, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
+			return
+		}
+
+		data, err := tiles.Encode(tile, "postcodes", fc)
+		if err != nil {
+			log.Printf("error while encoding tile %v: %v", tile, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "An internal server error occurred"})
+			return
+		}
+		c.Data(http.StatusOK, tiles.MIME_TYPE, data)
+	}
+}
+
+// targetForZoom picks which level of polygons should be shown at a given web
+// map zoom level.
+func targetForZoom(zoom int) string {
+	if zoom >= UNITS_MIN_ZOOM {
+		return "units"
+	}
+	if zoom >= SECTORS_MIN_ZOOM {
+		return "sectors"
+	}
+	return "districts"
+}
+
+func parseTile(zStr, xStr, yStr string) (maptile.Tile, error) {
+	yStr, found := strings.CutSuffix(yStr, ".mvt")
+	if !found {
+		return maptile.Tile{}, fmt.Errorf("only .mvt tiles are supported")
+	}
+
+	z, err := strconv.ParseUint(zStr, 10, 32)
+	if err != nil || z < MIN_TILE_ZOOM || z > MAX_TILE_ZOOM {
+		return maptile.Tile{}, fmt.Errorf("zoom must be a number between %d and %d", MIN_TILE_ZOOM, MAX_TILE_ZOOM)
+	}
+	x, err := strconv.ParseUint(xStr, 10, 32)
+	if err != nil {
+		return maptile.Tile{}, fmt.Errorf("invalid tile x value '%s'", xStr)
+	}
+	y, err := strconv.ParseUint(yStr, 10, 32)
+	if err != nil {
+		return maptile.Tile{}, fmt.Errorf("invalid tile y value '%s'", yStr)
+	}
+
+	tile := maptile.New(uint32(x), uint32(y), maptile.Zoom(z))
+	if !tile.Valid() {
+		return maptile.Tile{}, fmt.Errorf("tile %d/%d/%d does not exist", z, x, y)
+	}
+	return tile, nil
+}
@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"postcode-polygons/tiles"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolygonTiles_BadTile(t *testing.T) {
+	testCases := []struct {
+		name        string
+		z, x, y     string
+		errContains string
+	}{
+		{name: "not mvt", z: "14", x: "8148", y: "5396.png", errContains: "only .mvt tiles are supported"},
+		{name: "zoom too low", z: "7", x: "1", y: "1.mvt", errContains: "zoom must be a number between 8 and 22"},
+		{name: "zoom too high", z: "23", x: "1", y: "1.mvt", errContains: "zoom must be a number between 8 and 22"},
+		{name: "bad x", z: "14", x: "abc", y: "5396.mvt", errContains: "invalid tile x value 'abc'"},
+		{name: "bad y", z: "14", x: "8148", y: "abc.mvt", errContains: "invalid tile y value 'abc'"},
+		{name: "out of range", z: "8", x: "256", y: "1.mvt", errContains: "tile 8/256/1 does not exist"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/v1/tiles/"+tc.z+"/"+tc.x+"/"+tc.y, nil)
+			c.Params = gin.Params{{Key: "z", Value: tc.z}, {Key: "x", Value: tc.x}, {Key: "y", Value: tc.y}}
+
+			handler := PolygonTiles(&mockSpatialIndex{}, &mockPolygonsRepo{})
+			handler(c)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Contains(t, w.Body.String(), tc.errContains)
+		})
+	}
+}
+
+func TestPolygonTiles_Success(t *testing.T) {
+	testCases := []struct {
+		z, x, y  string
+		target   string
+		expected string
+	}{
+		{z: "14", x: "8148", y: "5396.mvt", target: "units", expected: "AB1 2CD"},
+		{z: "10", x: "509", y: "337.mvt", target: "districts", expected: "AB1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.target, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/v1/tiles/"+tc.z+"/"+tc.x+"/"+tc.y, nil)
+			c.Params = gin.Params{{Key: "z", Value: tc.z}, {Key: "x", Value: tc.x}, {Key: "y", Value: tc.y}}
+
+			tile, err := parseTile(tc.z, tc.x, tc.y)
+			require.NoError(t, err)
+
+			spatialIdx := &mockSpatialIndex{
+				SearchIterFunc: func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error {
+					iter([2]uint32{0, 0}, [2]uint32{1, 1}, "AB1 2CD")
+					return nil
+				},
+			}
+			repo := &mockPolygonsRepo{
+				RetrieveFeatureCollectionFunc: func(target string, district string) (*geojson.FeatureCollection, error) {
+					require.Equal(t, tc.target, target)
+					fc := geojson.NewFeatureCollection()
+					feature := geojson.NewFeature(tile.Bound().ToPolygon())
+					feature.ID = tc.expected
+					fc.Append(feature)
+					return fc, nil
+				},
+			}
+
+			handler := PolygonTiles(spatialIdx, repo)
+			handler(c)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, tiles.MIME_TYPE, w.Header().Get("Content-Type"))
+			require.Contains(t, w.Body.String(), tc.expected)
+		})
+	}
+}
+
+func TestPolygonTiles_InternalError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/v1/tiles/14/8148/5396.mvt", nil)
+	c.Params = gin.Params{{Key: "z", Value: "14"}, {Key: "x", Value: "8148"}, {Key: "y", Value: "5396.mvt"}}
+
+	spatialIdx := &mockSpatialIndex{
+		SearchIterFunc: func(bounds []uint32, iter func([2]uint32, [2]uint32, string) bool) error {
+			return errors.New("fail")
+		},
+	}
+	handler := PolygonTiles(spatialIdx, &mockPolygonsRepo{})
+	handler(c)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestParseTile(t *testing.T) {
+	tile, err := parseTile("14", "8148", "5396.mvt")
+	require.NoError(t, err)
+	require.Equal(t, maptile.New(8148, 5396, 14), tile)
+}
+
+func TestTargetForZoom(t *testing.T) {
+	require.Equal(t, "districts", targetForZoom(MIN_TILE_ZOOM))
+	require.Equal(t, "districts", targetForZoom(SECTORS_MIN_ZOOM-1))
+	require.Equal(t, "sectors", targetForZoom(SECTORS_MIN_ZOOM))
+	require.Equal(t, "sectors", targetForZoom(UNITS_MIN_ZOOM-1))
+	require.Equal(t, "units", targetForZoom(UNITS_MIN_ZOOM))
+	require.Equal(t, "units", targetForZoom(MAX_TILE_ZOOM))
+}
@@ -0,0 +1,120 @@
+package spatialindex
+
+import (
+	"fmt"
+	"math"
+)
+
+// Attributes are the CodePoint Open fields describing a postcode's location
+// quality and the statistical/administrative areas it falls within. The codes
+// are GSS codes, e.g. a country code of "S92000003" for Scotland.
+type Attributes struct {
+	PositionalQuality uint8  `json:"positional_quality"`
+	CountryCode       string `json:"country_code"`
+	NHSRegionalHACode string `json:"nhs_regional_ha_code"`
+	NHSHACode         string `json:"nhs_ha_code"`
+	AdminCountyCode   string `json:"admin_county_code"`
+	AdminDistrictCode string `json:"admin_district_code"`
+	AdminWardCode     string `json:"admin_ward_code"`
+}
+
+// Filter decides whether a codepoint should be included in a set of results.
+// A nil filter includes everything.
+type Filter func(cp *CodePoint) bool
+
+// Positions of each code within codePointRecord.codes
+const (
+	countryCode = iota
+	nhsRegionalHACode
+	nhsHACode
+	adminCountyCode
+	adminDistrictCode
+	adminWardCode
+	numCodes
+)
+
+// codePointRecord is the compact form in which the index holds a codepoint.
+// There are only a few thousand distinct area codes shared between ~1.7
+// million postcodes, so each is stored as an index into a code table.
+type codePointRecord struct {
+	postcode          string
+	easting, northing uint32
+	quality           uint8
+	codes             [numCodes]uint16
+}
+
+// codeTable interns strings, mapping each distinct value to a small integer.
+type codeTable struct {
+	values []string
+	index  map[string]uint16
+}
+
+func newCodeTable() *codeTable {
+	return &codeTable{index: make(map[string]uint16)}
+}
+
+func (t *codeTable) intern(value string) (uint16, error) {
+	if i, ok := t.index[value]; ok {
+		return i, nil
+	}
+	if len(t.values) > math.MaxUint16 {
+		return 0, fmt.Errorf("too many distinct codes, cannot add '%s'", value)
+	}
+	i := uint16(len(t.values))
+	t.values = append(t.values, value)
+	t.index[value] = i
+	return i, nil
+}
+
+type codeTables [numCodes]*codeTable
+
+func newCodeTables() codeTables {
+	var tables codeTables
+	for i := range tables {
+		tables[i] = newCodeTable()
+	}
+	return tables
+}
+
+// compact converts a codepoint to its record form, adding any new codes to
+// the tables.
+func (tables codeTables) compact(cp *CodePoint) (codePointRecord, error) {
+	values := [numCodes]string{
+		countryCode:       cp.CountryCode,
+		nhsRegionalHACode: cp.NHSRegionalHACode,
+		nhsHACode:         cp.NHSHACode,
+		adminCountyCode:   cp.AdminCountyCode,
+		adminDistrictCode: cp.AdminDistrictCode,
+		adminWardCode:     cp.AdminWardCode,
+	}
+
+	record := codePointRecord{
+		postcode: cp.PostCode,
+		easting:  cp.Easting,
+		northing: cp.Northing,
+		quality:  cp.PositionalQuality,
+	}
+	for i, value := range values {
+		code, err := tables[i].intern(value)
+		if err != nil {
+			return codePointRecord{}, err
+		}
+		record.codes[i] = code
+	}
+	return record, nil
+}
+
+// expand converts a record back to a full codepoint.
+func (tables codeTables) expand(record *codePointRecord) CodePoint {
+	cp := NewCodePoint(record.postcode, record.easting, record.northing)
+	cp.Attributes = Attributes{
+		PositionalQuality: record.quality,
+		CountryCode:       tables[countryCode].values[record.codes[countryCode]],
+		NHSRegionalHACode: tables[nhsRegionalHACode].values[record.codes[nhsRegionalHACode]],
+		NHSHACode:         tables[nhsHACode].values[record.codes[nhsHACode]],
+		AdminCountyCode:   tables[adminCountyCode].values[record.codes[adminCountyCode]],
+		AdminDistrictCode: tables[adminDistrictCode].values[record.codes[adminDistrictCode]],
+		AdminWardCode:     tables[adminWardCode].values[record.codes[adminWardCode]],
+	}
+	return cp
+}
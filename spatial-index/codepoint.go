@@ -4,6 +4,10 @@ import (
 	"archive/zip"
 	"fmt"
 	"log"
+	"math"
+	"postcode-polygons/postcode"
+	"postcode-polygons/projection"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -11,30 +15,60 @@ import (
 )
 
 type CodePoint struct {
-	PostCode string `json:"post_code"`
-	Easting  uint32 `json:"easting"`
-	Northing uint32 `json:"northing"`
+	PostCode string  `json:"post_code"`
+	Easting  uint32  `json:"easting"`
+	Northing uint32  `json:"northing"`
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Attributes
+}
+
+// NewCodePoint creates a codepoint at the given easting/northing, filling in
+// the equivalent WGS84 latitude/longitude.
+func NewCodePoint(postcode string, easting, northing uint32) CodePoint {
+	point := projection.ToWGS84(float64(easting), float64(northing))
+	return CodePoint{
+		PostCode: postcode,
+		Easting:  easting,
+		Northing: northing,
+		Lat:      point.Lat(),
+		Lon:      point.Lon(),
+	}
+}
+
+type NearestCodePoint struct {
+	CodePoint
+	Distance float64 `json:"distance"` // in meters
 }
 
 type SpatialIndex interface {
 	Search(bounds []uint32) (*[]CodePoint, error)
 	SearchIter(bounds []uint32, iter func(min, max [2]uint32, data string) bool) error
+	Lookup(postcode string) (*CodePoint, bool)
+	Nearest(easting, northing float64, k int, maxDistance float64, filter Filter) (*[]NearestCodePoint, error)
+	Autocomplete(query string, limit int) (*[]CodePoint, error)
 	Len() int
 }
 
 type RtreeSpatialIndex struct {
-	tree *rtree.RTreeGN[uint32, string]
+	tree      *rtree.RTreeGN[uint32, string]
+	postcodes map[string]codePointRecord
+	codes     codeTables
+	sorted    []string // All postcodes in order, for prefix searches
 }
 
 func NewCodePointSpatialIndex(zipFile string) (SpatialIndex, error) {
 	idx := RtreeSpatialIndex{
-		tree: &rtree.RTreeGN[uint32, string]{},
+		tree:      &rtree.RTreeGN[uint32, string]{},
+		postcodes: make(map[string]codePointRecord),
+		codes:     newCodeTables(),
 	}
 
 	err := idx.importCodePoint(zipFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load codepoint index from zip file: %w", err)
 	}
+	idx.sortPostcodes()
 
 	return &idx, nil
 }
@@ -43,11 +77,7 @@ func (idx *RtreeSpatialIndex) Search(bounds []uint32) (*[]CodePoint, error) {
 
 	results := make([]CodePoint, 0, 100)
 	err := idx.SearchIter(bounds, func(min, max [2]uint32, data string) bool {
-		results = append(results, CodePoint{
-			PostCode: data,
-			Easting:  min[0],
-			Northing: min[1],
-		})
+		results = append(results, idx.codePoint(data))
 		return true
 	})
 
@@ -70,10 +100,105 @@ func (idx *RtreeSpatialIndex) SearchIter(bounds []uint32, iter func(min, max [2]
 	return nil
 }
 
+// Lookup finds the codepoint for an exact postcode. Matching ignores case and
+// whitespace, so "sw1a1aa" and "SW1A 1AA" both resolve to the same entry.
+func (idx *RtreeSpatialIndex) Lookup(postcode string) (*CodePoint, bool) {
+	record, ok := idx.postcodes[postcodeKey(postcode)]
+	if !ok {
+		return nil, false
+	}
+	cp := idx.codes.expand(&record)
+	return &cp, true
+}
+
+// Nearest returns up to k codepoints closest to the given easting/northing,
+// ordered by ascending distance. A maxDistance of zero means no limit, and
+// codepoints rejected by the filter (if any) do not count towards k.
+func (idx *RtreeSpatialIndex) Nearest(easting, northing float64, k int, maxDistance float64, filter Filter) (*[]NearestCodePoint, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("number of neighbours must be greater than zero")
+	}
+
+	maxDistSq := math.Inf(1)
+	if maxDistance > 0 {
+		maxDistSq = maxDistance * maxDistance
+	}
+
+	// Squared distance from the target to the nearest edge of a box (zero if inside)
+	distSq := func(min, max [2]uint32, data string, item bool) float64 {
+		dx := math.Max(0, math.Max(float64(min[0])-easting, easting-float64(max[0])))
+		dy := math.Max(0, math.Max(float64(min[1])-northing, northing-float64(max[1])))
+		return dx*dx + dy*dy
+	}
+
+	results := make([]NearestCodePoint, 0, k)
+	idx.tree.Nearby(distSq, func(min, max [2]uint32, data string, dist float64) bool {
+		if dist > maxDistSq {
+			return false
+		}
+		cp := idx.codePoint(data)
+		if filter != nil && !filter(&cp) {
+			return true
+		}
+		results = append(results, NearestCodePoint{
+			CodePoint: cp,
+			Distance:  math.Round(math.Sqrt(dist)*10) / 10,
+		})
+		return len(results) < k
+	})
+
+	return &results, nil
+}
+
+// Autocomplete returns up to limit codepoints, in postcode order, whose
+// postcodes start with the (partial) postcode given.
+func (idx *RtreeSpatialIndex) Autocomplete(query string, limit int) (*[]CodePoint, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit must be greater than zero")
+	}
+
+	// Each prefix matches a separate run of the sorted postcodes
+	matches := make([]string, 0, limit)
+	for _, prefix := range postcode.Prefixes(query) {
+		found := 0
+		for i := sort.SearchStrings(idx.sorted, prefix); i < len(idx.sorted) && found < limit; i++ {
+			if !strings.HasPrefix(idx.sorted[i], prefix) {
+				break
+			}
+			matches = append(matches, idx.sorted[i])
+			found++
+		}
+	}
+	sort.Strings(matches)
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	results := make([]CodePoint, 0, len(matches))
+	for _, match := range matches {
+		results = append(results, idx.codePoint(match))
+	}
+	return &results, nil
+}
+
 func (idx *RtreeSpatialIndex) Len() int {
 	return idx.tree.Len()
 }
 
+// codePoint expands the record for a postcode taken from the tree.
+func (idx *RtreeSpatialIndex) codePoint(postcode string) CodePoint {
+	record := idx.postcodes[postcodeKey(postcode)]
+	return idx.codes.expand(&record)
+}
+
+func (idx *RtreeSpatialIndex) sortPostcodes() {
+	idx.sorted = make([]string, 0, len(idx.postcodes))
+	for _, record := range idx.postcodes {
+		idx.sorted = append(idx.sorted, record.postcode)
+	}
+	sort.Strings(idx.sorted)
+}
+
 func (idx *RtreeSpatialIndex) importCodePoint(zipPath string) error {
 
 	r, err := zip.OpenReader(zipPath)
@@ -115,13 +240,25 @@ func (idx *RtreeSpatialIndex) processCSV(f *zip.File) error {
 			return fmt.Errorf("error parsing line %d: %w", result.LineNum, result.Error)
 		}
 
-		point := [2]uint32{result.Value.Easting, result.Value.Northing}
-		idx.tree.Insert(point, point, result.Value.PostCode)
+		record, err := idx.codes.compact(result.Value)
+		if err != nil {
+			return fmt.Errorf("error indexing line %d: %w", result.LineNum, err)
+		}
+
+		point := [2]uint32{record.easting, record.northing}
+		idx.tree.Insert(point, point, record.postcode)
+		idx.postcodes[postcodeKey(record.postcode)] = record
 	}
 
 	return nil
 }
 
+// fromCodePointCSV reads a row of the CodePoint Open CSV files, which have the
+// columns: postcode, positional quality, easting, northing, country code, NHS
+// regional HA code, NHS HA code, admin county code, admin district code and
+// admin ward code. Rows with only the first four columns are accepted without
+// any attributes. The latitude/longitude are not filled in, as the index only
+// computes them on demand.
 func fromCodePointCSV(record []string, headers []string) (*CodePoint, error) {
 
 	easting, err := strconv.ParseUint(record[2], 10, 32)
@@ -133,9 +270,41 @@ func fromCodePointCSV(record []string, headers []string) (*CodePoint, error) {
 		return nil, fmt.Errorf("invalid northing value: %w", err)
 	}
 
-	return &CodePoint{
-		PostCode: record[0],
-		Easting:  uint32(easting),
-		Northing: uint32(northing),
-	}, nil
+	// Postcodes may be padded to seven characters (e.g. "B1  1AA"), so are
+	// normalised to match the IDs of their polygons. Anything that isn't a
+	// valid postcode is kept as it is.
+	pc := record[0]
+	if normalised, err := postcode.Normalise(pc); err == nil {
+		pc = normalised
+	}
+
+	codePoint := CodePoint{PostCode: pc, Easting: uint32(easting), Northing: uint32(northing)}
+	if len(record) < 10 {
+		return &codePoint, nil
+	}
+
+	quality, err := strconv.ParseUint(record[1], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid positional quality value: %w", err)
+	}
+
+	codePoint.Attributes = Attributes{
+		PositionalQuality: uint8(quality),
+		CountryCode:       record[4],
+		NHSRegionalHACode: record[5],
+		NHSHACode:         record[6],
+		AdminCountyCode:   record[7],
+		AdminDistrictCode: record[8],
+		AdminWardCode:     record[9],
+	}
+	return &codePoint, nil
+}
+
+// postcodeKey is the form postcodes are indexed by, so that lookups ignore
+// case and spacing.
+func postcodeKey(pc string) string {
+	if parsed, err := postcode.Parse(pc); err == nil {
+		return parsed.Outward + parsed.Inward
+	}
+	return strings.ToUpper(strings.Join(strings.Fields(pc), ""))
 }
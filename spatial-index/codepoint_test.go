@@ -72,6 +72,134 @@ func TestSearchIter_InvalidBounds(t *testing.T) {
 	require.Contains(t, err.Error(), "bounds must contain exactly 4 values")
 }
 
+func TestLookup(t *testing.T) {
+	csv := "TR26 1AB,10,100,200\nTR261AD,10,300,400\n"
+	zipPath := createTestZip(t, map[string]string{"Data/CSV/test.csv": csv})
+	defer func() { _ = os.Remove(zipPath) }()
+	idx, err := NewCodePointSpatialIndex(zipPath)
+	require.NoError(t, err)
+
+	cp, ok := idx.Lookup("TR26 1AB")
+	require.True(t, ok)
+	require.Equal(t, NewCodePoint("TR26 1AB", 100, 200), *cp)
+
+	// Postcodes are normalised, and case, whitespace and hyphens are ignored
+	cp, ok = idx.Lookup(" tr26  1ad ")
+	require.True(t, ok)
+	require.Equal(t, "TR26 1AD", cp.PostCode)
+
+	cp, ok = idx.Lookup("tr26-1ad")
+	require.True(t, ok)
+	require.Equal(t, "TR26 1AD", cp.PostCode)
+
+	_, ok = idx.Lookup("TR26 9ZZ")
+	require.False(t, ok)
+}
+
+func TestAutocomplete(t *testing.T) {
+	csv := "TR26 1AB,10,1,1\nTR26 1AD,10,2,2\nTR26 2AA,10,3,3\nTR2 6AA,10,4,4\nW1 1AA,10,5,5\nW11 1AA,10,6,6\nW11 2AA,10,7,7\n"
+	zipPath := createTestZip(t, map[string]string{"Data/CSV/test.csv": csv})
+	defer func() { _ = os.Remove(zipPath) }()
+	idx, err := NewCodePointSpatialIndex(zipPath)
+	require.NoError(t, err)
+
+	postcodes := func(query string, limit int) []string {
+		res, err := idx.Autocomplete(query, limit)
+		require.NoError(t, err)
+		var postcodes []string
+		for _, cp := range *res {
+			postcodes = append(postcodes, cp.PostCode)
+		}
+		return postcodes
+	}
+
+	require.Equal(t, []string{"TR26 1AB", "TR26 1AD"}, postcodes("tr261", 10))
+	require.Equal(t, []string{"TR26 1AB", "TR26 1AD", "TR26 2AA"}, postcodes("TR26 ", 10))
+	require.Equal(t, []string{"TR2 6AA", "TR26 1AB", "TR26 1AD", "TR26 2AA"}, postcodes("TR26", 10))
+	require.Equal(t, []string{"TR2 6AA", "TR26 1AB"}, postcodes("TR26", 2))
+	require.Equal(t, []string{"W1 1AA", "W11 1AA", "W11 2AA"}, postcodes("w11", 10))
+	require.Empty(t, postcodes("SW1", 10))
+	require.Empty(t, postcodes("", 10))
+
+	// Matching codepoints have their coordinates
+	res, err := idx.Autocomplete("TR2 6AA", 10)
+	require.NoError(t, err)
+	require.Equal(t, NewCodePoint("TR2 6AA", 4, 4), (*res)[0])
+
+	_, err = idx.Autocomplete("TR26", 0)
+	require.Error(t, err)
+}
+
+func TestAttributes(t *testing.T) {
+	csv := "AB10 1AB,10,394235,806529,S92000003,,S08000020,,S12000033,S13002842\n" +
+		"TR26 1AB,10,351670,40380,E92000001,E19000001,E18000010,E10000008,E07000045,E05011936\n" +
+		"AB10 1AD,20,394200,806500,S92000003,,S08000020,,S12000033,S13002842\n"
+	zipPath := createTestZip(t, map[string]string{"Data/CSV/test.csv": csv})
+	defer func() { _ = os.Remove(zipPath) }()
+	idx, err := NewCodePointSpatialIndex(zipPath)
+	require.NoError(t, err)
+
+	cp, ok := idx.Lookup("TR26 1AB")
+	require.True(t, ok)
+	require.Equal(t, Attributes{
+		PositionalQuality: 10,
+		CountryCode:       "E92000001",
+		NHSRegionalHACode: "E19000001",
+		NHSHACode:         "E18000010",
+		AdminCountyCode:   "E10000008",
+		AdminDistrictCode: "E07000045",
+		AdminWardCode:     "E05011936",
+	}, cp.Attributes)
+
+	res, err := idx.Search([]uint32{394000, 806000, 395000, 807000})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(*res))
+	for _, cp := range *res {
+		require.Equal(t, "S12000033", cp.AdminDistrictCode)
+	}
+
+	// Codes shared between postcodes are only stored once
+	tables := idx.(*RtreeSpatialIndex).codes
+	require.Equal(t, []string{"S92000003", "E92000001"}, tables[countryCode].values)
+	require.Equal(t, []string{"", "E19000001"}, tables[nhsRegionalHACode].values)
+}
+
+func TestNearest(t *testing.T) {
+	csv := "PC1,10,100,100\nPC2,10,130,140\nPC3,10,400,100\nPC4,10,1000,1000\n"
+	zipPath := createTestZip(t, map[string]string{"Data/CSV/test.csv": csv})
+	defer func() { _ = os.Remove(zipPath) }()
+	idx, err := NewCodePointSpatialIndex(zipPath)
+	require.NoError(t, err)
+
+	res, err := idx.Nearest(100, 100, 3, 0, nil)
+	require.NoError(t, err)
+	require.Equal(t, []NearestCodePoint{
+		{CodePoint: NewCodePoint("PC1", 100, 100), Distance: 0},
+		{CodePoint: NewCodePoint("PC2", 130, 140), Distance: 50},
+		{CodePoint: NewCodePoint("PC3", 400, 100), Distance: 300},
+	}, *res)
+
+	// Limited by distance
+	res, err = idx.Nearest(100, 100, 10, 100, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(*res))
+
+	// Target between points
+	res, err = idx.Nearest(380, 100, 1, 0, nil)
+	require.NoError(t, err)
+	require.Equal(t, "PC3", (*res)[0].PostCode)
+	require.Equal(t, 20.0, (*res)[0].Distance)
+
+	// Filtered codepoints don't count towards k
+	res, err = idx.Nearest(100, 100, 1, 0, func(cp *CodePoint) bool { return cp.PostCode != "PC1" })
+	require.NoError(t, err)
+	require.Equal(t, 1, len(*res))
+	require.Equal(t, "PC2", (*res)[0].PostCode)
+
+	_, err = idx.Nearest(100, 100, 0, 0, nil)
+	require.Error(t, err)
+}
+
 func TestLen(t *testing.T) {
 	csv := "PC1,PC2,1,2\nPC2,PC3,3,4\nPC3,PC4,5,6\n"
 	zipPath := createTestZip(t, map[string]string{"Data/CSV/test.csv": csv})
@@ -81,6 +209,15 @@ func TestLen(t *testing.T) {
 	require.Equal(t, 3, idx.Len())
 }
 
+func TestNewCodePoint(t *testing.T) {
+	cp := NewCodePoint("SW1A 1AA", 529090, 179645)
+	require.Equal(t, "SW1A 1AA", cp.PostCode)
+	require.Equal(t, uint32(529090), cp.Easting)
+	require.Equal(t, uint32(179645), cp.Northing)
+	require.InDelta(t, 51.501, cp.Lat, 0.001)
+	require.InDelta(t, -0.142, cp.Lon, 0.001)
+}
+
 func Test_fromCodePointCSV(t *testing.T) {
 	rec := []string{"PC1", "PC2", "123", "456"}
 	cp, err := fromCodePointCSV(rec, nil)
@@ -88,6 +225,26 @@ func Test_fromCodePointCSV(t *testing.T) {
 	require.Equal(t, "PC1", cp.PostCode)
 	require.Equal(t, uint32(123), cp.Easting)
 	require.Equal(t, uint32(456), cp.Northing)
+	require.Equal(t, Attributes{}, cp.Attributes)
+
+	// Full CodePoint Open row
+	rec = []string{"AB101AB", "10", "394235", "806529", "S92000003", "", "S08000020", "", "S12000033", "S13002842"}
+	cp, err = fromCodePointCSV(rec, nil)
+	require.NoError(t, err)
+	require.Equal(t, "AB10 1AB", cp.PostCode)
+	require.Equal(t, Attributes{
+		PositionalQuality: 10,
+		CountryCode:       "S92000003",
+		NHSHACode:         "S08000020",
+		AdminDistrictCode: "S12000033",
+		AdminWardCode:     "S13002842",
+	}, cp.Attributes)
+
+	// Bad positional quality
+	rec = []string{"AB10 1AB", "x", "394235", "806529", "S92000003", "", "S08000020", "", "S12000033", "S13002842"}
+	_, err = fromCodePointCSV(rec, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "positional quality")
 
 	// Bad easting
 	rec = []string{"PC1", "PC2", "bad", "456"}
@@ -0,0 +1,233 @@
+package spatialindex
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tidwall/rtree"
+)
+
+// A snapshot is a compact binary copy of an RtreeSpatialIndex, so the server
+// can start without downloading and parsing the CodePoint Open zip. Layout
+// (integers are little-endian, strings are prefixed with a uvarint length):
+//
+//	magic      "PCSI"
+//	version    uint16
+//	source     string   where the codepoints were loaded from
+//	built      int64    unix time the snapshot was written
+//	tables     numCodes x (uvarint count, count x string)
+//	records    uvarint count, count x (postcode string, easting uint32,
+//	           northing uint32, quality uint8, numCodes x uint16)
+//	checksum   uint32   CRC-32 (IEEE) of everything before it
+
+const SNAPSHOT_VERSION = 2
+
+var snapshotMagic = []byte("PCSI")
+
+var ErrSnapshotVersion = errors.New("snapshot was written by an incompatible version")
+var ErrSnapshotChecksum = errors.New("snapshot checksum does not match, file may be corrupt")
+
+// SnapshotInfo describes where a snapshot's data came from.
+type SnapshotInfo struct {
+	Source string
+	Built  time.Time
+}
+
+// WriteSnapshot saves the index to path, replacing any existing snapshot
+// atomically so that a running server never sees a partly written file.
+func WriteSnapshot(idx SpatialIndex, path string, source string) error {
+	rtreeIdx, ok := idx.(*RtreeSpatialIndex)
+	if !ok {
+		return fmt.Errorf("snapshots are not supported for %T", idx)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }() // No-op once renamed
+
+	checksum := crc32.NewIEEE()
+	w := bufio.NewWriter(io.MultiWriter(tmp, checksum))
+	rtreeIdx.encode(w, SnapshotInfo{Source: source, Built: time.Now()})
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := binary.Write(tmp, binary.LittleEndian, checksum.Sum32()); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write snapshot checksum: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close snapshot file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to move snapshot into place: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot reads an index back from a snapshot written by WriteSnapshot,
+// after checking its version and checksum.
+func LoadSnapshot(path string) (SpatialIndex, SnapshotInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, SnapshotInfo{}, err
+	}
+
+	if len(data) < len(snapshotMagic)+2+4 || !bytes.Equal(data[:len(snapshotMagic)], snapshotMagic) {
+		return nil, SnapshotInfo{}, fmt.Errorf("%s is not a spatial index snapshot", path)
+	}
+	if version := binary.LittleEndian.Uint16(data[len(snapshotMagic):]); version != SNAPSHOT_VERSION {
+		return nil, SnapshotInfo{}, fmt.Errorf("%w (version %d, expected %d)", ErrSnapshotVersion, version, SNAPSHOT_VERSION)
+	}
+
+	body, trailer := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(trailer) {
+		return nil, SnapshotInfo{}, ErrSnapshotChecksum
+	}
+
+	idx := &RtreeSpatialIndex{
+		tree:      &rtree.RTreeGN[uint32, string]{},
+		postcodes: make(map[string]codePointRecord),
+		codes:     newCodeTables(),
+	}
+	r := &snapshotReader{data: body[len(snapshotMagic)+2:]}
+	info := idx.decode(r)
+	if r.err != nil {
+		return nil, SnapshotInfo{}, fmt.Errorf("failed to read snapshot: %w", r.err)
+	}
+	idx.sortPostcodes()
+	return idx, info, nil
+}
+
+func (idx *RtreeSpatialIndex) encode(w *bufio.Writer, info SnapshotInfo) {
+	buf := make([]byte, 0, 64)
+	appendString := func(b []byte, s string) []byte {
+		return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+	}
+
+	buf = append(buf, snapshotMagic...)
+	buf = binary.LittleEndian.AppendUint16(buf, SNAPSHOT_VERSION)
+	buf = appendString(buf, info.Source)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(info.Built.Unix()))
+	_, _ = w.Write(buf)
+
+	for _, table := range idx.codes {
+		buf = binary.AppendUvarint(buf[:0], uint64(len(table.values)))
+		for _, value := range table.values {
+			buf = appendString(buf, value)
+		}
+		_, _ = w.Write(buf)
+	}
+
+	buf = binary.AppendUvarint(buf[:0], uint64(len(idx.postcodes)))
+	_, _ = w.Write(buf)
+	for _, record := range idx.postcodes {
+		buf = appendString(buf[:0], record.postcode)
+		buf = binary.LittleEndian.AppendUint32(buf, record.easting)
+		buf = binary.LittleEndian.AppendUint32(buf, record.northing)
+		buf = append(buf, record.quality)
+		for _, code := range record.codes {
+			buf = binary.LittleEndian.AppendUint16(buf, code)
+		}
+		_, _ = w.Write(buf)
+	}
+}
+
+func (idx *RtreeSpatialIndex) decode(r *snapshotReader) SnapshotInfo {
+	info := SnapshotInfo{Source: r.string(), Built: time.Unix(int64(r.uint64()), 0)}
+
+	for _, table := range idx.codes {
+		count := r.uvarint()
+		for i := uint64(0); i < count && r.err == nil; i++ {
+			if _, err := table.intern(r.string()); err != nil {
+				r.err = err
+			}
+		}
+	}
+
+	count := r.uvarint()
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		record := codePointRecord{
+			postcode: r.string(),
+			easting:  r.uint32(),
+			northing: r.uint32(),
+			quality:  r.uint8(),
+		}
+		for j := range record.codes {
+			record.codes[j] = r.uint16()
+			if r.err == nil && int(record.codes[j]) >= len(idx.codes[j].values) {
+				r.err = fmt.Errorf("code %d out of range for postcode %s", record.codes[j], record.postcode)
+			}
+		}
+		if r.err != nil {
+			break
+		}
+
+		point := [2]uint32{record.easting, record.northing}
+		idx.tree.Insert(point, point, record.postcode)
+		idx.postcodes[postcodeKey(record.postcode)] = record
+	}
+
+	if r.err == nil && len(r.data) > 0 {
+		r.err = fmt.Errorf("%d unexpected bytes at end of snapshot", len(r.data))
+	}
+	return info
+}
+
+// snapshotReader decodes values from the snapshot, recording the first error
+// rather than returning it from every call.
+type snapshotReader struct {
+	data []byte
+	err  error
+}
+
+func (r *snapshotReader) next(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if len(r.data) < n {
+		r.err = io.ErrUnexpectedEOF
+		return make([]byte, n)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *snapshotReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *snapshotReader) string() string {
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		r.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+func (r *snapshotReader) uint8() uint8   { return r.next(1)[0] }
+func (r *snapshotReader) uint16() uint16 { return binary.LittleEndian.Uint16(r.next(2)) }
+func (r *snapshotReader) uint32() uint32 { return binary.LittleEndian.Uint32(r.next(4)) }
+func (r *snapshotReader) uint64() uint64 { return binary.LittleEndian.Uint64(r.next(8)) }
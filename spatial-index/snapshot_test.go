@@ -0,0 +1,96 @@
+package spatialindex
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func snapshotTestIndex(t *testing.T) SpatialIndex {
+	t.Helper()
+	csv := "AB10 1AB,10,394235,806529,S92000003,,S08000020,,S12000033,S13002842\n" +
+		"TR26 1AB,10,351670,40380,E92000001,E19000001,E18000010,E10000008,E07000045,E05011936\n" +
+		"PC1,10,100,200,,,,,,\n"
+	zipPath := createTestZip(t, map[string]string{"Data/CSV/test.csv": csv})
+	t.Cleanup(func() { _ = os.Remove(zipPath) })
+
+	idx, err := NewCodePointSpatialIndex(zipPath)
+	require.NoError(t, err)
+	return idx
+}
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	idx := snapshotTestIndex(t)
+	path := filepath.Join(t.TempDir(), "codepoint.idx")
+
+	require.NoError(t, WriteSnapshot(idx, path, "codepo_gb.zip"))
+
+	loaded, info, err := LoadSnapshot(path)
+	require.NoError(t, err)
+	require.Equal(t, "codepo_gb.zip", info.Source)
+	require.False(t, info.Built.IsZero())
+	require.Equal(t, idx.Len(), loaded.Len())
+
+	for _, postcode := range []string{"AB10 1AB", "TR26 1AB", "PC1"} {
+		expected, _ := idx.Lookup(postcode)
+		actual, found := loaded.Lookup(postcode)
+		require.True(t, found, postcode)
+		require.Equal(t, expected, actual)
+	}
+
+	completions, err := loaded.Autocomplete("TR2", 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(*completions))
+	require.Equal(t, "TR26 1AB", (*completions)[0].PostCode)
+
+	res, err := loaded.Search([]uint32{351000, 40000, 352000, 41000})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(*res))
+	require.Equal(t, "E07000045", (*res)[0].AdminDistrictCode)
+
+	// No temporary files are left behind
+	files, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+func TestLoadSnapshot_Missing(t *testing.T) {
+	_, _, err := LoadSnapshot(filepath.Join(t.TempDir(), "missing.idx"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestLoadSnapshot_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "codepoint.idx")
+	require.NoError(t, WriteSnapshot(snapshotTestIndex(t), path, "codepo_gb.zip"))
+	valid, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		modify      func(data []byte) []byte
+		errContains string
+	}{
+		{name: "not a snapshot", modify: func(data []byte) []byte { return []byte("PK\x03\x04 zip file") }, errContains: "is not a spatial index snapshot"},
+		{name: "wrong version", modify: func(data []byte) []byte { data[4] = 99; return data }, errContains: "incompatible version"},
+		{name: "corrupt", modify: func(data []byte) []byte { data[len(data)/2] ^= 0xff; return data }, errContains: "checksum does not match"},
+		{name: "truncated", modify: func(data []byte) []byte { return data[:len(data)-10] }, errContains: "checksum does not match"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := tc.modify(append([]byte(nil), valid...))
+			require.NoError(t, os.WriteFile(path, data, 0o644))
+
+			_, _, err := LoadSnapshot(path)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.errContains)
+		})
+	}
+}
+
+func TestWriteSnapshot_Unsupported(t *testing.T) {
+	err := WriteSnapshot(nil, filepath.Join(t.TempDir(), "codepoint.idx"), "")
+	require.Error(t, err)
+}
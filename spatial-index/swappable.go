@@ -0,0 +1,64 @@
+package spatialindex
+
+import "sync/atomic"
+
+// SwappableSpatialIndex serves requests from an underlying index which can be
+// replaced at any time, e.g. when newer CodePoint data has been loaded. Each
+// method uses whichever index is current when it's called, so a request that
+// makes several calls should take the index with Current first, and use that
+// throughout.
+type SwappableSpatialIndex struct {
+	current atomic.Pointer[SpatialIndex]
+}
+
+func NewSwappableSpatialIndex(idx SpatialIndex) *SwappableSpatialIndex {
+	s := &SwappableSpatialIndex{}
+	s.Swap(idx)
+	return s
+}
+
+// Swap replaces the underlying index, returning the previous one.
+func (s *SwappableSpatialIndex) Swap(idx SpatialIndex) SpatialIndex {
+	previous := s.current.Swap(&idx)
+	if previous == nil {
+		return nil
+	}
+	return *previous
+}
+
+func (s *SwappableSpatialIndex) Current() SpatialIndex {
+	return *s.current.Load()
+}
+
+// Current returns the index to serve a request from: the current underlying
+// index of a SwappableSpatialIndex, or otherwise the index itself.
+func Current(idx SpatialIndex) SpatialIndex {
+	if swappable, ok := idx.(*SwappableSpatialIndex); ok {
+		return swappable.Current()
+	}
+	return idx
+}
+
+func (s *SwappableSpatialIndex) Search(bounds []uint32) (*[]CodePoint, error) {
+	return s.Current().Search(bounds)
+}
+
+func (s *SwappableSpatialIndex) SearchIter(bounds []uint32, iter func(min, max [2]uint32, data string) bool) error {
+	return s.Current().SearchIter(bounds, iter)
+}
+
+func (s *SwappableSpatialIndex) Lookup(postcode string) (*CodePoint, bool) {
+	return s.Current().Lookup(postcode)
+}
+
+func (s *SwappableSpatialIndex) Nearest(easting, northing float64, k int, maxDistance float64, filter Filter) (*[]NearestCodePoint, error) {
+	return s.Current().Nearest(easting, northing, k, maxDistance, filter)
+}
+
+func (s *SwappableSpatialIndex) Autocomplete(query string, limit int) (*[]CodePoint, error) {
+	return s.Current().Autocomplete(query, limit)
+}
+
+func (s *SwappableSpatialIndex) Len() int {
+	return s.Current().Len()
+}
@@ -0,0 +1,48 @@
+package spatialindex
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwappableSpatialIndex(t *testing.T) {
+	buildIndex := func(csv string) SpatialIndex {
+		zipPath := createTestZip(t, map[string]string{"Data/CSV/test.csv": csv})
+		defer func() { _ = os.Remove(zipPath) }()
+		idx, err := NewCodePointSpatialIndex(zipPath)
+		require.NoError(t, err)
+		return idx
+	}
+
+	original := buildIndex("PC1,10,100,200\n")
+	updated := buildIndex("PC1,10,150,250\nPC2,10,300,400\n")
+
+	idx := NewSwappableSpatialIndex(original)
+	require.Equal(t, original, Current(idx))
+	require.Equal(t, original, Current(original))
+	require.Equal(t, 1, idx.Len())
+	cp, found := idx.Lookup("PC1")
+	require.True(t, found)
+	require.Equal(t, uint32(100), cp.Easting)
+
+	// A request that took the index before the swap keeps using it
+	inProgress := Current(idx)
+	require.Equal(t, original, idx.Swap(updated))
+	require.Equal(t, updated, idx.Current())
+	_, found = inProgress.Lookup("PC2")
+	require.False(t, found)
+	require.Equal(t, 2, idx.Len())
+	cp, found = idx.Lookup("PC1")
+	require.True(t, found)
+	require.Equal(t, uint32(150), cp.Easting)
+
+	res, err := idx.Search([]uint32{0, 0, 500, 500})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(*res))
+
+	nearest, err := idx.Nearest(300, 400, 1, 0, nil)
+	require.NoError(t, err)
+	require.Equal(t, "PC2", (*nearest)[0].PostCode)
+}
@@ -0,0 +1,72 @@
+package tiles
+
+import (
+	"maps"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/simplify"
+)
+
+// Polygon layers are encoded as Mapbox Vector Tiles (v2.1), see
+// https://github.com/mapbox/vector-tile-spec/tree/master/2.1
+
+const EXTENT = mvt.DefaultExtent // Tile coordinate space
+const BUFFER = 64                // Geometry is clipped this many tile units beyond the tile edges
+
+const MIME_TYPE = "application/vnd.mapbox-vector-tile"
+
+// Encode renders the polygon features as a single named layer of a vector
+// tile. Each feature's ID is carried in an "id" property, as MVT only allows
+// numeric feature IDs.
+func Encode(tile maptile.Tile, name string, fc *geojson.FeatureCollection) ([]byte, error) {
+	features := make([]*geojson.Feature, 0, len(fc.Features))
+	for _, feature := range fc.Features {
+		if feature.Geometry == nil {
+			continue
+		}
+
+		// The layer is projected and clipped in place, and features may be shared
+		f := geojson.NewFeature(orb.Clone(feature.Geometry))
+		maps.Copy(f.Properties, feature.Properties)
+		if feature.ID != nil {
+			f.Properties["id"] = feature.ID
+		}
+		features = append(features, f)
+	}
+
+	layer := mvt.NewLayer(name, &geojson.FeatureCollection{Features: features})
+	layer.Version = 2
+	layer.ProjectToTile(tile)
+	layer.Clip(orb.Bound{Min: orb.Point{-BUFFER, -BUFFER}, Max: orb.Point{EXTENT + BUFFER, EXTENT + BUFFER}})
+	layer.Simplify(simplify.DouglasPeucker(1))
+	layer.RemoveEmpty(1, 1)
+	for _, f := range layer.Features {
+		orient(f.Geometry)
+	}
+
+	return mvt.Marshal(mvt.Layers{layer})
+}
+
+// orient winds exterior rings so that they have a positive area in tile
+// coordinates (clockwise with the Y axis pointing down), and interior rings
+// the other way, as the spec requires.
+func orient(geometry orb.Geometry) {
+	var polygons []orb.Polygon
+	switch g := geometry.(type) {
+	case orb.Polygon:
+		polygons = []orb.Polygon{g}
+	case orb.MultiPolygon:
+		polygons = g
+	}
+
+	for _, polygon := range polygons {
+		for i, ring := range polygon {
+			if (i == 0) != (ring.Orientation() == orb.CCW) {
+				ring.Reverse()
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package tiles
+
+import (
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/stretchr/testify/require"
+)
+
+// decode encodes the features as a tile and reads its single layer back, in
+// tile coordinates
+func decode(t *testing.T, tile maptile.Tile, fc *geojson.FeatureCollection) *mvt.Layer {
+	t.Helper()
+	data, err := Encode(tile, "postcodes", fc)
+	require.NoError(t, err)
+	layers, err := mvt.Unmarshal(data)
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+	return layers[0]
+}
+
+func TestEncode(t *testing.T) {
+	tile := maptile.New(8148, 5396, 14)
+	bound := tile.Bound()
+	center := bound.Center()
+
+	// A square covering the middle of the tile, with anti-clockwise winding
+	// as per GeoJSON
+	inner := orb.Bound{
+		Min: orb.Point{(bound.Min.Lon() + center.Lon()) / 2, (bound.Min.Lat() + center.Lat()) / 2},
+		Max: orb.Point{(bound.Max.Lon() + center.Lon()) / 2, (bound.Max.Lat() + center.Lat()) / 2},
+	}
+	feature := geojson.NewFeature(inner.ToPolygon())
+	feature.ID = "TR26 1AB"
+	feature.Properties["type"] = "unit"
+
+	outside := geojson.NewFeature(orb.Bound{Min: orb.Point{10, 10}, Max: orb.Point{11, 11}}.ToPolygon())
+	outside.ID = "ZZ1 1ZZ"
+
+	fc := geojson.NewFeatureCollection()
+	fc.Append(feature)
+	fc.Append(outside)
+
+	layer := decode(t, tile, fc)
+	require.Equal(t, "postcodes", layer.Name)
+	require.Equal(t, uint32(2), layer.Version)
+	require.Equal(t, uint32(EXTENT), layer.Extent)
+	require.Len(t, layer.Features, 1, "features outside of the tile should be dropped")
+
+	f := layer.Features[0]
+	require.Equal(t, geojson.Properties{"id": "TR26 1AB", "type": "unit"}, f.Properties)
+
+	polygon, ok := f.Geometry.(orb.Polygon)
+	require.True(t, ok)
+	require.Len(t, polygon, 1)
+	require.Len(t, polygon[0], 5)
+	require.Equal(t, orb.CCW, polygon[0].Orientation(), "exterior ring should have a positive area")
+	for _, p := range polygon[0] {
+		require.True(t, p[0] >= 1023 && p[0] <= 3073 && p[1] >= 1023 && p[1] <= 3073, "p=%v", p)
+	}
+
+	// The original feature must be left untouched
+	require.Equal(t, inner.ToPolygon(), feature.Geometry)
+	require.Equal(t, geojson.Properties{"type": "unit"}, feature.Properties)
+}
+
+func TestEncode_Holes(t *testing.T) {
+	tile := maptile.New(8148, 5396, 14)
+	bound := tile.Bound()
+	center := bound.Center()
+	hole := orb.Bound{
+		Min: orb.Point{(bound.Min.Lon() + center.Lon()) / 2, (bound.Min.Lat() + center.Lat()) / 2},
+		Max: orb.Point{(bound.Max.Lon() + center.Lon()) / 2, (bound.Max.Lat() + center.Lat()) / 2},
+	}.ToPolygon()[0]
+
+	// Both rings wound the same way, which the spec doesn't allow
+	fc := geojson.NewFeatureCollection()
+	fc.Append(geojson.NewFeature(orb.Polygon{bound.ToPolygon()[0], hole}))
+
+	polygon := decode(t, tile, fc).Features[0].Geometry.(orb.Polygon)
+	require.Len(t, polygon, 2)
+	require.Equal(t, orb.CCW, polygon[0].Orientation())
+	require.Equal(t, orb.CW, polygon[1].Orientation())
+}
+
+func TestEncode_ClipsToBuffer(t *testing.T) {
+	tile := maptile.New(8148, 5396, 14)
+	feature := geojson.NewFeature(tile.Bound(1).ToPolygon())
+	fc := geojson.NewFeatureCollection()
+	fc.Append(feature)
+
+	polygon := decode(t, tile, fc).Features[0].Geometry.(orb.Polygon)
+	for _, p := range polygon[0] {
+		require.True(t, p[0] >= -BUFFER && p[0] <= EXTENT+BUFFER, "x=%v", p[0])
+		require.True(t, p[1] >= -BUFFER && p[1] <= EXTENT+BUFFER, "y=%v", p[1])
+	}
+
+	// The original geometry must be left untouched
+	require.Equal(t, tile.Bound(1).ToPolygon(), feature.Geometry)
+}
+
+func TestEncode_DropsDegenerateRings(t *testing.T) {
+	tile := maptile.New(0, 0, 14)
+	center := tile.Center()
+	tiny := orb.Bound{Min: center, Max: orb.Point{center.Lon() + 1e-9, center.Lat() + 1e-9}}
+
+	fc := geojson.NewFeatureCollection()
+	fc.Append(geojson.NewFeature(tiny.ToPolygon()))
+
+	require.Empty(t, decode(t, tile, fc).Features)
+}
@@ -0,0 +1,110 @@
+package topology
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+const MIME_TYPE = "application/topo+json"
+
+type topoJSON struct {
+	Type      string                `json:"type"`
+	Transform *transform            `json:"transform,omitempty"`
+	Objects   map[string]collection `json:"objects"`
+	Arcs      any                   `json:"arcs"`
+}
+
+type transform struct {
+	Scale     [2]float64 `json:"scale"`
+	Translate [2]float64 `json:"translate"`
+}
+
+type collection struct {
+	Type       string     `json:"type"`
+	Geometries []geometry `json:"geometries"`
+}
+
+type geometry struct {
+	Type       string             `json:"type"`
+	ID         any                `json:"id,omitempty"`
+	Properties geojson.Properties `json:"properties,omitempty"`
+	Arcs       any                `json:"arcs"`
+}
+
+// TopoJSON encodes the topology as a TopoJSON document, with the features as
+// a single named GeometryCollection object. If quantization is more than 1,
+// the arcs are quantized to a grid of that many positions across each axis,
+// and delta-encoded, which makes the document much smaller. Otherwise the
+// arcs keep their exact coordinates.
+func (t *Topology) TopoJSON(name string, quantization int) ([]byte, error) {
+	geometries := make([]geometry, 0, len(t.Features))
+	for _, feat := range t.Features {
+		if len(feat.Polygons) == 0 {
+			continue
+		}
+		g := geometry{Type: "MultiPolygon", ID: feat.ID, Properties: feat.Properties, Arcs: feat.Polygons}
+		if !feat.Multi && len(feat.Polygons) == 1 {
+			g.Type, g.Arcs = "Polygon", feat.Polygons[0]
+		}
+		geometries = append(geometries, g)
+	}
+
+	doc := topoJSON{
+		Type:    "Topology",
+		Objects: map[string]collection{name: {Type: "GeometryCollection", Geometries: geometries}},
+		Arcs:    t.Arcs,
+	}
+	if quantization > 1 && len(t.Arcs) > 0 {
+		doc.Transform, doc.Arcs = t.quantize(quantization)
+	}
+	return json.Marshal(doc)
+}
+
+// quantize converts the arcs to integer positions on a grid spanning their
+// bounds, each position after the first in an arc given relative to the one
+// before it.
+func (t *Topology) quantize(quantization int) (*transform, [][][2]int) {
+	bound := t.Arcs[0].Bound()
+	for _, arc := range t.Arcs[1:] {
+		bound = bound.Union(arc.Bound())
+	}
+
+	tr := &transform{Scale: [2]float64{1, 1}, Translate: [2]float64{bound.Min.X(), bound.Min.Y()}}
+	if width := bound.Max.X() - bound.Min.X(); width > 0 {
+		tr.Scale[0] = width / float64(quantization-1)
+	}
+	if height := bound.Max.Y() - bound.Min.Y(); height > 0 {
+		tr.Scale[1] = height / float64(quantization-1)
+	}
+
+	arcs := make([][][2]int, len(t.Arcs))
+	for i, arc := range t.Arcs {
+		quantized := make([][2]int, 0, len(arc))
+		var previous [2]int
+		for _, point := range arc {
+			position := tr.position(point)
+			// Points that are closer together than the grid are merged
+			if len(quantized) > 0 && position == previous {
+				continue
+			}
+			quantized = append(quantized, [2]int{position[0] - previous[0], position[1] - previous[1]})
+			previous = position
+		}
+		// Every arc needs at least two positions, even if they're the same
+		if len(quantized) == 1 {
+			quantized = append(quantized, [2]int{0, 0})
+		}
+		arcs[i] = quantized
+	}
+	return tr, arcs
+}
+
+func (tr *transform) position(point orb.Point) [2]int {
+	return [2]int{
+		int(math.Round((point.X() - tr.Translate[0]) / tr.Scale[0])),
+		int(math.Round((point.Y() - tr.Translate[1]) / tr.Scale[1])),
+	}
+}
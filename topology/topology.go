@@ -0,0 +1,295 @@
+package topology
+
+import (
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/simplify"
+)
+
+// A topology describes a set of polygons by the arcs (lines) that make up
+// their boundaries, as in TopoJSON. Each boundary shared between neighbouring
+// polygons is a single arc, so it can be simplified once and the neighbours
+// still meet exactly, without the gaps and slivers that appear when every
+// polygon is simplified independently. See
+// https://github.com/topojson/topojson-specification
+
+// Topology holds the arcs, and the features whose rings are made up of them.
+type Topology struct {
+	Arcs     []orb.LineString
+	Features []*Feature
+}
+
+// Feature is a polygonal feature with its rings given as references to arcs.
+// A reference is an index into Topology.Arcs, or for an arc that is traversed
+// backwards, the ones' complement of the index (i.e. ^i, or -1 for arc 0).
+type Feature struct {
+	ID         any
+	Properties geojson.Properties
+	Polygons   [][][]int // Polygon -> ring -> arc references
+	Multi      bool      // Whether the original geometry was a MultiPolygon
+}
+
+// Build constructs the topology of the polygon and multipolygon features in
+// the collection. It relies on neighbouring polygons having exactly the same
+// vertices along their shared boundaries. Features with any other type of
+// geometry are left out.
+func Build(fc *geojson.FeatureCollection) *Topology {
+	var polygons [][]orb.Polygon
+	var features []*Feature
+	for _, feature := range fc.Features {
+		var feat *Feature
+		switch geometry := feature.Geometry.(type) {
+		case orb.Polygon:
+			feat = &Feature{ID: feature.ID, Properties: feature.Properties}
+			polygons = append(polygons, []orb.Polygon{geometry})
+		case orb.MultiPolygon:
+			feat = &Feature{ID: feature.ID, Properties: feature.Properties, Multi: true}
+			polygons = append(polygons, geometry)
+		default:
+			continue
+		}
+		features = append(features, feat)
+	}
+
+	b := newBuilder()
+	for _, featurePolygons := range polygons {
+		for _, polygon := range featurePolygons {
+			for _, ring := range polygon {
+				b.join(ring)
+			}
+		}
+	}
+
+	for i, featurePolygons := range polygons {
+		for _, polygon := range featurePolygons {
+			rings := make([][]int, 0, len(polygon))
+			for _, ring := range polygon {
+				if arcs := b.cut(ring); len(arcs) > 0 {
+					rings = append(rings, arcs)
+				}
+			}
+			if len(rings) > 0 {
+				features[i].Polygons = append(features[i].Polygons, rings)
+			}
+		}
+	}
+
+	return &Topology{Arcs: b.arcs, Features: features}
+}
+
+// Simplify simplifies every arc with the Douglas-Peucker algorithm. The ends
+// of an arc are where it meets other arcs, so are always kept.
+func (t *Topology) Simplify(tolerance float64) {
+	for i, arc := range t.Arcs {
+		closed := len(arc) > 1 && arc[0] == arc[len(arc)-1]
+		simplified := simplify.DouglasPeucker(tolerance).LineString(orb.Clone(arc).(orb.LineString))
+
+		// A closed arc is a whole ring (e.g. an island), which must keep enough
+		// points to enclose an area, so is simplified less until it does
+		for lower := tolerance / 2; closed && len(simplified) < 4 && lower > tolerance/1024; lower /= 2 {
+			simplified = simplify.DouglasPeucker(lower).LineString(orb.Clone(arc).(orb.LineString))
+		}
+		if closed && len(simplified) < 4 {
+			continue
+		}
+		t.Arcs[i] = simplified
+	}
+}
+
+// FeatureCollection converts the topology back to GeoJSON features. Any rings
+// which no longer enclose an area (e.g. after simplification) are dropped, as
+// are polygons without an exterior ring and features without any polygons.
+func (t *Topology) FeatureCollection() *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+	fc.Features = make([]*geojson.Feature, 0, len(t.Features))
+
+	for _, feat := range t.Features {
+		var multiPolygon orb.MultiPolygon
+		for _, rings := range feat.Polygons {
+			var polygon orb.Polygon
+			for i, arcs := range rings {
+				ring := t.ring(arcs)
+				if len(ring) < 4 {
+					if i == 0 {
+						break // No exterior, so no polygon
+					}
+					continue
+				}
+				polygon = append(polygon, ring)
+			}
+			if len(polygon) > 0 {
+				multiPolygon = append(multiPolygon, polygon)
+			}
+		}
+		if len(multiPolygon) == 0 {
+			continue
+		}
+
+		var geometry orb.Geometry = multiPolygon
+		if !feat.Multi && len(multiPolygon) == 1 {
+			geometry = multiPolygon[0]
+		}
+		feature := geojson.NewFeature(geometry)
+		feature.ID = feat.ID
+		feature.Properties = feat.Properties.Clone()
+		fc.Append(feature)
+	}
+	return fc
+}
+
+// ring joins up the arcs, where each one starts at the end of the previous.
+func (t *Topology) ring(refs []int) orb.Ring {
+	var ring orb.Ring
+	for _, ref := range refs {
+		arc := t.arc(ref)
+		if len(ring) > 0 {
+			arc = arc[1:]
+		}
+		for _, point := range arc {
+			// Simplification can leave the same point twice in a row
+			if len(ring) == 0 || ring[len(ring)-1] != point {
+				ring = append(ring, point)
+			}
+		}
+	}
+	return ring
+}
+
+// arc returns the points of an arc reference, in the direction referenced.
+func (t *Topology) arc(ref int) orb.LineString {
+	if ref >= 0 {
+		return t.Arcs[ref]
+	}
+	arc := orb.Clone(t.Arcs[^ref]).(orb.LineString)
+	arc.Reverse()
+	return arc
+}
+
+// neighbours are the points either side of a vertex on a ring.
+type neighbours struct {
+	prev, next orb.Point
+}
+
+type builder struct {
+	seen      map[orb.Point]neighbours
+	junctions map[orb.Point]bool
+	arcs      []orb.LineString
+	byStart   map[orb.Point][]int // Arcs indexed by their first point
+}
+
+func newBuilder() *builder {
+	return &builder{
+		seen:      make(map[orb.Point]neighbours),
+		junctions: make(map[orb.Point]bool),
+		byStart:   make(map[orb.Point][]int),
+	}
+}
+
+// join finds the junctions on a ring, i.e. its vertices where the boundary
+// stops being shared with one neighbour, or starts being shared with another.
+// These are the points where the same vertex appears elsewhere with different
+// points either side of it.
+func (b *builder) join(ring orb.Ring) {
+	n := len(ring) - 1 // The last point repeats the first
+	if n < 3 {
+		return
+	}
+	for i := 0; i < n; i++ {
+		point := ring[i]
+		around := neighbours{prev: ring[(i+n-1)%n], next: ring[i+1]}
+		previous, seen := b.seen[point]
+		if !seen {
+			b.seen[point] = around
+			continue
+		}
+		reversed := neighbours{prev: around.next, next: around.prev}
+		if previous != around && previous != reversed {
+			b.junctions[point] = true
+		}
+	}
+}
+
+// cut splits a ring into arcs at its junctions, returning references to the
+// (possibly already existing) arcs.
+func (b *builder) cut(ring orb.Ring) []int {
+	n := len(ring) - 1
+	if n < 3 {
+		return nil
+	}
+
+	start := -1
+	for i := 0; i < n; i++ {
+		if b.junctions[ring[i]] {
+			start = i
+			break
+		}
+	}
+
+	// A ring without junctions is a single closed arc. It starts from its
+	// smallest point, so that its neighbour (if any) finds the same arc.
+	if start < 0 {
+		start = 0
+		for i := 1; i < n; i++ {
+			if less(ring[i], ring[start]) {
+				start = i
+			}
+		}
+		arc := make(orb.LineString, 0, n+1)
+		for i := 0; i <= n; i++ {
+			arc = append(arc, ring[(start+i)%n])
+		}
+		return []int{b.add(arc)}
+	}
+
+	var refs []int
+	arc := orb.LineString{ring[start]}
+	for i := 1; i <= n; i++ {
+		point := ring[(start+i)%n]
+		arc = append(arc, point)
+		if b.junctions[point] {
+			refs = append(refs, b.add(arc))
+			arc = orb.LineString{point}
+		}
+	}
+	return refs
+}
+
+// add returns a reference to an arc with the same points (in either
+// direction), adding it if there isn't one already.
+func (b *builder) add(arc orb.LineString) int {
+	for _, i := range b.byStart[arc[0]] {
+		if equal(b.arcs[i], arc, false) {
+			return i
+		}
+	}
+	for _, i := range b.byStart[arc[len(arc)-1]] {
+		if equal(b.arcs[i], arc, true) {
+			return ^i
+		}
+	}
+
+	i := len(b.arcs)
+	b.arcs = append(b.arcs, arc)
+	b.byStart[arc[0]] = append(b.byStart[arc[0]], i)
+	return i
+}
+
+func equal(a, b orb.LineString, reversed bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		j := i
+		if reversed {
+			j = len(b) - 1 - i
+		}
+		if a[i] != b[j] {
+			return false
+		}
+	}
+	return true
+}
+
+func less(a, b orb.Point) bool {
+	return a[0] < b[0] || (a[0] == b[0] && a[1] < b[1])
+}
@@ -0,0 +1,156 @@
+package topology
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/stretchr/testify/require"
+)
+
+// Two squares side by side, sharing the edge from (1,0) to (1,1), which has
+// a small kink in it at (1.001,0.5)
+func adjacentSquares() *geojson.FeatureCollection {
+	left := geojson.NewFeature(orb.Polygon{{{0, 0}, {1, 0}, {1.001, 0.5}, {1, 1}, {0, 1}, {0, 0}}})
+	left.ID = "TR26 1AA"
+	left.Properties["type"] = "unit"
+	right := geojson.NewFeature(orb.Polygon{{{1, 0}, {2, 0}, {2, 1}, {1, 1}, {1.001, 0.5}, {1, 0}}})
+	right.ID = "TR26 1AB"
+	right.Properties["type"] = "unit"
+
+	fc := geojson.NewFeatureCollection()
+	fc.Append(left)
+	fc.Append(right)
+	return fc
+}
+
+func TestBuild_SharedArcs(t *testing.T) {
+	topo := Build(adjacentSquares())
+
+	// The shared edge, plus the rest of each square
+	require.Len(t, topo.Arcs, 3)
+	require.Len(t, topo.Features, 2)
+	require.Equal(t, [][][]int{{{0, 1}}}, topo.Features[0].Polygons)
+	require.Equal(t, [][][]int{{{2, ^0}}}, topo.Features[1].Polygons)
+	require.Equal(t, orb.LineString{{1, 0}, {1.001, 0.5}, {1, 1}}, topo.Arcs[0])
+}
+
+func TestBuild_RoundTrip(t *testing.T) {
+	fc := adjacentSquares()
+	hole := orb.Ring{{0.2, 0.2}, {0.2, 0.4}, {0.4, 0.4}, {0.4, 0.2}, {0.2, 0.2}}
+	fc.Features[0].Geometry = append(fc.Features[0].Geometry.(orb.Polygon), hole)
+	island := geojson.NewFeature(orb.MultiPolygon{{{{0.4, 0.2}, {0.4, 0.4}, {0.2, 0.4}, {0.2, 0.2}, {0.4, 0.2}}}})
+	island.ID = "TR26 1AD"
+	fc.Append(island)
+
+	topo := Build(fc)
+	rebuilt := topo.FeatureCollection()
+
+	require.Len(t, rebuilt.Features, 3)
+	for i, feature := range rebuilt.Features {
+		require.Equal(t, fc.Features[i].ID, feature.ID)
+		require.Equal(t, fc.Features[i].Properties, feature.Properties)
+		require.Equal(t, fc.Features[i].Geometry.GeoJSONType(), feature.Geometry.GeoJSONType())
+		require.Equal(t, fc.Features[i].Geometry.Bound(), feature.Geometry.Bound())
+	}
+
+	// The hole and the island are the same closed arc
+	require.Len(t, topo.Arcs, 4)
+}
+
+func TestSimplify_KeepsNeighboursTogether(t *testing.T) {
+	topo := Build(adjacentSquares())
+	topo.Simplify(0.01)
+	fc := topo.FeatureCollection()
+
+	// The kink is removed from both sides of the shared edge. Rings now start
+	// from a junction.
+	require.Equal(t, orb.Polygon{{{1, 0}, {1, 1}, {0, 1}, {0, 0}, {1, 0}}}, fc.Features[0].Geometry)
+	require.Equal(t, orb.Polygon{{{1, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 0}}}, fc.Features[1].Geometry)
+}
+
+func TestSimplify_Collapsed(t *testing.T) {
+	fc := geojson.NewFeatureCollection()
+	// A thin triangle, split into two arcs by its neighbour
+	fc.Append(geojson.NewFeature(orb.Polygon{{{0, 0}, {1, 0}, {0.5, 0.001}, {0, 0}}}))
+	fc.Append(geojson.NewFeature(orb.Polygon{{{0, 0}, {0.5, -1}, {1, 0}, {0, 0}}}))
+
+	topo := Build(fc)
+	topo.Simplify(0.01)
+	simplified := topo.FeatureCollection()
+
+	require.Len(t, simplified.Features, 1)
+	require.Equal(t, orb.Polygon{{{0, 0}, {0.5, -1}, {1, 0}, {0, 0}}}, simplified.Features[0].Geometry)
+}
+
+func TestSimplify_ClosedArcs(t *testing.T) {
+	fc := geojson.NewFeatureCollection()
+	fc.Append(geojson.NewFeature(orb.Polygon{{{0, 0}, {0.001, 0}, {0.001, 0.001}, {0, 0.001}, {0, 0}}}))
+
+	topo := Build(fc)
+	topo.Simplify(0.01)
+
+	// Small isolated rings keep enough points to enclose an area, rather than
+	// disappearing
+	require.Equal(t, fc.Features[0].Geometry, topo.FeatureCollection().Features[0].Geometry)
+
+	fc = geojson.NewFeatureCollection()
+	fc.Append(geojson.NewFeature(orb.Polygon{{{0, 0}, {0.0005, 0}, {0.001, 0}, {0.001, 0.001}, {0, 0.001}, {0, 0}}}))
+
+	topo = Build(fc)
+	topo.Simplify(0.01)
+	require.Equal(t, orb.Polygon{{{0, 0}, {0.001, 0}, {0.001, 0.001}, {0, 0.001}, {0, 0}}}, topo.FeatureCollection().Features[0].Geometry)
+}
+
+func TestTopoJSON(t *testing.T) {
+	fc := adjacentSquares()
+	fc.Append(geojson.NewFeature(orb.Point{1, 2})) // Not polygonal, so left out
+
+	data, err := Build(fc).TopoJSON("units", 0)
+	require.NoError(t, err)
+
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(data, &doc))
+	require.Equal(t, "Topology", doc["type"])
+	require.Len(t, doc["arcs"], 3)
+
+	geometries := doc["objects"].(map[string]any)["units"].(map[string]any)["geometries"].([]any)
+	require.Len(t, geometries, 2)
+	require.Equal(t, map[string]any{
+		"type":       "Polygon",
+		"id":         "TR26 1AB",
+		"properties": map[string]any{"type": "unit"},
+		"arcs":       []any{[]any{2.0, -1.0}},
+	}, geometries[1])
+}
+
+func TestTopoJSON_Quantized(t *testing.T) {
+	data, err := Build(adjacentSquares()).TopoJSON("units", 2001)
+	require.NoError(t, err)
+
+	var doc struct {
+		Transform struct {
+			Scale     [2]float64 `json:"scale"`
+			Translate [2]float64 `json:"translate"`
+		} `json:"transform"`
+		Arcs [][][2]int `json:"arcs"`
+	}
+	require.NoError(t, json.Unmarshal(data, &doc))
+	require.InDeltaSlice(t, []float64{0.001, 0.0005}, doc.Transform.Scale[:], 1e-12)
+	require.Equal(t, [2]float64{0, 0}, doc.Transform.Translate)
+
+	// The shared edge, from (1,0) via (1.001,0.5) to (1,1), delta-encoded
+	require.Equal(t, [][2]int{{1000, 0}, {1, 1000}, {-1, 1000}}, doc.Arcs[0])
+
+	// The rest of each square joins up the ends of the shared edge
+	end := func(arc [][2]int) [2]int {
+		var x, y int
+		for _, delta := range arc {
+			x, y = x+delta[0], y+delta[1]
+		}
+		return [2]int{x, y}
+	}
+	require.Equal(t, [2]int{1000, 0}, end(doc.Arcs[1]))
+	require.Equal(t, [2]int{1000, 2000}, end(doc.Arcs[2]))
+}